@@ -2,18 +2,30 @@
 
 // Package main starts the Yadoma Docker agent. It connects to the Docker Engine,
 // initializes gRPC services for container, image, network, volume, and system domains,
-// and serves a gRPC API over TCP.
+// and serves a gRPC API over TCP (optionally secured with mutual TLS) and/or a Unix domain socket.
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
+	"io/fs"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/whiteo/yadoma/internal/auth"
 	docker "github.com/whiteo/yadoma/internal/dockers"
+	"github.com/whiteo/yadoma/internal/metrics"
+	"github.com/whiteo/yadoma/internal/server"
 	"github.com/whiteo/yadoma/internal/services/container"
 	"github.com/whiteo/yadoma/internal/services/image"
 	"github.com/whiteo/yadoma/internal/services/network"
@@ -25,6 +37,15 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// statsHistorySaveInterval is how often the stats history is saved to its file.
+	statsHistorySaveInterval = 5 * time.Minute
+	// metricsReadHeaderTimeout bounds how long a metrics client may take to send its request headers.
+	metricsReadHeaderTimeout = 10 * time.Second
 )
 
 func main() {
@@ -35,7 +56,71 @@ func main() {
 		)
 		tcpPort = flag.String("agent-tcp-port",
 			":50001",
-			"Run gRPC over TCP",
+			"Run gRPC over TCP (empty to disable)",
+		)
+		unixSocket = flag.String("agent-unix-socket",
+			envOrDefault("YADOMA_UNIX_SOCKET", ""),
+			"Also run gRPC over this Unix domain socket path (env YADOMA_UNIX_SOCKET)",
+		)
+		unixSocketMode = flag.String("agent-unix-socket-mode",
+			"0660",
+			"Octal file mode of the Unix domain socket",
+		)
+		unixSocketGroup = flag.String("agent-unix-socket-group",
+			envOrDefault("YADOMA_UNIX_SOCKET_GROUP", ""),
+			"Group name or gid owning the Unix domain socket (env YADOMA_UNIX_SOCKET_GROUP)",
+		)
+		shutdownTimeout = flag.Duration("shutdown-timeout",
+			10*time.Second,
+			"Time to drain in-flight RPCs on shutdown before open streams are canceled",
+		)
+		tlsCert = flag.String("tls-cert",
+			envOrDefault("YADOMA_TLS_CERT", ""),
+			"Path to the server TLS certificate (env YADOMA_TLS_CERT)",
+		)
+		tlsKey = flag.String("tls-key",
+			envOrDefault("YADOMA_TLS_KEY", ""),
+			"Path to the server TLS private key (env YADOMA_TLS_KEY)",
+		)
+		tlsClientCA = flag.String("tls-client-ca",
+			envOrDefault("YADOMA_TLS_CLIENT_CA", ""),
+			"Path to the CA bundle used to verify client certificates (env YADOMA_TLS_CLIENT_CA)",
+		)
+		tlsClientNames = flag.String("tls-client-names",
+			envOrDefault("YADOMA_TLS_CLIENT_NAMES", ""),
+			"Comma-separated client certificate names allowed to connect (env YADOMA_TLS_CLIENT_NAMES)",
+		)
+		tlsReloadInterval = flag.Duration("tls-reload-interval",
+			30*time.Second,
+			"How often TLS files are checked for changes",
+		)
+		authTokenFile = flag.String("auth-token-file",
+			envOrDefault("YADOMA_AUTH_TOKEN_FILE", ""),
+			"Path to a static token file with \"<token> <identity> <role>\" lines (env YADOMA_AUTH_TOKEN_FILE)",
+		)
+		authJWTKeyFile = flag.String("auth-jwt-key-file",
+			envOrDefault("YADOMA_AUTH_JWT_KEY_FILE", ""),
+			"Path to the HMAC key used to verify JWT bearer tokens (env YADOMA_AUTH_JWT_KEY_FILE)",
+		)
+		authUnixUIDs = flag.String("auth-unix-uids",
+			envOrDefault("YADOMA_AUTH_UNIX_UIDS", ""),
+			"Roles for Unix socket peers by uid, e.g. \"0=admin,1000=viewer\" (env YADOMA_AUTH_UNIX_UIDS)",
+		)
+		statsHistory = flag.String("stats-history",
+			envOrDefault("YADOMA_STATS_HISTORY", "10s:1h,1m:24h"),
+			"Stats history tiers as resolution:retention pairs, empty to disable (env YADOMA_STATS_HISTORY)",
+		)
+		statsHistoryMemory = flag.Int("stats-history-memory",
+			64,
+			"Memory budget of the stats history in MiB",
+		)
+		statsHistoryFile = flag.String("stats-history-file",
+			envOrDefault("YADOMA_STATS_HISTORY_FILE", ""),
+			"Path to save the stats history across restarts, empty to keep it in memory only (env YADOMA_STATS_HISTORY_FILE)",
+		)
+		metricsAddr = flag.String("metrics-addr",
+			envOrDefault("YADOMA_METRICS_ADDR", ""),
+			"Serve Prometheus metrics over HTTP on this address, e.g. \":9101\" (empty to disable, env YADOMA_METRICS_ADDR)",
 		)
 	)
 
@@ -71,17 +156,275 @@ func main() {
 	layer := docker.NewLayer(c)
 	log.Info().Msg("Docker layer initialized")
 
-	containerService := container.NewContainerService(layer)
-	imageService := image.NewImageService(layer)
-	networkService := network.NewNetworkService(layer)
-	volumeService := volume.NewVolumeService(layer)
-	systemService := system.NewSystemService(layer)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	creds := insecure.NewCredentials()
+	if *tlsCert != "" || *tlsKey != "" || *tlsClientCA != "" {
+		reloader, rErr := server.NewCertReloader(*tlsCert, *tlsKey, *tlsClientCA, splitList(*tlsClientNames))
+		if rErr != nil {
+			log.Error().Err(rErr).Msg("Cannot load TLS certificates")
+			return
+		}
+		go reloader.Watch(ctx, *tlsReloadInterval)
+		creds = credentials.NewTLS(reloader.TLSConfig())
+		log.Info().Str("cert", *tlsCert).Str("client_ca", *tlsClientCA).Msg("Mutual TLS enabled")
+	} else {
+		log.Warn().Msg("TLS is not configured, gRPC traffic is unencrypted and unauthenticated")
+	}
+	opts := []grpc.ServerOption{grpc.Creds(server.NewTransportCredentials(creds))}
+
+	var serverMetrics *metrics.ServerMetrics
+	if *metricsAddr != "" {
+		serverMetrics = metrics.NewServerMetrics()
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(serverMetrics.Unary()),
+			grpc.ChainStreamInterceptor(serverMetrics.Stream()),
+		)
+	}
+
+	authenticators, err := loadAuthenticators(*authTokenFile, *authJWTKeyFile)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot initialize authentication")
+		return
+	}
+	uidRoles, err := auth.ParseUIDRoles(*authUnixUIDs)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot parse Unix socket uid roles")
+		return
+	}
+	if len(authenticators) > 0 || len(uidRoles) > 0 {
+		interceptor := auth.NewInterceptor(uidRoles, authenticators...)
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(interceptor.Unary()),
+			grpc.ChainStreamInterceptor(interceptor.Stream()),
+		)
+		log.Info().
+			Int("authenticators", len(authenticators)).
+			Int("unix_uids", len(uidRoles)).
+			Msg("Authentication enabled")
+	} else {
+		log.Warn().Msg("Authentication is not configured, every caller has full access")
+	}
+
+	var history *metrics.Store
+	if *statsHistory != "" {
+		history, err = newStatsHistory(*statsHistory, *statsHistoryMemory, *statsHistoryFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Cannot initialize stats history")
+			return
+		}
+		if *statsHistoryFile != "" {
+			go history.Persist(ctx, *statsHistoryFile, statsHistorySaveInterval)
+			defer saveStatsHistory(history, *statsHistoryFile)
+		}
+		log.Info().
+			Str("tiers", *statsHistory).
+			Int("max_containers", history.MaxContainers()).
+			Msg("Stats history enabled")
+	}
+
+	containers := container.NewContainerService(layer, history)
+	go containers.RecordStats(ctx)
+
+	srv := newServer(layer, containers, *shutdownTimeout, opts...)
 	log.Info().Msg("All gRPC services initialized")
 
+	listeners, err := openListeners(*tcpPort, *unixSocket, *unixSocketMode, *unixSocketGroup)
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot open listeners")
+		return
+	}
+
+	if serverMetrics != nil {
+		metricsSrv, mErr := serveMetrics(*metricsAddr, metrics.NewHandler(layer, serverMetrics))
+		if mErr != nil {
+			log.Error().Err(mErr).Msg("Cannot start metrics server")
+			for _, lis := range listeners {
+				_ = lis.Close()
+			}
+			return
+		}
+		defer shutdownMetrics(metricsSrv, *shutdownTimeout)
+		log.Info().Str("addr", *metricsAddr).Msg("Prometheus metrics enabled")
+	}
+
+	serveErr := make(chan error, len(listeners))
+	for _, lis := range listeners {
+		go func() {
+			serveErr <- srv.Serve(lis)
+		}()
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	<-stop
-	log.Info().Msg("Received stop signal, shutting down")
+
+	select {
+	case sig := <-stop:
+		log.Info().Str("signal", sig.String()).Msg("Received stop signal, shutting down")
+		srv.Shutdown()
+	case err = <-serveErr:
+		if err != nil {
+			log.Error().Err(err).Msg("gRPC server failed")
+		}
+		srv.Shutdown()
+	}
+	log.Info().Msg("Yadoma Docker Agent stopped")
+}
+
+// newServer builds the gRPC server and registers the given container service and the
+// image, network, volume, and system services backed by the given Docker layer.
+func newServer(
+	layer *docker.Layer,
+	containers *container.Service,
+	drain time.Duration,
+	opts ...grpc.ServerOption,
+) *server.Server {
+	srv := server.NewServer(drain, opts...)
+	srv.Register(
+		containers,
+		image.NewImageService(layer),
+		network.NewNetworkService(layer),
+		volume.NewVolumeService(layer),
+		system.NewSystemService(layer),
+	)
+	return srv
+}
+
+// openListeners opens the TCP listener and, if configured, the Unix domain socket.
+// At least one transport must be enabled. On failure, already opened listeners are closed.
+func openListeners(tcpAddr, unixPath, unixMode, unixGroup string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			_ = l.Close()
+		}
+	}
+
+	if tcpAddr != "" {
+		lis, err := net.Listen("tcp", tcpAddr)
+		if err != nil {
+			return nil, fmt.Errorf("cannot listen on %s: %w", tcpAddr, err)
+		}
+		listeners = append(listeners, lis)
+	}
+
+	if unixPath != "" {
+		mode, err := strconv.ParseUint(unixMode, 8, 32)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("invalid unix socket mode %q: %w", unixMode, err)
+		}
+		lis, err := server.ListenUnix(unixPath, fs.FileMode(mode), unixGroup)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		listeners = append(listeners, lis)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("no transport enabled, set -agent-tcp-port or -agent-unix-socket")
+	}
+	return listeners, nil
+}
+
+// serveMetrics starts an HTTP server that serves handler at /metrics on addr.
+// The address is bound before returning, so a taken port fails startup.
+func serveMetrics(addr string, handler http.Handler) (*http.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: metricsReadHeaderTimeout}
+	go func() {
+		if sErr := srv.Serve(lis); sErr != nil && !errors.Is(sErr, http.ErrServerClosed) {
+			log.Error().Err(sErr).Msg("Metrics server failed")
+		}
+	}()
+	return srv, nil
+}
+
+func shutdownMetrics(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("Cannot shut down metrics server gracefully")
+	}
+}
+
+// loadAuthenticators builds the bearer token authenticators configured by flags.
+// Static tokens are tried before JWTs. An empty result disables authentication.
+func loadAuthenticators(tokenFile, jwtKeyFile string) ([]auth.Authenticator, error) {
+	var res []auth.Authenticator
+	if tokenFile != "" {
+		tokens, err := auth.LoadTokenFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, tokens)
+	}
+	if jwtKeyFile != "" {
+		key, err := os.ReadFile(jwtKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read JWT key file %s: %w", jwtKeyFile, err)
+		}
+		verifier, err := auth.NewJWTVerifier(bytes.TrimSpace(key))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, verifier)
+	}
+	return res, nil
+}
+
+// newStatsHistory creates the stats history from the tiers flag and the memory budget
+// in MiB, restoring a previously saved history from file if one is configured.
+func newStatsHistory(tiers string, budgetMiB int, file string) (*metrics.Store, error) {
+	parsed, err := metrics.ParseTiers(tiers)
+	if err != nil {
+		return nil, err
+	}
+	history, err := metrics.NewStore(parsed, budgetMiB<<20)
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		if err = history.Load(file); err != nil {
+			log.Warn().Err(err).Msg("Cannot restore stats history, starting empty")
+		}
+	}
+	return history, nil
+}
+
+func saveStatsHistory(history *metrics.Store, file string) {
+	if err := history.Save(file); err != nil {
+		log.Error().Err(err).Msg("Cannot save stats history")
+		return
+	}
+	log.Info().Str("file", file).Msg("Stats history saved")
+}
+
+// envOrDefault returns the value of the environment variable key, or def if it is unset or empty.
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// splitList splits a comma-separated flag value, dropping blanks.
+func splitList(v string) []string {
+	var res []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
 }
 
 func initializeConnectToDockerEngine(socket string) (*client.Client, error) {
@@ -91,4 +434,4 @@ func initializeConnectToDockerEngine(socket string) (*client.Client, error) {
 	}
 
 	return c, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,129 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	docker "github.com/whiteo/yadoma/internal/dockers"
+	"github.com/whiteo/yadoma/internal/protos"
+	containerservice "github.com/whiteo/yadoma/internal/services/container"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeDockerClient implements only the Docker calls exercised by the tests.
+// Any other method panics through the embedded nil interface.
+type fakeDockerClient struct {
+	docker.ClientInterface
+}
+
+func (f *fakeDockerClient) Info(context.Context) (system.Info, error) {
+	return system.Info{ID: "engine-1", Name: "test-host", NCPU: 4}, nil
+}
+
+func (f *fakeDockerClient) ContainerList(context.Context, container.ListOptions) ([]container.Summary, error) {
+	return []container.Summary{{ID: "c1", Names: []string{"/web"}, State: "running"}}, nil
+}
+
+func (f *fakeDockerClient) ContainerInspect(_ context.Context, id string) (container.InspectResponse, error) {
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{ID: id},
+		Config:            &container.Config{Tty: true},
+	}, nil
+}
+
+// ContainerLogs emulates a followed log stream that stays open until ctx is canceled.
+func (f *fakeDockerClient) ContainerLogs(ctx context.Context,
+	_ string,
+	_ container.LogsOptions,
+) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = pw.Write([]byte("hello\n"))
+		<-ctx.Done()
+		_ = pw.CloseWithError(ctx.Err())
+	}()
+	return pr, nil
+}
+
+func dialAgent(t *testing.T, drain time.Duration) (*grpc.ClientConn, func(), chan error) {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	layer := docker.NewLayer(&fakeDockerClient{})
+	srv := newServer(layer, containerservice.NewContainerService(layer, nil), drain)
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn, srv.Shutdown, served
+}
+
+func TestAgentServesAllServices(t *testing.T) {
+	conn, shutdown, served := dialAgent(t, time.Second)
+	ctx := context.Background()
+
+	info, err := protos.NewSystemServiceClient(conn).GetSystemInfo(ctx, &protos.GetSystemInfoRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, "test-host", info.GetName())
+	assert.Equal(t, int32(4), info.GetNCpu())
+
+	list, err := protos.NewContainerServiceClient(conn).GetContainers(ctx, &protos.GetContainersRequest{All: true})
+	require.NoError(t, err)
+	require.Len(t, list.GetContainers(), 1)
+	assert.Equal(t, "c1", list.GetContainers()[0].GetId())
+
+	_, err = protos.NewVolumeServiceClient(conn).CreateVolume(ctx, &protos.CreateVolumeRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = protos.NewNetworkServiceClient(conn).ConnectNetwork(ctx, &protos.ConnectNetworkRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = protos.NewImageServiceClient(conn).RemoveImage(ctx, &protos.RemoveImageRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	shutdown()
+	assert.NoError(t, <-served)
+}
+
+func TestAgentShutdownCancelsLogStream(t *testing.T) {
+	conn, shutdown, served := dialAgent(t, 50*time.Millisecond)
+
+	stream, err := protos.NewContainerServiceClient(conn).GetContainerLogs(context.Background(),
+		&protos.GetContainerLogsRequest{Id: "c1", Follow: true})
+	require.NoError(t, err)
+
+	first, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(first.GetLine()))
+	assert.Equal(t, protos.LogStream_LOG_STREAM_STDOUT, first.GetStream())
+
+	shutdown()
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.NoError(t, <-served)
+}
@@ -0,0 +1,87 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package auth implements application-level authentication and authorization
+// for the agent's gRPC API.
+// Callers present a bearer token in the "authorization" metadata. Tokens are
+// verified either against a static token file or as HMAC-signed JWTs, and the
+// resulting identity carries a role. Every RPC is mapped to the minimum role it
+// requires; unknown methods require the most privileged role.
+//
+// Unary and stream interceptors enforce the policy, return codes.Unauthenticated
+// for missing or invalid credentials and codes.PermissionDenied for insufficient
+// roles, and log every denial with the caller identity.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Role is a named permission level. Higher roles include all lower ones.
+type Role int
+
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleOperator
+	RoleAdmin
+)
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errMissingToken = errors.New("missing bearer token")
+)
+
+// Identity describes an authenticated caller.
+type Identity struct {
+	Name string
+	Role Role
+}
+
+// Authenticator verifies a bearer token and resolves the caller identity.
+type Authenticator interface {
+	Authenticate(token string) (Identity, error)
+}
+
+type identityKey struct{}
+
+// String returns the lower-case role name used in token files and JWT claims.
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	case RoleNone:
+		return "none"
+	}
+	return fmt.Sprintf("role(%d)", int(r))
+}
+
+// ParseRole converts a role name into a Role.
+// It returns an error for unknown names so typos never grant access.
+func ParseRole(name string) (Role, error) {
+	switch name {
+	case "viewer":
+		return RoleViewer, nil
+	case "operator":
+		return RoleOperator, nil
+	case "admin":
+		return RoleAdmin, nil
+	}
+	return RoleNone, fmt.Errorf("unknown role %q", name)
+}
+
+// NewContext returns a copy of ctx carrying the caller identity.
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the caller identity stored by the interceptors, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
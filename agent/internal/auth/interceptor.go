@@ -0,0 +1,146 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package auth implements application-level authentication and authorization
+// for the agent's gRPC API.
+// Callers present a bearer token in the "authorization" metadata. Tokens are
+// verified either against a static token file or as HMAC-signed JWTs, and the
+// resulting identity carries a role. Every RPC is mapped to the minimum role it
+// requires; unknown methods require the most privileged role.
+//
+// Unary and stream interceptors enforce the policy, return codes.Unauthenticated
+// for missing or invalid credentials and codes.PermissionDenied for insufficient
+// roles, and log every denial with the caller identity.
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const bearerPrefix = "bearer "
+
+// Interceptor authenticates callers with the configured authenticators and
+// authorizes each RPC against RequiredRole.
+type Interceptor struct {
+	uidRoles       UIDRoles
+	authenticators []Authenticator
+}
+
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the stream context enriched with the caller identity.
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// NewInterceptor constructs an Interceptor that tries authenticators in order
+// and accepts the first identity that verifies.
+// Callers connected over a Unix socket that present no bearer token are
+// authorized by their peer uid through uidRoles, which may be nil.
+func NewInterceptor(uidRoles UIDRoles, authenticators ...Authenticator) *Interceptor {
+	return &Interceptor{uidRoles: uidRoles, authenticators: authenticators}
+}
+
+// Unary returns a grpc.UnaryServerInterceptor enforcing authentication and authorization.
+func (i *Interceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := i.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns a grpc.StreamServerInterceptor enforcing authentication and authorization.
+func (i *Interceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (i *Interceptor) authorize(ctx context.Context, method string) (context.Context, error) {
+	id, err := i.authenticate(ctx)
+	if err != nil {
+		log.Warn().Err(err).Str("peer", peerAddr(ctx)).Str("method", method).Msg("Unauthenticated request rejected")
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing credentials")
+	}
+
+	required := RequiredRole(method)
+	if id.Role < required {
+		log.Warn().
+			Str("identity", id.Name).
+			Str("role", id.Role.String()).
+			Str("peer", peerAddr(ctx)).
+			Str("required", required.String()).
+			Str("method", method).
+			Msg("Permission denied")
+		return nil, status.Errorf(codes.PermissionDenied, "role %s is not allowed to call %s", id.Role, method)
+	}
+
+	log.Debug().Str("identity", id.Name).Str("method", method).Msg("Request authorized")
+	return NewContext(ctx, id), nil
+}
+
+func (i *Interceptor) authenticate(ctx context.Context) (Identity, error) {
+	token, err := bearerToken(ctx)
+	if errors.Is(err, errMissingToken) {
+		if id, ok := i.uidRoles.identify(ctx); ok {
+			return id, nil
+		}
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	err = errInvalidToken
+	for _, a := range i.authenticators {
+		id, aErr := a.Authenticate(token)
+		if aErr == nil {
+			return id, nil
+		}
+		err = aErr
+	}
+	return Identity{}, err
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", errMissingToken
+	}
+
+	v := values[0]
+	if len(v) < len(bearerPrefix) || !strings.EqualFold(v[:len(bearerPrefix)], bearerPrefix) {
+		return "", errMissingToken
+	}
+	token := strings.TrimSpace(v[len(bearerPrefix):])
+	if token == "" {
+		return "", errMissingToken
+	}
+	return token, nil
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
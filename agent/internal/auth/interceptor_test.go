@@ -0,0 +1,167 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/whiteo/yadoma/internal/protos"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type mapAuthenticator map[string]Identity
+
+func (m mapAuthenticator) Authenticate(token string) (Identity, error) {
+	if id, ok := m[token]; ok {
+		return id, nil
+	}
+	return Identity{}, errors.New("unknown token")
+}
+
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeServerStream) Context() context.Context { return f.ctx }
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token))
+}
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method string
+		role   Role
+	}{
+		{method: "/container.v1.ContainerService/GetContainers", role: RoleViewer},
+		{method: "/container.v1.ContainerService/GetContainerLogs", role: RoleViewer},
+		{method: "/system.v1.SystemService/GetSystemInfo", role: RoleViewer},
+		{method: "/container.v1.ContainerService/StartContainer", role: RoleOperator},
+		{method: "/container.v1.ContainerService/RemoveContainer", role: RoleAdmin},
+		{method: "/container.v1.ContainerService/KillContainer", role: RoleAdmin},
+		{method: "/image.v1.ImageService/PruneImages", role: RoleAdmin},
+		{method: "/unknown.v1.Service/Anything", role: RoleAdmin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			assert.Equal(t, tt.role, RequiredRole(tt.method))
+		})
+	}
+}
+
+func TestMethodRolesCoverEveryMethod(t *testing.T) {
+	rpc := grpc.NewServer()
+	protos.RegisterContainerServiceServer(rpc, protos.UnimplementedContainerServiceServer{})
+	protos.RegisterImageServiceServer(rpc, protos.UnimplementedImageServiceServer{})
+	protos.RegisterNetworkServiceServer(rpc, protos.UnimplementedNetworkServiceServer{})
+	protos.RegisterVolumeServiceServer(rpc, protos.UnimplementedVolumeServiceServer{})
+	protos.RegisterSystemServiceServer(rpc, protos.UnimplementedSystemServiceServer{})
+
+	services := rpc.GetServiceInfo()
+	require.Len(t, services, 5)
+	for name, info := range services {
+		for _, m := range info.Methods {
+			method := "/" + name + "/" + m.Name
+			_, ok := methodRoles[method]
+			assert.True(t, ok, "%s has no explicit role", method)
+		}
+	}
+}
+
+func TestInterceptorUnary(t *testing.T) {
+	i := NewInterceptor(nil,
+		mapAuthenticator{"v": {Name: "dash", Role: RoleViewer}},
+		mapAuthenticator{"a": {Name: "oncall", Role: RoleAdmin}},
+	)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		code     codes.Code
+		expectID string
+	}{
+		{name: "no metadata", ctx: context.Background(), method: "/system.v1.SystemService/GetSystemInfo",
+			code: codes.Unauthenticated},
+		{name: "not a bearer token", ctx: withToken("Basic abc"), method: "/system.v1.SystemService/GetSystemInfo",
+			code: codes.Unauthenticated},
+		{name: "unknown token", ctx: withToken("Bearer x"), method: "/system.v1.SystemService/GetSystemInfo",
+			code: codes.Unauthenticated},
+		{name: "viewer reads", ctx: withToken("Bearer v"), method: "/container.v1.ContainerService/GetContainers",
+			code: codes.OK, expectID: "dash"},
+		{name: "viewer cannot remove", ctx: withToken("Bearer v"),
+			method: "/container.v1.ContainerService/RemoveContainer", code: codes.PermissionDenied},
+		{name: "viewer cannot prune", ctx: withToken("bearer v"), method: "/image.v1.ImageService/PruneImages",
+			code: codes.PermissionDenied},
+		{name: "second authenticator admin", ctx: withToken("Bearer a"),
+			method: "/container.v1.ContainerService/KillContainer", code: codes.OK, expectID: "oncall"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Identity
+			handler := func(ctx context.Context, _ any) (any, error) {
+				got, _ = FromContext(ctx)
+				return "ok", nil
+			}
+
+			resp, err := i.Unary()(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.code == codes.OK {
+				assert.Equal(t, "ok", resp)
+				assert.Equal(t, tt.expectID, got.Name)
+			} else {
+				assert.Nil(t, resp)
+			}
+		})
+	}
+}
+
+func TestInterceptorStream(t *testing.T) {
+	i := NewInterceptor(nil, mapAuthenticator{"v": {Name: "dash", Role: RoleViewer}})
+
+	t.Run("viewer follows logs", func(t *testing.T) {
+		var got Identity
+		err := i.Stream()(nil, &fakeServerStream{ctx: withToken("Bearer v")},
+			&grpc.StreamServerInfo{FullMethod: "/container.v1.ContainerService/GetContainerLogs"},
+			func(_ any, ss grpc.ServerStream) error {
+				got, _ = FromContext(ss.Context())
+				return nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, "dash", got.Name)
+	})
+
+	t.Run("viewer cannot build", func(t *testing.T) {
+		called := false
+		err := i.Stream()(nil, &fakeServerStream{ctx: withToken("Bearer v")},
+			&grpc.StreamServerInfo{FullMethod: "/image.v1.ImageService/BuildImage"},
+			func(any, grpc.ServerStream) error {
+				called = true
+				return nil
+			})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.False(t, called)
+	})
+}
+
+func TestParseRole(t *testing.T) {
+	for _, r := range []Role{RoleViewer, RoleOperator, RoleAdmin} {
+		parsed, err := ParseRole(r.String())
+		require.NoError(t, err)
+		assert.Equal(t, r, parsed)
+	}
+	_, err := ParseRole("Admin")
+	assert.Error(t, err)
+}
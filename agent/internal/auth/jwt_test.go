@@ -0,0 +1,127 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func signHS256(t *testing.T, key []byte, alg string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestNewJWTVerifierEmptyKey(t *testing.T) {
+	_, err := NewJWTVerifier(nil)
+	assert.Error(t, err)
+}
+
+func TestJWTVerifierAuthenticate(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	valid := map[string]any{"sub": "webapp", "role": "operator", "exp": now.Add(time.Hour).Unix()}
+
+	with := func(k string, v any) map[string]any {
+		c := map[string]any{}
+		for key, val := range valid {
+			c[key] = val
+		}
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name      string
+		token     func(t *testing.T) string
+		expectErr string
+		expectID  Identity
+	}{
+		{
+			name:     "valid token",
+			token:    func(t *testing.T) string { return signHS256(t, testKey, "HS256", valid) },
+			expectID: Identity{Name: "webapp", Role: RoleOperator},
+		},
+		{
+			name:      "wrong key",
+			token:     func(t *testing.T) string { return signHS256(t, []byte("other"), "HS256", valid) },
+			expectErr: "signature",
+		},
+		{
+			name:      "alg none rejected",
+			token:     func(t *testing.T) string { return signHS256(t, testKey, "none", valid) },
+			expectErr: "unsupported token algorithm",
+		},
+		{
+			name: "expired",
+			token: func(t *testing.T) string {
+				return signHS256(t, testKey, "HS256", with("exp", now.Add(-time.Hour).Unix()))
+			},
+			expectErr: "expired",
+		},
+		{
+			name:      "missing expiry",
+			token:     func(t *testing.T) string { return signHS256(t, testKey, "HS256", with("exp", nil)) },
+			expectErr: "no expiry",
+		},
+		{
+			name: "not valid yet",
+			token: func(t *testing.T) string {
+				return signHS256(t, testKey, "HS256", with("nbf", now.Add(time.Hour).Unix()))
+			},
+			expectErr: "not valid yet",
+		},
+		{
+			name:      "unknown role",
+			token:     func(t *testing.T) string { return signHS256(t, testKey, "HS256", with("role", "root")) },
+			expectErr: "unknown role",
+		},
+		{
+			name:      "missing subject",
+			token:     func(t *testing.T) string { return signHS256(t, testKey, "HS256", with("sub", nil)) },
+			expectErr: "no subject",
+		},
+		{
+			name:      "malformed",
+			token:     func(*testing.T) string { return "not.a-jwt" },
+			expectErr: "invalid token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewJWTVerifier(testKey)
+			require.NoError(t, err)
+			v.now = func() time.Time { return now }
+
+			id, err := v.Authenticate(tt.token(t))
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectID, id)
+		})
+	}
+}
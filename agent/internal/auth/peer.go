@@ -0,0 +1,72 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package auth implements application-level authentication and authorization
+// for the agent's gRPC API.
+// Callers present a bearer token in the "authorization" metadata. Tokens are
+// verified either against a static token file or as HMAC-signed JWTs, and the
+// resulting identity carries a role. Every RPC is mapped to the minimum role it
+// requires; unknown methods require the most privileged role.
+//
+// Unary and stream interceptors enforce the policy, return codes.Unauthenticated
+// for missing or invalid credentials and codes.PermissionDenied for insufficient
+// roles, and log every denial with the caller identity.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/whiteo/yadoma/internal/server"
+
+	"google.golang.org/grpc/peer"
+)
+
+// UIDRoles maps local user IDs to roles for callers on the agent's Unix socket.
+type UIDRoles map[uint32]Role
+
+// ParseUIDRoles parses a comma-separated list of "<uid>=<role>" pairs,
+// for example "0=admin,1000=viewer". An empty string yields nil.
+func ParseUIDRoles(v string) (UIDRoles, error) {
+	if strings.TrimSpace(v) == "" {
+		return nil, nil
+	}
+
+	res := UIDRoles{}
+	for _, pair := range strings.Split(v, ",") {
+		uidStr, roleStr, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid uid mapping %q, expected <uid>=<role>", pair)
+		}
+		uid, err := strconv.ParseUint(uidStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid in mapping %q: %w", pair, err)
+		}
+		role, err := ParseRole(roleStr)
+		if err != nil {
+			return nil, err
+		}
+		res[uint32(uid)] = role
+	}
+	return res, nil
+}
+
+func (u UIDRoles) identify(ctx context.Context) (Identity, bool) {
+	if len(u) == 0 {
+		return Identity{}, false
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return Identity{}, false
+	}
+	cred, ok := p.AuthInfo.(server.PeerCredInfo)
+	if !ok {
+		return Identity{}, false
+	}
+	role, ok := u[cred.UID]
+	if !ok {
+		return Identity{}, false
+	}
+	return Identity{Name: "uid:" + strconv.FormatUint(uint64(cred.UID), 10), Role: role}, true
+}
@@ -0,0 +1,92 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package auth
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/whiteo/yadoma/internal/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func withPeerUID(ctx context.Context, uid uint32) context.Context {
+	return peer.NewContext(ctx, &peer.Peer{
+		Addr:     &net.UnixAddr{Name: "@", Net: "unix"},
+		AuthInfo: server.PeerCredInfo{UID: uid},
+	})
+}
+
+func TestParseUIDRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  UIDRoles
+		expectErr bool
+	}{
+		{name: "empty", value: "", expected: nil},
+		{name: "two mappings", value: "0=admin, 1000=viewer",
+			expected: UIDRoles{0: RoleAdmin, 1000: RoleViewer}},
+		{name: "missing separator", value: "1000", expectErr: true},
+		{name: "bad uid", value: "abc=viewer", expectErr: true},
+		{name: "bad role", value: "1000=root", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseUIDRoles(tt.value)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestInterceptorPeerUID(t *testing.T) {
+	i := NewInterceptor(UIDRoles{1000: RoleViewer},
+		mapAuthenticator{"a": {Name: "oncall", Role: RoleAdmin}})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		code     codes.Code
+		expectID string
+	}{
+		{name: "mapped uid reads", ctx: withPeerUID(context.Background(), 1000),
+			method: "/system.v1.SystemService/GetSystemInfo", code: codes.OK, expectID: "uid:1000"},
+		{name: "mapped uid cannot remove", ctx: withPeerUID(context.Background(), 1000),
+			method: "/container.v1.ContainerService/RemoveContainer", code: codes.PermissionDenied},
+		{name: "unmapped uid", ctx: withPeerUID(context.Background(), 1001),
+			method: "/system.v1.SystemService/GetSystemInfo", code: codes.Unauthenticated},
+		{name: "token takes precedence over uid",
+			ctx: metadata.NewIncomingContext(withPeerUID(context.Background(), 1000),
+				metadata.Pairs("authorization", "Bearer a")),
+			method: "/container.v1.ContainerService/RemoveContainer", code: codes.OK, expectID: "oncall"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Identity
+			_, err := i.Unary()(tt.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method},
+				func(ctx context.Context, _ any) (any, error) {
+					got, _ = FromContext(ctx)
+					return nil, nil
+				})
+			assert.Equal(t, tt.code, status.Code(err))
+			assert.Equal(t, tt.expectID, got.Name)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package auth implements application-level authentication and authorization
+// for the agent's gRPC API.
+// Callers present a bearer token in the "authorization" metadata. Tokens are
+// verified either against a static token file or as HMAC-signed JWTs, and the
+// resulting identity carries a role. Every RPC is mapped to the minimum role it
+// requires; unknown methods require the most privileged role.
+//
+// Unary and stream interceptors enforce the policy, return codes.Unauthenticated
+// for missing or invalid credentials and codes.PermissionDenied for insufficient
+// roles, and log every denial with the caller identity.
+package auth
+
+import "github.com/whiteo/yadoma/internal/protos"
+
+// methodRoles maps every RPC to the minimum role allowed to call it.
+// Read-only calls are open to viewers, lifecycle changes to operators, and
+// destructive or host-level calls (remove, prune, kill) are reserved for admins.
+// Keys are the method names generated from the service definitions, so a
+// renamed RPC fails to compile instead of silently requiring RoleAdmin.
+var methodRoles = map[string]Role{
+	protos.ContainerService_GetContainers_FullMethodName:            RoleViewer,
+	protos.ContainerService_GetContainerDetails_FullMethodName:      RoleViewer,
+	protos.ContainerService_GetContainerLogs_FullMethodName:         RoleViewer,
+	protos.ContainerService_GetContainerStats_FullMethodName:        RoleViewer,
+	protos.ContainerService_StreamMultiContainerLogs_FullMethodName: RoleViewer,
+	protos.ContainerService_StreamAllStats_FullMethodName:           RoleViewer,
+	protos.ContainerService_GetStatsHistory_FullMethodName:          RoleViewer,
+	protos.ContainerService_WaitContainer_FullMethodName:            RoleViewer,
+	protos.ContainerService_CreateContainer_FullMethodName:          RoleOperator,
+	protos.ContainerService_RunContainer_FullMethodName:             RoleOperator,
+	protos.ContainerService_StartContainer_FullMethodName:           RoleOperator,
+	protos.ContainerService_StopContainer_FullMethodName:            RoleOperator,
+	protos.ContainerService_RestartContainer_FullMethodName:         RoleOperator,
+	protos.ContainerService_PauseContainer_FullMethodName:           RoleOperator,
+	protos.ContainerService_UnpauseContainer_FullMethodName:         RoleOperator,
+	protos.ContainerService_RenameContainer_FullMethodName:          RoleOperator,
+	protos.ContainerService_UpdateContainer_FullMethodName:          RoleOperator,
+	protos.ContainerService_RecreateContainer_FullMethodName:        RoleOperator,
+	protos.ContainerService_KillContainer_FullMethodName:            RoleAdmin,
+	protos.ContainerService_ExecContainer_FullMethodName:            RoleAdmin,
+	protos.ContainerService_AttachContainer_FullMethodName:          RoleAdmin,
+	protos.ContainerService_RemoveContainer_FullMethodName:          RoleAdmin,
+
+	protos.ImageService_GetImages_FullMethodName:       RoleViewer,
+	protos.ImageService_GetImageDetails_FullMethodName: RoleViewer,
+	protos.ImageService_PullImage_FullMethodName:       RoleOperator,
+	protos.ImageService_BuildImage_FullMethodName:      RoleOperator,
+	protos.ImageService_RemoveImage_FullMethodName:     RoleAdmin,
+	protos.ImageService_PruneImages_FullMethodName:     RoleAdmin,
+
+	protos.NetworkService_GetNetworks_FullMethodName:       RoleViewer,
+	protos.NetworkService_GetNetworkDetails_FullMethodName: RoleViewer,
+	protos.NetworkService_CreateNetwork_FullMethodName:     RoleOperator,
+	protos.NetworkService_ConnectNetwork_FullMethodName:    RoleOperator,
+	protos.NetworkService_DisconnectNetwork_FullMethodName: RoleOperator,
+	protos.NetworkService_RemoveNetwork_FullMethodName:     RoleAdmin,
+	protos.NetworkService_PruneNetworks_FullMethodName:     RoleAdmin,
+
+	protos.VolumeService_GetVolumes_FullMethodName:       RoleViewer,
+	protos.VolumeService_GetVolumeDetails_FullMethodName: RoleViewer,
+	protos.VolumeService_CreateVolume_FullMethodName:     RoleOperator,
+	protos.VolumeService_RemoveVolume_FullMethodName:     RoleAdmin,
+	protos.VolumeService_PruneVolumes_FullMethodName:     RoleAdmin,
+
+	protos.SystemService_GetSystemInfo_FullMethodName: RoleViewer,
+	protos.SystemService_GetDiskUsage_FullMethodName:  RoleViewer,
+	protos.SystemService_StreamEvents_FullMethodName:  RoleViewer,
+}
+
+// RequiredRole returns the minimum role for the fully-qualified gRPC method.
+// Methods without an explicit entry require RoleAdmin, so newly added RPCs are
+// denied to lower roles until they are classified.
+func RequiredRole(fullMethod string) Role {
+	if r, ok := methodRoles[fullMethod]; ok {
+		return r
+	}
+	return RoleAdmin
+}
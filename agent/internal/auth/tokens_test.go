@@ -0,0 +1,77 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTokenFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		expectErr string
+	}{
+		{
+			name:    "valid file with comments",
+			content: "# webapp\nt-view dashboard viewer\n\nt-admin oncall admin\n",
+		},
+		{
+			name:      "missing field",
+			content:   "t-view dashboard\n",
+			expectErr: "tokens:1",
+		},
+		{
+			name:      "unknown role",
+			content:   "t-view dashboard root\n",
+			expectErr: "unknown role",
+		},
+		{
+			name:      "duplicate token",
+			content:   "t-1 a viewer\nt-1 b admin\n",
+			expectErr: "duplicate token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := LoadTokenFile(writeTokenFile(t, tt.content))
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				assert.Nil(t, st)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, st.tokens, 2)
+		})
+	}
+}
+
+func TestLoadTokenFileMissing(t *testing.T) {
+	_, err := LoadTokenFile(filepath.Join(t.TempDir(), "absent"))
+	assert.ErrorContains(t, err, "cannot open token file")
+}
+
+func TestStaticTokensAuthenticate(t *testing.T) {
+	st, err := LoadTokenFile(writeTokenFile(t, "t-view dashboard viewer\nt-admin oncall admin\n"))
+	require.NoError(t, err)
+
+	id, err := st.Authenticate("t-admin")
+	require.NoError(t, err)
+	assert.Equal(t, Identity{Name: "oncall", Role: RoleAdmin}, id)
+
+	_, err = st.Authenticate("t-unknown")
+	assert.ErrorIs(t, err, errInvalidToken)
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -85,6 +86,19 @@ func (l *Layer) GetContainerStats(ctx context.Context,
 	return stats, nil
 }
 
+// GetContainerStatsOneShot retrieves a single resource-usage snapshot for the container identified by id.
+// Unlike GetContainerStats without streaming, the daemon answers right away and does not wait
+// for a second sample, so the previous CPU stats of the snapshot are empty.
+// The provided ctx is used as-is. The caller must read from and close the returned reader.
+// On failure, it returns an error propagated from the Docker client.
+func (l *Layer) GetContainerStatsOneShot(ctx context.Context, id string) (container.StatsResponseReader, error) {
+	stats, err := l.client.ContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return container.StatsResponseReader{}, fmt.Errorf("cannot get stats for container %s: %w", id, err)
+	}
+	return stats, nil
+}
+
 // CreateContainer creates a Docker container using the provided specifications.
 // A child context with a predefined timeout (ctxTimeout) is derived from ctx
 // to bound the request duration.
@@ -245,3 +259,138 @@ func (l *Layer) RenameContainer(ctx context.Context, id, name string) error {
 	}
 	return nil
 }
+
+// UpdateContainer changes the resources and restart policy of the container identified
+// by id without recreating it.
+// A child context with the predefined timeout (ctxTimeout) is derived from ctx
+// to bound the operation duration.
+// The call delegates to Docker's ContainerUpdate API; zero values in config leave the
+// current settings unchanged.
+// On success, it returns the engine warnings; on failure, returns an error wrapped with
+// the container id and the underlying cause.
+func (l *Layer) UpdateContainer(ctx context.Context,
+	id string,
+	config container.UpdateConfig,
+) (container.UpdateResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	resp, err := l.client.ContainerUpdate(ctx, id, config)
+	if err != nil {
+		return container.UpdateResponse{}, fmt.Errorf("cannot update container %s: %w", id, err)
+	}
+	return resp, nil
+}
+
+// WaitContainer waits until the container identified by id meets the given condition:
+// it is not running, it exits next, or it is removed.
+// The provided ctx is used as-is (no internal timeout) because waiting lasts until the
+// condition is met or the caller cancels it.
+// The call delegates to Docker's ContainerWait API. Exactly one value is delivered:
+// the exit status on the response channel once the condition is met, or the request
+// error, including ctx.Err() on cancellation, on the error channel.
+func (l *Layer) WaitContainer(ctx context.Context,
+	id string,
+	condition container.WaitCondition,
+) (<-chan container.WaitResponse, <-chan error) {
+	return l.client.ContainerWait(ctx, id, condition)
+}
+
+// CreateExec creates an exec instance in the container identified by id.
+// A child context with the predefined timeout (ctxTimeout) is derived from ctx
+// to bound the request duration.
+// The exec is only created; it starts when it is attached via AttachExec.
+// On success, it returns container.ExecCreateResponse carrying the exec ID.
+// On failure, it returns an error wrapped with the container id.
+func (l *Layer) CreateExec(ctx context.Context,
+	id string,
+	opts container.ExecOptions,
+) (container.ExecCreateResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	resp, err := l.client.ContainerExecCreate(ctx, id, opts)
+	if err != nil {
+		return container.ExecCreateResponse{}, fmt.Errorf("cannot create exec in container %s: %w", id, err)
+	}
+	return resp, nil
+}
+
+// AttachExec starts the exec instance identified by execID and attaches to its
+// standard streams through a hijacked connection.
+// The provided ctx is used as-is (no internal timeout) because the connection
+// lives as long as the exec process.
+// The caller must close the returned HijackedResponse. Without a TTY the output
+// is multiplexed per the Docker API and must be demultiplexed (e.g. with stdcopy).
+// On failure, it returns an error wrapped with the exec id.
+func (l *Layer) AttachExec(ctx context.Context,
+	execID string,
+	opts container.ExecAttachOptions,
+) (types.HijackedResponse, error) {
+	resp, err := l.client.ContainerExecAttach(ctx, execID, opts)
+	if err != nil {
+		return types.HijackedResponse{}, fmt.Errorf("cannot attach to exec %s: %w", execID, err)
+	}
+	return resp, nil
+}
+
+// ResizeExec changes the TTY size of the exec instance identified by execID.
+// A child context with the predefined timeout (ctxTimeout) is derived from ctx.
+// Returns nil on success; on failure, returns an error wrapped with the exec id.
+func (l *Layer) ResizeExec(ctx context.Context, execID string, opts container.ResizeOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	if err := l.client.ContainerExecResize(ctx, execID, opts); err != nil {
+		return fmt.Errorf("cannot resize exec %s: %w", execID, err)
+	}
+	return nil
+}
+
+// InspectExec returns the state of the exec instance identified by execID,
+// including whether it is still running and its exit code.
+// A child context with the predefined timeout (ctxTimeout) is derived from ctx.
+// On failure, it returns an error wrapped with the exec id.
+func (l *Layer) InspectExec(ctx context.Context, execID string) (container.ExecInspect, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	resp, err := l.client.ContainerExecInspect(ctx, execID)
+	if err != nil {
+		return container.ExecInspect{}, fmt.Errorf("cannot inspect exec %s: %w", execID, err)
+	}
+	return resp, nil
+}
+
+// AttachContainer attaches to the standard streams of the main process of the
+// container identified by id through a hijacked connection.
+// The provided ctx is used as-is (no internal timeout) because the connection
+// lives as long as the client stays attached.
+// When opts.DetachKeys is set, the daemon closes the connection once that key
+// sequence is read from stdin, leaving the container running.
+// The caller must close the returned HijackedResponse. Without a TTY the output
+// is multiplexed per the Docker API and must be demultiplexed (e.g. with stdcopy).
+// On failure, it returns an error wrapped with the container id.
+func (l *Layer) AttachContainer(ctx context.Context,
+	id string,
+	opts container.AttachOptions,
+) (types.HijackedResponse, error) {
+	resp, err := l.client.ContainerAttach(ctx, id, opts)
+	if err != nil {
+		return types.HijackedResponse{}, fmt.Errorf("cannot attach to container %s: %w", id, err)
+	}
+	return resp, nil
+}
+
+// ResizeContainer changes the TTY size of the container identified by id.
+// A child context with the predefined timeout (ctxTimeout) is derived from ctx.
+// Returns nil on success; on failure, returns an error wrapped with the container id.
+func (l *Layer) ResizeContainer(ctx context.Context, id string, opts container.ResizeOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeout)
+	defer cancel()
+
+	if err := l.client.ContainerResize(ctx, id, opts); err != nil {
+		return fmt.Errorf("cannot resize container %s: %w", id, err)
+	}
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/network"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -52,6 +53,13 @@ func (m *MockDockerClient) ContainerStats(ctx context.Context,
 	return args.Get(0).(container.StatsResponseReader), args.Error(1)
 }
 
+func (m *MockDockerClient) ContainerStatsOneShot(ctx context.Context,
+	containerID string,
+) (container.StatsResponseReader, error) {
+	args := m.Called(ctx, containerID)
+	return args.Get(0).(container.StatsResponseReader), args.Error(1)
+}
+
 func (m *MockDockerClient) ContainerCreate(ctx context.Context,
 	config *container.Config,
 	hostConfig *container.HostConfig,
@@ -130,6 +138,67 @@ func (m *MockDockerClient) ContainerRename(ctx context.Context, containerID stri
 	return errorResult
 }
 
+func (m *MockDockerClient) ContainerUpdate(ctx context.Context,
+	containerID string,
+	updateConfig container.UpdateConfig,
+) (container.UpdateResponse, error) {
+	args := m.Called(ctx, containerID, updateConfig)
+	return args.Get(0).(container.UpdateResponse), args.Error(1)
+}
+
+func (m *MockDockerClient) ContainerWait(ctx context.Context,
+	containerID string,
+	condition container.WaitCondition,
+) (<-chan container.WaitResponse, <-chan error) {
+	args := m.Called(ctx, containerID, condition)
+	return args.Get(0).(<-chan container.WaitResponse), args.Get(1).(<-chan error)
+}
+
+func (m *MockDockerClient) ContainerExecCreate(ctx context.Context,
+	containerID string,
+	options container.ExecOptions,
+) (container.ExecCreateResponse, error) {
+	args := m.Called(ctx, containerID, options)
+	return args.Get(0).(container.ExecCreateResponse), args.Error(1)
+}
+
+func (m *MockDockerClient) ContainerExecAttach(ctx context.Context,
+	execID string,
+	config container.ExecAttachOptions,
+) (types.HijackedResponse, error) {
+	args := m.Called(ctx, execID, config)
+	return args.Get(0).(types.HijackedResponse), args.Error(1)
+}
+
+func (m *MockDockerClient) ContainerExecResize(ctx context.Context,
+	execID string,
+	options container.ResizeOptions,
+) error {
+	args := m.Called(ctx, execID, options)
+	return args.Error(0)
+}
+
+func (m *MockDockerClient) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	args := m.Called(ctx, execID)
+	return args.Get(0).(container.ExecInspect), args.Error(1)
+}
+
+func (m *MockDockerClient) ContainerAttach(ctx context.Context,
+	containerID string,
+	options container.AttachOptions,
+) (types.HijackedResponse, error) {
+	args := m.Called(ctx, containerID, options)
+	return args.Get(0).(types.HijackedResponse), args.Error(1)
+}
+
+func (m *MockDockerClient) ContainerResize(ctx context.Context,
+	containerID string,
+	options container.ResizeOptions,
+) error {
+	args := m.Called(ctx, containerID, options)
+	return args.Error(0)
+}
+
 type MockReadCloser struct {
 	*strings.Reader
 }
@@ -455,6 +524,24 @@ func TestContainerGetStats(t *testing.T) {
 	}
 }
 
+func TestContainerStatsOneShot(t *testing.T) {
+	mockClient := &MockDockerClient{}
+	mockClient.On("ContainerStatsOneShot", mock.Anything, "c1").Return(container.StatsResponseReader{}, nil)
+	mockClient.On("ContainerStatsOneShot", mock.Anything, "c2").
+		Return(container.StatsResponseReader{}, errors.New("container not found"))
+
+	l := &Layer{client: mockClient}
+
+	_, err := l.GetContainerStatsOneShot(context.Background(), "c1")
+	assert.NoError(t, err)
+
+	_, err = l.GetContainerStatsOneShot(context.Background(), "c2")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot get stats for container c2")
+
+	mockClient.AssertExpectations(t)
+}
+
 func TestContainerStatsContextCancelled(t *testing.T) {
 	mockClient := &MockDockerClient{}
 
@@ -1037,6 +1124,78 @@ func TestContainerRename(t *testing.T) {
 	}
 }
 
+func TestContainerUpdate(t *testing.T) {
+	limit := int64(100)
+	config := container.UpdateConfig{
+		Resources:     container.Resources{Memory: 256 << 20, PidsLimit: &limit},
+		RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 3},
+	}
+
+	tests := []struct {
+		setupMock   func(*MockDockerClient)
+		name        string
+		containerID string
+		expected    container.UpdateResponse
+		expectError bool
+	}{
+		{
+			name:        "successful update of the container",
+			containerID: testContainerID,
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerUpdate", mock.Anything, testContainerID, config).
+					Return(container.UpdateResponse{Warnings: []string{"swap limit not supported"}}, nil)
+			},
+			expected: container.UpdateResponse{Warnings: []string{"swap limit not supported"}},
+		},
+		{
+			name:        "error when updating a container",
+			containerID: "invalid-id",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerUpdate", mock.Anything, "invalid-id", config).
+					Return(container.UpdateResponse{}, errors.New("container not found"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockDockerClient{}
+			tt.setupMock(mockClient)
+
+			l := &Layer{client: mockClient}
+
+			resp, err := l.UpdateContainer(context.Background(), tt.containerID, config)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "cannot update container")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, resp)
+			}
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContainerWait(t *testing.T) {
+	waits := make(chan container.WaitResponse, 1)
+	errs := make(chan error)
+	waits <- container.WaitResponse{StatusCode: 137}
+
+	mockClient := &MockDockerClient{}
+	mockClient.On("ContainerWait", mock.Anything, testContainerID, container.WaitConditionNextExit).
+		Return((<-chan container.WaitResponse)(waits), (<-chan error)(errs))
+
+	l := &Layer{client: mockClient}
+	gotWaits, _ := l.WaitContainer(context.Background(), testContainerID, container.WaitConditionNextExit)
+
+	assert.Equal(t, int64(137), (<-gotWaits).StatusCode)
+	mockClient.AssertExpectations(t)
+}
+
 func TestContainerContextTimeout(t *testing.T) {
 	mockClient := &MockDockerClient{}
 
@@ -1055,3 +1214,292 @@ func TestContainerContextTimeout(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestContainerExecCreate(t *testing.T) {
+	opts := container.ExecOptions{Cmd: []string{"sh"}, AttachStdout: true}
+
+	tests := []struct {
+		setupMock   func(*MockDockerClient)
+		name        string
+		containerID string
+		expectedID  string
+		expectError bool
+	}{
+		{
+			name:        "successful exec creation",
+			containerID: testContainerID,
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecCreate",
+					mock.Anything,
+					testContainerID,
+					opts,
+				).Return(container.ExecCreateResponse{ID: "exec-1"}, nil)
+			},
+			expectedID: "exec-1",
+		},
+		{
+			name:        "error when creating exec",
+			containerID: "invalid-id",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecCreate",
+					mock.Anything,
+					"invalid-id",
+					opts,
+				).Return(container.ExecCreateResponse{}, errors.New("container not running"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockDockerClient{}
+			tt.setupMock(mockClient)
+
+			l := &Layer{client: mockClient}
+
+			resp, err := l.CreateExec(context.Background(), tt.containerID, opts)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "cannot create exec in container")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedID, resp.ID)
+			}
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContainerExecAttach(t *testing.T) {
+	tests := []struct {
+		setupMock   func(*MockDockerClient)
+		name        string
+		expectError bool
+	}{
+		{
+			name: "successful exec attach",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecAttach",
+					mock.Anything,
+					"exec-1",
+					container.ExecAttachOptions{Tty: true},
+				).Return(types.HijackedResponse{}, nil)
+			},
+		},
+		{
+			name: "error when attaching exec",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecAttach",
+					mock.Anything,
+					"exec-1",
+					container.ExecAttachOptions{Tty: true},
+				).Return(types.HijackedResponse{}, errors.New("no such exec"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockDockerClient{}
+			tt.setupMock(mockClient)
+
+			l := &Layer{client: mockClient}
+
+			_, err := l.AttachExec(context.Background(), "exec-1", container.ExecAttachOptions{Tty: true})
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "cannot attach to exec")
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContainerExecResize(t *testing.T) {
+	size := container.ResizeOptions{Height: 40, Width: 120}
+
+	tests := []struct {
+		setupMock   func(*MockDockerClient)
+		name        string
+		expectError bool
+	}{
+		{
+			name: "successful exec resize",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecResize", mock.Anything, "exec-1", size).Return(nil)
+			},
+		},
+		{
+			name: "error when resizing exec",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecResize", mock.Anything, "exec-1", size).Return(errors.New("no tty"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockDockerClient{}
+			tt.setupMock(mockClient)
+
+			l := &Layer{client: mockClient}
+
+			err := l.ResizeExec(context.Background(), "exec-1", size)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "cannot resize exec")
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContainerExecInspect(t *testing.T) {
+	tests := []struct {
+		setupMock   func(*MockDockerClient)
+		name        string
+		expected    container.ExecInspect
+		expectError bool
+	}{
+		{
+			name: "successful exec inspect",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecInspect", mock.Anything, "exec-1").
+					Return(container.ExecInspect{ExecID: "exec-1", ExitCode: 3}, nil)
+			},
+			expected: container.ExecInspect{ExecID: "exec-1", ExitCode: 3},
+		},
+		{
+			name: "error when inspecting exec",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerExecInspect", mock.Anything, "exec-1").
+					Return(container.ExecInspect{}, errors.New("no such exec"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockDockerClient{}
+			tt.setupMock(mockClient)
+
+			l := &Layer{client: mockClient}
+
+			got, err := l.InspectExec(context.Background(), "exec-1")
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "cannot inspect exec")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, got)
+			}
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContainerAttach(t *testing.T) {
+	opts := container.AttachOptions{Stream: true, Stdin: true, Stdout: true, Stderr: true, DetachKeys: "ctrl-x"}
+
+	tests := []struct {
+		setupMock   func(*MockDockerClient)
+		name        string
+		expectError bool
+	}{
+		{
+			name: "successful container attach",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerAttach", mock.Anything, testContainerID, opts).
+					Return(types.HijackedResponse{}, nil)
+			},
+		},
+		{
+			name: "error when attaching container",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerAttach", mock.Anything, testContainerID, opts).
+					Return(types.HijackedResponse{}, errors.New("invalid detach keys"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockDockerClient{}
+			tt.setupMock(mockClient)
+
+			l := &Layer{client: mockClient}
+
+			_, err := l.AttachContainer(context.Background(), testContainerID, opts)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "cannot attach to container")
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestContainerResize(t *testing.T) {
+	size := container.ResizeOptions{Height: 50, Width: 200}
+
+	tests := []struct {
+		setupMock   func(*MockDockerClient)
+		name        string
+		expectError bool
+	}{
+		{
+			name: "successful container resize",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerResize", mock.Anything, testContainerID, size).Return(nil)
+			},
+		},
+		{
+			name: "error when resizing container",
+			setupMock: func(m *MockDockerClient) {
+				m.On("ContainerResize", mock.Anything, testContainerID, size).Return(errors.New("no tty"))
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockDockerClient{}
+			tt.setupMock(mockClient)
+
+			l := &Layer{client: mockClient}
+
+			err := l.ResizeContainer(context.Background(), testContainerID, size)
+
+			if tt.expectError {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "cannot resize container")
+			} else {
+				assert.NoError(t, err)
+			}
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
@@ -39,6 +40,7 @@ type ClientInterface interface {
 	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
 	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
 	ContainerStats(ctx context.Context, containerID string, stream bool) (container.StatsResponseReader, error)
+	ContainerStatsOneShot(ctx context.Context, containerID string) (container.StatsResponseReader, error)
 	ContainerCreate(ctx context.Context,
 		config *container.Config,
 		hostConfig *container.HostConfig,
@@ -54,6 +56,29 @@ type ClientInterface interface {
 	ContainerUnpause(ctx context.Context, containerID string) error
 	ContainerKill(ctx context.Context, containerID string, signal string) error
 	ContainerRename(ctx context.Context, containerID string, newName string) error
+	ContainerUpdate(ctx context.Context,
+		containerID string,
+		updateConfig container.UpdateConfig,
+	) (container.UpdateResponse, error)
+	ContainerWait(ctx context.Context,
+		containerID string,
+		condition container.WaitCondition,
+	) (<-chan container.WaitResponse, <-chan error)
+	ContainerExecCreate(ctx context.Context,
+		containerID string,
+		options container.ExecOptions,
+	) (container.ExecCreateResponse, error)
+	ContainerExecAttach(ctx context.Context,
+		execID string,
+		config container.ExecAttachOptions,
+	) (types.HijackedResponse, error)
+	ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+	ContainerAttach(ctx context.Context,
+		containerID string,
+		options container.AttachOptions,
+	) (types.HijackedResponse, error)
+	ContainerResize(ctx context.Context, containerID string, options container.ResizeOptions) error
 
 	// Image methods
 	ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error)
@@ -88,6 +113,7 @@ type ClientInterface interface {
 	// System methods
 	Info(ctx context.Context) (system.Info, error)
 	DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error)
+	Events(ctx context.Context, options events.ListOptions) (<-chan events.Message, <-chan error)
 }
 
 type Layer struct {
@@ -96,10 +122,11 @@ type Layer struct {
 
 // NewLayer constructs a Layer that wraps the provided Docker Engine API client.
 // It binds the given client to enable container, image, network, volume, and
-// system operations through this package.
+// system operations through this package. Any ClientInterface implementation is
+// accepted, which allows *client.Client in production and fakes in tests.
 // Non-streaming requests are bounded by the package-level timeout (ctxTimeout);
 // streaming endpoints use the caller's context.
 // The caller retains ownership of the client and should close it when finished.
-func NewLayer(c *client.Client) *Layer {
+func NewLayer(c ClientInterface) *Layer {
 	return &Layer{client: c}
 }
@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/system"
 )
 
@@ -51,3 +52,14 @@ func (l *Layer) GetDiskUsage(ctx context.Context, opts types.DiskUsageOptions) (
 	}
 	return du, nil
 }
+
+// GetEvents subscribes to the Docker Engine event stream using the provided events.ListOptions.
+// The provided ctx is used as-is (no internal timeout) because the subscription lives until
+// the caller cancels it or the requested Until time is reached.
+// Events are delivered on the returned message channel. The error channel receives exactly
+// one value when the stream ends: io.EOF when the daemon closes it (for example, after Until
+// or on a daemon restart), ctx.Err() on cancellation, or the request/decoding error.
+// Reconnecting after an error is left to the caller.
+func (l *Layer) GetEvents(ctx context.Context, opts events.ListOptions) (<-chan events.Message, <-chan error) {
+	return l.client.Events(ctx, opts)
+}
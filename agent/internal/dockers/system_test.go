@@ -11,6 +11,8 @@ import (
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/api/types/volume"
@@ -28,6 +30,13 @@ func (m *MockDockerClient) DiskUsage(ctx context.Context, options types.DiskUsag
 	return args.Get(0).(types.DiskUsage), args.Error(1)
 }
 
+func (m *MockDockerClient) Events(ctx context.Context,
+	options events.ListOptions,
+) (<-chan events.Message, <-chan error) {
+	args := m.Called(ctx, options)
+	return args.Get(0).(<-chan events.Message), args.Get(1).(<-chan error)
+}
+
 func TestSystemGetInfo(t *testing.T) {
 	expectedSystemInfo := system.Info{
 		ID:                "test-docker-id",
@@ -230,3 +239,26 @@ func TestSystemContextTimeout(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestSystemGetEvents(t *testing.T) {
+	opts := events.ListOptions{
+		Since:   "1700000000.000000000",
+		Filters: filters.NewArgs(filters.Arg("type", "container")),
+	}
+
+	msgs := make(chan events.Message, 1)
+	errs := make(chan error, 1)
+	msgs <- events.Message{Type: events.ContainerEventType, Action: events.ActionStart}
+	errs <- errors.New("connection reset")
+
+	mockClient := &MockDockerClient{}
+	mockClient.On("Events", mock.Anything, opts).
+		Return((<-chan events.Message)(msgs), (<-chan error)(errs))
+
+	l := &Layer{client: mockClient}
+	gotMsgs, gotErrs := l.GetEvents(context.Background(), opts)
+
+	assert.Equal(t, events.ActionStart, (<-gotMsgs).Action)
+	assert.EqualError(t, <-gotErrs, "connection reset")
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,82 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package metrics measures container resource usage inside the agent.
+// It computes derived values from Docker stats the way `docker stats` does, keeps a
+// history of samples in tiers of increasing resolution, for example 10 second points
+// kept for an hour and 1 minute points kept for a day, and exposes containers, the
+// engine, and the agent itself in the Prometheus format.
+//
+// Every history tier is a fixed size ring per container, so the memory used by the
+// history is known up front and bounded by a budget; when the budget is reached, the
+// containers that were updated least recently are dropped first. The history can be
+// saved to and restored from a file, so it survives restarts of the agent.
+//
+// The package is internal to the agent.
+package metrics
+
+import (
+	"math"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// CPUPercent returns the CPU usage between the previous and the current sample
+// in percent of one CPU, the way `docker stats` computes it. It is 0 if there is no
+// previous sample, which is the case for the first sample of a stream.
+func CPUPercent(cur, pre container.CPUStats) float64 {
+	cpuDelta := float64(cur.CPUUsage.TotalUsage) - float64(pre.CPUUsage.TotalUsage)
+	systemDelta := float64(cur.SystemUsage) - float64(pre.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * float64(OnlineCPUs(cur)) * 100
+}
+
+// OnlineCPUs returns the number of CPUs available to the container. Older engines do not
+// report it, then the number of per-CPU counters is used.
+func OnlineCPUs(stats container.CPUStats) uint32 {
+	if stats.OnlineCPUs != 0 {
+		return stats.OnlineCPUs
+	}
+	return clampToUint32(len(stats.CPUUsage.PercpuUsage))
+}
+
+// MemWorkingSet returns the memory usage without the page cache that the kernel
+// can reclaim. cgroup v1 reports it as total_inactive_file, cgroup v2 as inactive_file.
+func MemWorkingSet(stats container.MemoryStats) uint64 {
+	for _, key := range []string{"total_inactive_file", "inactive_file"} {
+		if v, ok := stats.Stats[key]; ok {
+			if v < stats.Usage {
+				return stats.Usage - v
+			}
+			return stats.Usage
+		}
+	}
+	return stats.Usage
+}
+
+// BlockIO returns the bytes read and written, summed over all block devices. cgroup v1
+// reports the operations capitalized, cgroup v2 in lower case.
+func BlockIO(stats container.BlkioStats) (uint64, uint64) {
+	var read, write uint64
+	for _, entry := range stats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			read += entry.Value
+		case "write":
+			write += entry.Value
+		}
+	}
+	return read, write
+}
+
+func clampToUint32(v int) uint32 {
+	if v > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	if v < 0 {
+		return 0
+	}
+	return uint32(v)
+}
@@ -0,0 +1,78 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUPercent(t *testing.T) {
+	tests := []struct {
+		name     string
+		cur      container.CPUStats
+		pre      container.CPUStats
+		expected float64
+	}{
+		{
+			name: "online cpus",
+			cur: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 5000}, SystemUsage: 40000,
+				OnlineCPUs: 4},
+			pre:      container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 3000}, SystemUsage: 20000},
+			expected: 40,
+		},
+		{
+			name: "per-cpu counters without online cpus",
+			cur: container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 3000, PercpuUsage: []uint64{1, 2}},
+				SystemUsage: 20000},
+			pre:      container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 1000}, SystemUsage: 10000},
+			expected: 40,
+		},
+		{
+			name:     "no previous sample",
+			cur:      container.CPUStats{CPUUsage: container.CPUUsage{TotalUsage: 3000}, OnlineCPUs: 2},
+			expected: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expected, CPUPercent(tt.cur, tt.pre), 0.0001)
+		})
+	}
+}
+
+func TestMemWorkingSet(t *testing.T) {
+	tests := []struct {
+		name     string
+		stats    container.MemoryStats
+		expected uint64
+	}{
+		{name: "cgroup v1", stats: container.MemoryStats{Usage: 400,
+			Stats: map[string]uint64{"total_inactive_file": 100}}, expected: 300},
+		{name: "cgroup v2", stats: container.MemoryStats{Usage: 400,
+			Stats: map[string]uint64{"inactive_file": 150}}, expected: 250},
+		{name: "cache above usage", stats: container.MemoryStats{Usage: 400,
+			Stats: map[string]uint64{"inactive_file": 500}}, expected: 400},
+		{name: "no stats", stats: container.MemoryStats{Usage: 400}, expected: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MemWorkingSet(tt.stats))
+		})
+	}
+}
+
+func TestBlockIO(t *testing.T) {
+	read, write := BlockIO(container.BlkioStats{IoServiceBytesRecursive: []container.BlkioStatEntry{
+		{Op: "Read", Value: 10},
+		{Op: "read", Value: 5},
+		{Op: "Write", Value: 20},
+		{Op: "Total", Value: 35},
+	}})
+	assert.Equal(t, uint64(15), read)
+	assert.Equal(t, uint64(20), write)
+}
@@ -0,0 +1,276 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package metrics measures container resource usage inside the agent.
+// It computes derived values from Docker stats the way `docker stats` does, keeps a
+// history of samples in tiers of increasing resolution, for example 10 second points
+// kept for an hour and 1 minute points kept for a day, and exposes containers, the
+// engine, and the agent itself in the Prometheus format.
+//
+// Every history tier is a fixed size ring per container, so the memory used by the
+// history is known up front and bounded by a budget; when the budget is reached, the
+// containers that were updated least recently are dropped first. The history can be
+// saved to and restored from a file, so it survives restarts of the agent.
+//
+// The package is internal to the agent.
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/whiteo/yadoma/internal/dockers"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	namespace = "yadoma"
+	// scrapeWorkers limits the Docker stats connections open at the same time.
+	scrapeWorkers = 8
+	// scrapeTimeout bounds the Docker calls of one scrape.
+	scrapeTimeout = 10 * time.Second
+	// composeProjectLabel is the container label Docker Compose stores the project in.
+	composeProjectLabel = "com.docker.compose.project"
+)
+
+type dockerAPI interface {
+	GetContainers(ctx context.Context, opts container.ListOptions) ([]container.Summary, error)
+	GetContainerStatsOneShot(ctx context.Context, id string) (container.StatsResponseReader, error)
+	GetSystemInfo(ctx context.Context) (system.Info, error)
+	GetDiskUsage(ctx context.Context, opts types.DiskUsageOptions) (types.DiskUsage, error)
+}
+
+var (
+	containerLabels = []string{"id", "name", "image", "compose_project"}
+
+	containerCPUDesc = prometheus.NewDesc(namespace+"_container_cpu_usage_seconds_total",
+		"Cumulative CPU time consumed by the container.", containerLabels, nil)
+	containerMemUsageDesc = prometheus.NewDesc(namespace+"_container_memory_usage_bytes",
+		"Memory used by the container without reclaimable page cache.", containerLabels, nil)
+	containerMemLimitDesc = prometheus.NewDesc(namespace+"_container_memory_limit_bytes",
+		"Memory limit of the container.", containerLabels, nil)
+	containerPidsDesc = prometheus.NewDesc(namespace+"_container_pids",
+		"Number of processes and threads in the container.", containerLabels, nil)
+	containerBlockReadDesc = prometheus.NewDesc(namespace+"_container_block_read_bytes_total",
+		"Bytes read from block devices by the container.", containerLabels, nil)
+	containerBlockWriteDesc = prometheus.NewDesc(namespace+"_container_block_write_bytes_total",
+		"Bytes written to block devices by the container.", containerLabels, nil)
+	containerNetRxDesc = prometheus.NewDesc(namespace+"_container_network_receive_bytes_total",
+		"Bytes received by the container per network interface.",
+		append(slices.Clone(containerLabels), "interface"), nil)
+	containerNetTxDesc = prometheus.NewDesc(namespace+"_container_network_transmit_bytes_total",
+		"Bytes transmitted by the container per network interface.",
+		append(slices.Clone(containerLabels), "interface"), nil)
+
+	engineContainersDesc = prometheus.NewDesc(namespace+"_engine_containers",
+		"Number of containers known to the Docker engine by state.", []string{"state"}, nil)
+	engineImagesDesc = prometheus.NewDesc(namespace+"_engine_images",
+		"Number of images known to the Docker engine.", nil, nil)
+	engineCPUsDesc = prometheus.NewDesc(namespace+"_engine_cpus",
+		"Number of CPUs available to the Docker engine.", nil, nil)
+	engineMemoryDesc = prometheus.NewDesc(namespace+"_engine_memory_bytes",
+		"Memory available to the Docker engine.", nil, nil)
+
+	diskUsageDesc = prometheus.NewDesc(namespace+"_disk_usage_bytes",
+		"Disk space used by Docker objects by type.", []string{"type"}, nil)
+
+	scrapeSuccessDesc = prometheus.NewDesc(namespace+"_scrape_success",
+		"Whether the last scrape of a Docker source succeeded.", []string{"source"}, nil)
+)
+
+// NewHandler returns an HTTP handler that serves the Docker metrics, the gRPC server
+// metrics, and the Go runtime and process metrics of the agent in the Prometheus
+// text format.
+func NewHandler(layer *docker.Layer, server *ServerMetrics) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		NewDockerCollector(layer),
+		server,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
+
+// DockerCollector is a Prometheus collector for per-container resource usage, engine
+// counts, and Docker disk usage. Every scrape queries the Docker engine; container
+// stats are read as one-shot snapshots by a bounded pool of workers.
+type DockerCollector struct {
+	layer dockerAPI
+}
+
+// NewDockerCollector creates a collector backed by the provided Docker layer.
+func NewDockerCollector(layer *docker.Layer) *DockerCollector {
+	return &DockerCollector{layer: layer}
+}
+
+// Describe sends the descriptors of all metrics the collector can produce.
+func (c *DockerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		containerCPUDesc, containerMemUsageDesc, containerMemLimitDesc, containerPidsDesc,
+		containerBlockReadDesc, containerBlockWriteDesc, containerNetRxDesc, containerNetTxDesc,
+		engineContainersDesc, engineImagesDesc, engineCPUsDesc, engineMemoryDesc,
+		diskUsageDesc, scrapeSuccessDesc,
+	} {
+		ch <- d
+	}
+}
+
+// Collect queries the Docker engine and sends the current metrics. A source that
+// fails is reported through yadoma_scrape_success and does not fail the others.
+func (c *DockerCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	sources := []struct {
+		name    string
+		collect func(context.Context, chan<- prometheus.Metric) error
+	}{
+		{name: "containers", collect: c.collectContainers},
+		{name: "engine", collect: c.collectEngine},
+		{name: "disk", collect: c.collectDiskUsage},
+	}
+	for _, src := range sources {
+		success := 1.0
+		if err := src.collect(ctx, ch); err != nil {
+			log.Warn().Err(err).Str("source", src.name).Msg("Cannot collect Docker metrics")
+			success = 0
+		}
+		ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, src.name)
+	}
+}
+
+func (c *DockerCollector) collectContainers(ctx context.Context, ch chan<- prometheus.Metric) error {
+	list, err := c.layer.GetContainers(ctx, container.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	jobs := make(chan container.Summary)
+	var wg sync.WaitGroup
+	for range min(scrapeWorkers, len(list)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for summary := range jobs {
+				stats, sErr := c.containerStats(ctx, summary.ID)
+				if sErr != nil {
+					log.Debug().Err(sErr).Str("container", summary.ID).Msg("Cannot read container stats")
+					continue
+				}
+				collectContainer(ch, summary, stats)
+			}
+		}()
+	}
+	for _, summary := range list {
+		jobs <- summary
+	}
+	close(jobs)
+	wg.Wait()
+	return nil
+}
+
+func (c *DockerCollector) containerStats(ctx context.Context, id string) (container.StatsResponse, error) {
+	reader, err := c.layer.GetContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return container.StatsResponse{}, err
+	}
+	defer func() {
+		_ = reader.Body.Close()
+	}()
+
+	var stats container.StatsResponse
+	if err = json.NewDecoder(reader.Body).Decode(&stats); err != nil {
+		return container.StatsResponse{}, err
+	}
+	return stats, nil
+}
+
+func collectContainer(ch chan<- prometheus.Metric, summary container.Summary, stats container.StatsResponse) {
+	var name string
+	if len(summary.Names) > 0 {
+		name = strings.TrimPrefix(summary.Names[0], "/")
+	}
+	labels := []string{summary.ID, name, summary.Image, summary.Labels[composeProjectLabel]}
+	blkRead, blkWrite := BlockIO(stats.BlkioStats)
+
+	for _, m := range []struct {
+		desc  *prometheus.Desc
+		kind  prometheus.ValueType
+		value float64
+	}{
+		{containerCPUDesc, prometheus.CounterValue, float64(stats.CPUStats.CPUUsage.TotalUsage) / float64(time.Second)},
+		{containerMemUsageDesc, prometheus.GaugeValue, float64(MemWorkingSet(stats.MemoryStats))},
+		{containerMemLimitDesc, prometheus.GaugeValue, float64(stats.MemoryStats.Limit)},
+		{containerPidsDesc, prometheus.GaugeValue, float64(stats.PidsStats.Current)},
+		{containerBlockReadDesc, prometheus.CounterValue, float64(blkRead)},
+		{containerBlockWriteDesc, prometheus.CounterValue, float64(blkWrite)},
+	} {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.kind, m.value, labels...)
+	}
+	for iface, n := range stats.Networks {
+		netLabels := append(slices.Clone(labels), iface)
+		ch <- prometheus.MustNewConstMetric(containerNetRxDesc, prometheus.CounterValue, float64(n.RxBytes), netLabels...)
+		ch <- prometheus.MustNewConstMetric(containerNetTxDesc, prometheus.CounterValue, float64(n.TxBytes), netLabels...)
+	}
+}
+
+func (c *DockerCollector) collectEngine(ctx context.Context, ch chan<- prometheus.Metric) error {
+	info, err := c.layer.GetSystemInfo(ctx)
+	if err != nil {
+		return err
+	}
+
+	for state, count := range map[string]int{
+		"running": info.ContainersRunning,
+		"paused":  info.ContainersPaused,
+		"stopped": info.ContainersStopped,
+	} {
+		ch <- prometheus.MustNewConstMetric(engineContainersDesc, prometheus.GaugeValue, float64(count), state)
+	}
+	ch <- prometheus.MustNewConstMetric(engineImagesDesc, prometheus.GaugeValue, float64(info.Images))
+	ch <- prometheus.MustNewConstMetric(engineCPUsDesc, prometheus.GaugeValue, float64(info.NCPU))
+	ch <- prometheus.MustNewConstMetric(engineMemoryDesc, prometheus.GaugeValue, float64(info.MemTotal))
+	return nil
+}
+
+func (c *DockerCollector) collectDiskUsage(ctx context.Context, ch chan<- prometheus.Metric) error {
+	du, err := c.layer.GetDiskUsage(ctx, types.DiskUsageOptions{})
+	if err != nil {
+		return err
+	}
+
+	var containers, volumes, buildCache int64
+	for _, ctr := range du.Containers {
+		containers += ctr.SizeRw
+	}
+	for _, v := range du.Volumes {
+		if v.UsageData != nil && v.UsageData.Size > 0 {
+			volumes += v.UsageData.Size
+		}
+	}
+	for _, bc := range du.BuildCache {
+		buildCache += bc.Size
+	}
+
+	for kind, size := range map[string]int64{
+		"images":      du.LayersSize,
+		"containers":  containers,
+		"volumes":     volumes,
+		"build_cache": buildCache,
+	} {
+		ch <- prometheus.MustNewConstMetric(diskUsageDesc, prometheus.GaugeValue, float64(size), kind)
+	}
+	return nil
+}
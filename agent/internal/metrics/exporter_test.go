@@ -0,0 +1,133 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockDockerAPI struct{ mock.Mock }
+
+func (m *mockDockerAPI) GetContainers(ctx context.Context, opts container.ListOptions) ([]container.Summary, error) {
+	args := m.Called(ctx, opts)
+	return args.Get(0).([]container.Summary), args.Error(1)
+}
+
+func (m *mockDockerAPI) GetContainerStatsOneShot(ctx context.Context,
+	id string,
+) (container.StatsResponseReader, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(container.StatsResponseReader), args.Error(1)
+}
+
+func (m *mockDockerAPI) GetSystemInfo(ctx context.Context) (system.Info, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(system.Info), args.Error(1)
+}
+
+func (m *mockDockerAPI) GetDiskUsage(ctx context.Context, opts types.DiskUsageOptions) (types.DiskUsage, error) {
+	args := m.Called(ctx, opts)
+	return args.Get(0).(types.DiskUsage), args.Error(1)
+}
+
+func statsBody(json string) container.StatsResponseReader {
+	return container.StatsResponseReader{Body: io.NopCloser(strings.NewReader(json))}
+}
+
+func TestDockerCollector(t *testing.T) {
+	ml := &mockDockerAPI{}
+	ml.On("GetContainers", mock.Anything, container.ListOptions{}).Return([]container.Summary{
+		{ID: "c1", Names: []string{"/shop-web-1"}, Image: "nginx:1.27",
+			Labels: map[string]string{"com.docker.compose.project": "shop"}},
+		{ID: "c2", Names: []string{"/gone"}, Image: "redis:7"},
+	}, nil)
+	ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(statsBody(`{
+		"cpu_stats": {"cpu_usage": {"total_usage": 2500000000}},
+		"memory_stats": {"usage": 300, "limit": 1000, "stats": {"inactive_file": 100}},
+		"pids_stats": {"current": 4},
+		"blkio_stats": {"io_service_bytes_recursive": [{"op": "read", "value": 10}, {"op": "write", "value": 20}]},
+		"networks": {"eth0": {"rx_bytes": 5, "tx_bytes": 6}}
+	}`), nil)
+	ml.On("GetContainerStatsOneShot", mock.Anything, "c2").
+		Return(container.StatsResponseReader{}, errors.New("no such container"))
+	ml.On("GetSystemInfo", mock.Anything).Return(system.Info{
+		ContainersRunning: 2, ContainersPaused: 1, ContainersStopped: 3, Images: 7, NCPU: 4, MemTotal: 8192,
+	}, nil)
+	ml.On("GetDiskUsage", mock.Anything, types.DiskUsageOptions{}).
+		Return(types.DiskUsage{}, errors.New("disk usage is already running"))
+
+	expected := `
+# HELP yadoma_container_cpu_usage_seconds_total Cumulative CPU time consumed by the container.
+# TYPE yadoma_container_cpu_usage_seconds_total counter
+yadoma_container_cpu_usage_seconds_total{compose_project="shop",id="c1",image="nginx:1.27",name="shop-web-1"} 2.5
+# HELP yadoma_container_memory_usage_bytes Memory used by the container without reclaimable page cache.
+# TYPE yadoma_container_memory_usage_bytes gauge
+yadoma_container_memory_usage_bytes{compose_project="shop",id="c1",image="nginx:1.27",name="shop-web-1"} 200
+# HELP yadoma_container_network_receive_bytes_total Bytes received by the container per network interface.
+# TYPE yadoma_container_network_receive_bytes_total counter
+yadoma_container_network_receive_bytes_total{compose_project="shop",id="c1",image="nginx:1.27",interface="eth0",name="shop-web-1"} 5
+# HELP yadoma_container_block_write_bytes_total Bytes written to block devices by the container.
+# TYPE yadoma_container_block_write_bytes_total counter
+yadoma_container_block_write_bytes_total{compose_project="shop",id="c1",image="nginx:1.27",name="shop-web-1"} 20
+# HELP yadoma_engine_containers Number of containers known to the Docker engine by state.
+# TYPE yadoma_engine_containers gauge
+yadoma_engine_containers{state="paused"} 1
+yadoma_engine_containers{state="running"} 2
+yadoma_engine_containers{state="stopped"} 3
+# HELP yadoma_scrape_success Whether the last scrape of a Docker source succeeded.
+# TYPE yadoma_scrape_success gauge
+yadoma_scrape_success{source="containers"} 1
+yadoma_scrape_success{source="disk"} 0
+yadoma_scrape_success{source="engine"} 1
+`
+	err := testutil.CollectAndCompare(&DockerCollector{layer: ml}, strings.NewReader(expected),
+		"yadoma_container_cpu_usage_seconds_total",
+		"yadoma_container_memory_usage_bytes",
+		"yadoma_container_network_receive_bytes_total",
+		"yadoma_container_block_write_bytes_total",
+		"yadoma_engine_containers",
+		"yadoma_scrape_success",
+	)
+	assert.NoError(t, err)
+	ml.AssertExpectations(t)
+}
+
+func TestDockerCollectorDiskUsage(t *testing.T) {
+	ml := &mockDockerAPI{}
+	ml.On("GetContainers", mock.Anything, mock.Anything).Return([]container.Summary{}, nil)
+	ml.On("GetSystemInfo", mock.Anything).Return(system.Info{}, nil)
+	ml.On("GetDiskUsage", mock.Anything, mock.Anything).Return(types.DiskUsage{
+		LayersSize: 1000,
+		Containers: []*container.Summary{{SizeRw: 10}, {SizeRw: 20}},
+		Volumes: []*volume.Volume{
+			{UsageData: &volume.UsageData{Size: 50}},
+			{UsageData: &volume.UsageData{Size: -1}},
+			{},
+		},
+		BuildCache: []*types.BuildCache{{Size: 7}},
+	}, nil)
+
+	expected := `
+# HELP yadoma_disk_usage_bytes Disk space used by Docker objects by type.
+# TYPE yadoma_disk_usage_bytes gauge
+yadoma_disk_usage_bytes{type="build_cache"} 7
+yadoma_disk_usage_bytes{type="containers"} 30
+yadoma_disk_usage_bytes{type="images"} 1000
+yadoma_disk_usage_bytes{type="volumes"} 50
+`
+	err := testutil.CollectAndCompare(&DockerCollector{layer: ml}, strings.NewReader(expected),
+		"yadoma_disk_usage_bytes")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,100 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package metrics measures container resource usage inside the agent.
+// It computes derived values from Docker stats the way `docker stats` does, keeps a
+// history of samples in tiers of increasing resolution, for example 10 second points
+// kept for an hour and 1 minute points kept for a day, and exposes containers, the
+// engine, and the agent itself in the Prometheus format.
+//
+// Every history tier is a fixed size ring per container, so the memory used by the
+// history is known up front and bounded by a budget; when the budget is reached, the
+// containers that were updated least recently are dropped first. The history can be
+// saved to and restored from a file, so it survives restarts of the agent.
+//
+// The package is internal to the agent.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// ServerMetrics records metrics about the agent's own gRPC server: handled calls by
+// method and status code, their latency, and the streams that are currently open.
+// It is a Prometheus collector and provides interceptors that feed it.
+type ServerMetrics struct {
+	handled  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	streams  *prometheus.GaugeVec
+}
+
+// NewServerMetrics creates empty gRPC server metrics.
+func NewServerMetrics() *ServerMetrics {
+	return &ServerMetrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_handled_total",
+			Help:      "gRPC calls completed by the agent by method and status code.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_handling_seconds",
+			Help:      "Duration of gRPC calls handled by the agent by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"method"}),
+		streams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_active_streams",
+			Help:      "gRPC streams currently open on the agent by method.",
+		}, []string{"method"}),
+	}
+}
+
+// Describe sends the descriptors of the gRPC server metrics.
+func (m *ServerMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.handled.Describe(ch)
+	m.duration.Describe(ch)
+	m.streams.Describe(ch)
+}
+
+// Collect sends the current gRPC server metrics.
+func (m *ServerMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.handled.Collect(ch)
+	m.duration.Collect(ch)
+	m.streams.Collect(ch)
+}
+
+// Unary returns an interceptor that records unary calls.
+func (m *ServerMetrics) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		m.observe(info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// Stream returns an interceptor that records streaming calls and counts them as
+// active while they are open.
+func (m *ServerMetrics) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		active := m.streams.WithLabelValues(info.FullMethod)
+		active.Inc()
+		defer active.Dec()
+
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (m *ServerMetrics) observe(method string, start time.Time, err error) {
+	m.handled.WithLabelValues(method, status.Code(err).String()).Inc()
+	m.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
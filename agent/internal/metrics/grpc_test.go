@@ -0,0 +1,51 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerMetricsUnary(t *testing.T) {
+	m := NewServerMetrics()
+	info := &grpc.UnaryServerInfo{FullMethod: "/yadoma.ContainerService/GetContainers"}
+	interceptor := m.Unary()
+
+	_, err := interceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return "ok", nil
+	})
+	require.NoError(t, err)
+	_, err = interceptor(context.Background(), nil, info, func(context.Context, any) (any, error) {
+		return nil, status.Error(codes.NotFound, "missing")
+	})
+	require.Error(t, err)
+
+	assert.InDelta(t, 1, testutil.ToFloat64(m.handled.WithLabelValues(info.FullMethod, "OK")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.handled.WithLabelValues(info.FullMethod, "NotFound")), 0)
+	assert.Equal(t, 1, testutil.CollectAndCount(m.duration))
+}
+
+func TestServerMetricsStream(t *testing.T) {
+	m := NewServerMetrics()
+	info := &grpc.StreamServerInfo{FullMethod: "/yadoma.ContainerService/GetContainerLogs"}
+
+	var during float64
+	err := m.Stream()(nil, nil, info, func(any, grpc.ServerStream) error {
+		during = testutil.ToFloat64(m.streams.WithLabelValues(info.FullMethod))
+		return status.Error(codes.Canceled, "canceled")
+	})
+
+	require.Error(t, err)
+	assert.InDelta(t, 1, during, 0)
+	assert.InDelta(t, 0, testutil.ToFloat64(m.streams.WithLabelValues(info.FullMethod)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.handled.WithLabelValues(info.FullMethod, "Canceled")), 0)
+}
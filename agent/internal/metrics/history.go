@@ -0,0 +1,334 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package metrics measures container resource usage inside the agent.
+// It computes derived values from Docker stats the way `docker stats` does, keeps a
+// history of samples in tiers of increasing resolution, for example 10 second points
+// kept for an hour and 1 minute points kept for a day, and exposes containers, the
+// engine, and the agent itself in the Prometheus format.
+//
+// Every history tier is a fixed size ring per container, so the memory used by the
+// history is known up front and bounded by a budget; when the budget is reached, the
+// containers that were updated least recently are dropped first. The history can be
+// saved to and restored from a file, so it survives restarts of the agent.
+//
+// The package is internal to the agent.
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pointSize is the approximate memory used by one Point.
+const pointSize = 128
+
+// Tier is one level of the history: points of Resolution kept for Retention.
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+func (t Tier) capacity() int {
+	return int(t.Retention / t.Resolution)
+}
+
+// ParseTiers parses tiers in the form "10s:1h,1m:24h". Resolutions must increase
+// from tier to tier and every retention must hold at least one point.
+func ParseTiers(v string) ([]Tier, error) {
+	var tiers []Tier
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		res, ret, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid tier %q, expected resolution:retention", item)
+		}
+		resolution, err := time.ParseDuration(res)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resolution in tier %q: %w", item, err)
+		}
+		retention, err := time.ParseDuration(ret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention in tier %q: %w", item, err)
+		}
+		tiers = append(tiers, Tier{Resolution: resolution, Retention: retention})
+	}
+	if err := validateTiers(tiers); err != nil {
+		return nil, err
+	}
+	return tiers, nil
+}
+
+func validateTiers(tiers []Tier) error {
+	if len(tiers) == 0 {
+		return errors.New("at least one tier is required")
+	}
+	for i, t := range tiers {
+		if t.Resolution < time.Second {
+			return fmt.Errorf("tier resolution %s is below 1s", t.Resolution)
+		}
+		if t.Retention < t.Resolution {
+			return fmt.Errorf("tier retention %s is shorter than its resolution %s", t.Retention, t.Resolution)
+		}
+		if i > 0 && t.Resolution <= tiers[i-1].Resolution {
+			return fmt.Errorf("tier resolution %s must be coarser than %s", t.Resolution, tiers[i-1].Resolution)
+		}
+	}
+	return nil
+}
+
+// Sample is one measurement of a container. Network and block I/O values are
+// cumulative counters as reported by Docker.
+type Sample struct {
+	CPUPercent float64
+	MemUsage   uint64
+	MemLimit   uint64
+	NetRx      uint64
+	NetTx      uint64
+	BlockRead  uint64
+	BlockWrite uint64
+	Pids       uint64
+}
+
+// Point aggregates the samples of one resolution interval starting at Time.
+// CPU and memory are averaged and their maximum is kept; counters, the memory
+// limit and the PID count hold the last sample of the interval.
+type Point struct {
+	Time          time.Time
+	CPUPercent    float64
+	CPUPercentMax float64
+	MemUsage      uint64
+	MemUsageMax   uint64
+	MemLimit      uint64
+	NetRx         uint64
+	NetTx         uint64
+	BlockRead     uint64
+	BlockWrite    uint64
+	Pids          uint64
+	Samples       uint64
+}
+
+func newPoint(at time.Time, s Sample) Point {
+	return Point{
+		Time:          at,
+		CPUPercent:    s.CPUPercent,
+		CPUPercentMax: s.CPUPercent,
+		MemUsage:      s.MemUsage,
+		MemUsageMax:   s.MemUsage,
+		MemLimit:      s.MemLimit,
+		NetRx:         s.NetRx,
+		NetTx:         s.NetTx,
+		BlockRead:     s.BlockRead,
+		BlockWrite:    s.BlockWrite,
+		Pids:          s.Pids,
+		Samples:       1,
+	}
+}
+
+func (p *Point) merge(s Sample) {
+	n := float64(p.Samples)
+	p.CPUPercent = (p.CPUPercent*n + s.CPUPercent) / (n + 1)
+	p.CPUPercentMax = max(p.CPUPercentMax, s.CPUPercent)
+	p.MemUsage = uint64((float64(p.MemUsage)*n + float64(s.MemUsage)) / (n + 1))
+	p.MemUsageMax = max(p.MemUsageMax, s.MemUsage)
+	p.MemLimit = s.MemLimit
+	p.NetRx, p.NetTx = s.NetRx, s.NetTx
+	p.BlockRead, p.BlockWrite = s.BlockRead, s.BlockWrite
+	p.Pids = s.Pids
+	p.Samples++
+}
+
+// ring holds the points of one tier of one container. The point of the current
+// interval stays open until a sample of a later interval arrives.
+type ring struct {
+	resolution time.Duration
+	points     []Point
+	next       int
+	open       Point
+	hasOpen    bool
+}
+
+func newRing(t Tier) *ring {
+	return &ring{resolution: t.Resolution, points: make([]Point, 0, t.capacity())}
+}
+
+func (r *ring) add(at time.Time, s Sample) {
+	bucket := at.Truncate(r.resolution)
+	switch {
+	case r.hasOpen && bucket.Equal(r.open.Time):
+		r.open.merge(s)
+		return
+	case r.hasOpen && bucket.Before(r.open.Time):
+		return
+	case r.hasOpen:
+		r.push(r.open)
+	}
+	r.open, r.hasOpen = newPoint(bucket, s), true
+}
+
+func (r *ring) push(p Point) {
+	if len(r.points) < cap(r.points) {
+		r.points = append(r.points, p)
+		return
+	}
+	r.points[r.next] = p
+	r.next = (r.next + 1) % len(r.points)
+}
+
+// all returns the points in time order, including the open one.
+func (r *ring) all() []Point {
+	res := make([]Point, 0, len(r.points)+1)
+	res = append(res, r.points[r.next:]...)
+	res = append(res, r.points[:r.next]...)
+	if r.hasOpen {
+		res = append(res, r.open)
+	}
+	return res
+}
+
+type series struct {
+	name    string
+	updated time.Time
+	rings   []*ring
+}
+
+// Series is the history of one container at one resolution.
+type Series struct {
+	ID         string
+	Name       string
+	Resolution time.Duration
+	Points     []Point
+}
+
+// Store is a bounded in-memory history of container samples. It is safe for
+// concurrent use.
+type Store struct {
+	mu        sync.RWMutex
+	tiers     []Tier
+	maxSeries int
+	series    map[string]*series
+}
+
+// NewStore creates a history with the given tiers that uses at most budget bytes.
+// It returns an error for invalid tiers or a budget too small for one container.
+func NewStore(tiers []Tier, budget int) (*Store, error) {
+	if err := validateTiers(tiers); err != nil {
+		return nil, err
+	}
+	perSeries := 0
+	for _, t := range tiers {
+		perSeries += (t.capacity() + 1) * pointSize
+	}
+	if budget < perSeries {
+		return nil, fmt.Errorf("memory budget of %d bytes cannot hold the history of one container (%d bytes)",
+			budget, perSeries)
+	}
+	return &Store{
+		tiers:     slices.Clone(tiers),
+		maxSeries: budget / perSeries,
+		series:    make(map[string]*series),
+	}, nil
+}
+
+// Resolution returns the finest resolution of the history, which is the interval
+// samples should be added at.
+func (s *Store) Resolution() time.Duration {
+	return s.tiers[0].Resolution
+}
+
+// MaxContainers returns how many containers fit into the memory budget.
+func (s *Store) MaxContainers() int {
+	return s.maxSeries
+}
+
+// Add records a sample of container id taken at the given time.
+// Samples older than the current interval of a tier are ignored by that tier.
+func (s *Store) Add(id, name string, at time.Time, sample Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	se, ok := s.series[id]
+	if !ok {
+		if len(s.series) >= s.maxSeries {
+			s.evict()
+		}
+		se = &series{rings: make([]*ring, len(s.tiers))}
+		for i, t := range s.tiers {
+			se.rings[i] = newRing(t)
+		}
+		s.series[id] = se
+	}
+	se.name = name
+	se.updated = at
+	for _, r := range se.rings {
+		r.add(at, sample)
+	}
+}
+
+// evict drops the container that was updated least recently.
+func (s *Store) evict() {
+	var oldest string
+	for id, se := range s.series {
+		if oldest == "" || se.updated.Before(s.series[oldest].updated) {
+			oldest = id
+		}
+	}
+	delete(s.series, oldest)
+}
+
+// Query returns the points between from and to of the containers in ids, or of
+// all containers if ids is empty. Unknown containers are skipped. If resolution is
+// zero, the finest tier that still retains from is used; otherwise the finest tier
+// at least as coarse as resolution. The result is ordered by container name.
+func (s *Store) Query(ids []string, from, to time.Time, resolution time.Duration) []Series {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tier := s.pickTier(from, resolution)
+	if len(ids) == 0 {
+		for id := range s.series {
+			ids = append(ids, id)
+		}
+	}
+
+	res := make([]Series, 0, len(ids))
+	for _, id := range ids {
+		se, ok := s.series[id]
+		if !ok {
+			continue
+		}
+		var points []Point
+		for _, p := range se.rings[tier].all() {
+			if !p.Time.Add(s.tiers[tier].Resolution).After(from) || (!to.IsZero() && p.Time.After(to)) {
+				continue
+			}
+			points = append(points, p)
+		}
+		res = append(res, Series{ID: id, Name: se.name, Resolution: s.tiers[tier].Resolution, Points: points})
+	}
+	slices.SortFunc(res, func(a, b Series) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return res
+}
+
+func (s *Store) pickTier(from time.Time, resolution time.Duration) int {
+	for i, t := range s.tiers {
+		if t.Resolution < resolution {
+			continue
+		}
+		if resolution > 0 || !from.Before(time.Now().Add(-t.Retention)) {
+			return i
+		}
+	}
+	return len(s.tiers) - 1
+}
@@ -0,0 +1,135 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTiers(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		expected  []Tier
+		expectErr bool
+	}{
+		{
+			name:  "two tiers",
+			value: "10s:1h, 1m:24h",
+			expected: []Tier{
+				{Resolution: 10 * time.Second, Retention: time.Hour},
+				{Resolution: time.Minute, Retention: 24 * time.Hour},
+			},
+		},
+		{name: "empty", value: "", expectErr: true},
+		{name: "missing retention", value: "10s", expectErr: true},
+		{name: "invalid duration", value: "10x:1h", expectErr: true},
+		{name: "resolution below one second", value: "500ms:1h", expectErr: true},
+		{name: "retention shorter than resolution", value: "1m:30s", expectErr: true},
+		{name: "resolutions not increasing", value: "1m:1h,10s:24h", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers, err := ParseTiers(tt.value)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, tiers)
+		})
+	}
+}
+
+func newTestStore(t *testing.T, budget int) *Store {
+	t.Helper()
+	s, err := NewStore([]Tier{
+		{Resolution: 10 * time.Second, Retention: time.Minute},
+		{Resolution: time.Minute, Retention: time.Hour},
+	}, budget)
+	require.NoError(t, err)
+	return s
+}
+
+func TestStoreAggregation(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	start := time.Now().Truncate(time.Minute)
+
+	s.Add("c1", "web", start.Add(1*time.Second), Sample{CPUPercent: 10, MemUsage: 100, NetRx: 1, Pids: 2})
+	s.Add("c1", "web", start.Add(5*time.Second), Sample{CPUPercent: 30, MemUsage: 300, NetRx: 5, Pids: 3})
+	s.Add("c1", "web", start.Add(12*time.Second), Sample{CPUPercent: 50, MemUsage: 500, NetRx: 9, Pids: 4})
+
+	fine := s.Query(nil, start, time.Time{}, 0)
+	require.Len(t, fine, 1)
+	assert.Equal(t, 10*time.Second, fine[0].Resolution)
+	require.Len(t, fine[0].Points, 2)
+	assert.Equal(t, Point{
+		Time:          start,
+		CPUPercent:    20,
+		CPUPercentMax: 30,
+		MemUsage:      200,
+		MemUsageMax:   300,
+		NetRx:         5,
+		Pids:          3,
+		Samples:       2,
+	}, fine[0].Points[0])
+	assert.Equal(t, start.Add(10*time.Second), fine[0].Points[1].Time)
+
+	coarse := s.Query([]string{"c1"}, start, time.Time{}, time.Minute)
+	require.Len(t, coarse, 1)
+	assert.Equal(t, time.Minute, coarse[0].Resolution)
+	require.Len(t, coarse[0].Points, 1)
+	assert.InDelta(t, 30, coarse[0].Points[0].CPUPercent, 0.001)
+	assert.Equal(t, uint64(3), coarse[0].Points[0].Samples)
+}
+
+func TestStoreRetention(t *testing.T) {
+	s := newTestStore(t, 1<<20)
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	for i := range 20 {
+		s.Add("c1", "web", start.Add(time.Duration(i)*10*time.Second), Sample{Pids: uint64(i)})
+	}
+
+	// The fine tier keeps 6 closed points and the open one.
+	fine := s.Query(nil, start, time.Time{}, 10*time.Second)
+	require.Len(t, fine[0].Points, 7)
+	assert.Equal(t, uint64(13), fine[0].Points[0].Pids)
+	assert.Equal(t, uint64(19), fine[0].Points[6].Pids)
+
+	// The fine tier no longer covers start, so the coarse one is picked.
+	auto := s.Query(nil, start, time.Time{}, 0)
+	assert.Equal(t, time.Minute, auto[0].Resolution)
+	require.Len(t, auto[0].Points, 4)
+
+	bounded := s.Query(nil, start.Add(time.Minute), start.Add(2*time.Minute), time.Minute)
+	require.Len(t, bounded[0].Points, 2)
+	assert.Equal(t, start.Add(time.Minute), bounded[0].Points[0].Time)
+}
+
+func TestStoreBudget(t *testing.T) {
+	_, err := NewStore([]Tier{{Resolution: 10 * time.Second, Retention: time.Hour}}, 1024)
+	require.Error(t, err)
+
+	// Each container needs 7 + 61 points.
+	s := newTestStore(t, 2*68*pointSize)
+	require.Equal(t, 2, s.MaxContainers())
+	now := time.Now()
+
+	s.Add("c1", "web", now.Add(-2*time.Second), Sample{})
+	s.Add("c2", "db", now.Add(-3*time.Second), Sample{})
+	s.Add("c1", "web", now.Add(-time.Second), Sample{})
+	s.Add("c3", "cache", now, Sample{})
+
+	var names []string
+	for _, se := range s.Query(nil, now.Add(-time.Minute), time.Time{}, 0) {
+		names = append(names, se.Name)
+	}
+	assert.Equal(t, []string{"cache", "web"}, names)
+	assert.Empty(t, s.Query([]string{"c2"}, now.Add(-time.Minute), time.Time{}, 0))
+}
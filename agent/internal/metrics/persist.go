@@ -0,0 +1,150 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package metrics measures container resource usage inside the agent.
+// It computes derived values from Docker stats the way `docker stats` does, keeps a
+// history of samples in tiers of increasing resolution, for example 10 second points
+// kept for an hour and 1 minute points kept for a day, and exposes containers, the
+// engine, and the agent itself in the Prometheus format.
+//
+// Every history tier is a fixed size ring per container, so the memory used by the
+// history is known up front and bounded by a budget; when the budget is reached, the
+// containers that were updated least recently are dropped first. The history can be
+// saved to and restored from a file, so it survives restarts of the agent.
+//
+// The package is internal to the agent.
+package metrics
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type snapshot struct {
+	Series []seriesSnapshot
+}
+
+type seriesSnapshot struct {
+	ID      string
+	Name    string
+	Updated time.Time
+	Tiers   []tierSnapshot
+}
+
+type tierSnapshot struct {
+	Resolution time.Duration
+	Points     []Point
+}
+
+// Save writes the history to path. The file is replaced atomically, so a crash
+// while saving leaves the previous file intact.
+func (s *Store) Save(path string) error {
+	s.mu.RLock()
+	snap := snapshot{Series: make([]seriesSnapshot, 0, len(s.series))}
+	for id, se := range s.series {
+		ss := seriesSnapshot{ID: id, Name: se.name, Updated: se.updated}
+		for _, r := range se.rings {
+			ss.Tiers = append(ss.Tiers, tierSnapshot{Resolution: r.resolution, Points: r.all()})
+		}
+		snap.Series = append(snap.Series, ss)
+	}
+	s.mu.RUnlock()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create stats history file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err = gob.NewEncoder(tmp).Encode(&snap); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("cannot encode stats history: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write stats history file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot replace stats history file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Load restores the history saved at path. A missing file is not an error.
+// Points of tiers whose resolution is no longer configured are dropped, and if the
+// file holds more containers than the budget allows, the least recently updated
+// ones are dropped.
+func (s *Store) Load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot open stats history file: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var snap snapshot
+	if err = gob.NewDecoder(f).Decode(&snap); err != nil {
+		return fmt.Errorf("cannot decode stats history file %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ss := range snap.Series {
+		se := &series{name: ss.Name, updated: ss.Updated, rings: make([]*ring, len(s.tiers))}
+		for i, t := range s.tiers {
+			se.rings[i] = newRing(t)
+			for _, ts := range ss.Tiers {
+				if ts.Resolution == t.Resolution {
+					se.rings[i].restore(ts.Points)
+				}
+			}
+		}
+		if _, ok := s.series[ss.ID]; !ok && len(s.series) >= s.maxSeries {
+			s.evict()
+		}
+		s.series[ss.ID] = se
+	}
+	return nil
+}
+
+// restore refills an empty ring with saved points in time order. The last point
+// becomes the open one, so samples of the same interval keep merging into it.
+func (r *ring) restore(points []Point) {
+	if len(points) == 0 {
+		return
+	}
+	for _, p := range points[:len(points)-1] {
+		r.push(p)
+	}
+	r.open, r.hasOpen = points[len(points)-1], true
+}
+
+// Persist saves the history to path every interval until ctx is canceled.
+// Failed saves are logged and retried on the next interval.
+func (s *Store) Persist(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Save(path); err != nil {
+				log.Warn().Err(err).Msg("Cannot save stats history")
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.gob")
+	start := time.Now().Truncate(time.Minute)
+
+	s := newTestStore(t, 1<<20)
+	s.Add("c1", "web", start, Sample{CPUPercent: 10})
+	s.Add("c1", "web", start.Add(10*time.Second), Sample{CPUPercent: 20})
+	require.NoError(t, s.Save(path))
+
+	restored := newTestStore(t, 1<<20)
+	require.NoError(t, restored.Load(path))
+	assert.Equal(t, s.Query(nil, start, time.Time{}, 0), restored.Query(nil, start, time.Time{}, 0))
+
+	// Samples of the last saved interval keep merging into it.
+	restored.Add("c1", "web", start.Add(15*time.Second), Sample{CPUPercent: 40})
+	points := restored.Query(nil, start, time.Time{}, 10*time.Second)[0].Points
+	require.Len(t, points, 2)
+	assert.InDelta(t, 30, points[1].CPUPercent, 0.001)
+}
+
+func TestStoreLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, 1<<20)
+
+	require.NoError(t, s.Load(filepath.Join(dir, "missing.gob")))
+
+	broken := filepath.Join(dir, "broken.gob")
+	require.NoError(t, os.WriteFile(broken, []byte("not a history"), 0o600))
+	assert.Error(t, s.Load(broken))
+}
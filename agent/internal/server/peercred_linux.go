@@ -0,0 +1,43 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package server owns the lifecycle of the agent's gRPC server.
+// It creates the underlying grpc.Server, registers service implementations,
+// serves them on one or more listeners, and performs a bounded graceful shutdown.
+//
+// On shutdown the server stops accepting new RPCs and lets in-flight calls drain
+// for a configurable period. Long-lived streams (for example, followed logs or
+// stats) that are still open after the drain period are canceled through their
+// context, so handlers can release Docker resources and return cleanly before
+// the server is forcibly stopped.
+//
+// The package is intended for internal use by the agent's entrypoint.
+package server
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func peerCredentials(conn *net.UnixConn) (PeerCredInfo, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCredInfo{}, fmt.Errorf("cannot access unix socket: %w", err)
+	}
+
+	var (
+		cred    *syscall.Ucred
+		credErr error
+	)
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCredInfo{}, fmt.Errorf("cannot access unix socket: %w", err)
+	}
+	if credErr != nil {
+		return PeerCredInfo{}, fmt.Errorf("cannot read peer credentials: %w", credErr)
+	}
+
+	return PeerCredInfo{UID: cred.Uid, GID: cred.Gid, PID: cred.Pid}, nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package server owns the lifecycle of the agent's gRPC server.
+// It creates the underlying grpc.Server, registers service implementations,
+// serves them on one or more listeners, and performs a bounded graceful shutdown.
+//
+// On shutdown the server stops accepting new RPCs and lets in-flight calls drain
+// for a configurable period. Long-lived streams (for example, followed logs or
+// stats) that are still open after the drain period are canceled through their
+// context, so handlers can release Docker resources and return cleanly before
+// the server is forcibly stopped.
+//
+// The package is intended for internal use by the agent's entrypoint.
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCredentials(*net.UnixConn) (PeerCredInfo, error) {
+	return PeerCredInfo{}, errors.New("peer credentials are only supported on Linux")
+}
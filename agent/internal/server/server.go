@@ -0,0 +1,142 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package server owns the lifecycle of the agent's gRPC server.
+// It creates the underlying grpc.Server, registers service implementations,
+// serves them on one or more listeners, and performs a bounded graceful shutdown.
+//
+// On shutdown the server stops accepting new RPCs and lets in-flight calls drain
+// for a configurable period. Long-lived streams (for example, followed logs or
+// stats) that are still open after the drain period are canceled through their
+// context, so handlers can release Docker resources and return cleanly before
+// the server is forcibly stopped.
+//
+// The package is intended for internal use by the agent's entrypoint.
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cancelGrace bounds how long canceled streams may take to return before the
+// server is stopped forcibly.
+const cancelGrace = 5 * time.Second
+
+// Registrar is implemented by services that can attach themselves to a gRPC server.
+type Registrar interface {
+	Register(rpc *grpc.Server)
+}
+
+// Server wraps a gRPC server and the context its streams derive from, so that
+// Shutdown can drain in-flight calls and cancel the streams that remain open.
+type Server struct {
+	rpc    *grpc.Server
+	ctx    context.Context
+	cancel context.CancelFunc
+	drain  time.Duration
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the stream context that is additionally canceled on shutdown.
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// NewServer constructs a Server with the provided gRPC options.
+// The drain duration bounds how long Shutdown waits for in-flight RPCs before
+// canceling the remaining streams. A stream interceptor is installed so that
+// every streaming handler observes shutdown through its context.
+func NewServer(drain time.Duration, opts ...grpc.ServerOption) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		ctx:    ctx,
+		cancel: cancel,
+		drain:  drain,
+	}
+
+	opts = append(opts, grpc.ChainStreamInterceptor(s.streamInterceptor))
+	s.rpc = grpc.NewServer(opts...)
+	return s
+}
+
+// Register attaches the given services to the underlying gRPC server.
+// Call it before Serve; registering a service twice panics.
+func (s *Server) Register(services ...Registrar) {
+	for _, svc := range services {
+		svc.Register(s.rpc)
+	}
+}
+
+// Serve accepts connections on lis and blocks until the server stops.
+// It may be called concurrently for several listeners. A stop caused by
+// Shutdown is reported as nil; any other serving failure is returned.
+func (s *Server) Serve(lis net.Listener) error {
+	log.Info().Str("addr", lis.Addr().String()).Msg("gRPC server listening")
+	if err := s.rpc.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the server gracefully.
+// New RPCs are rejected immediately and in-flight calls get the drain period to
+// complete. Streams still open after that are canceled via their context and,
+// if they do not return within a short grace period, the server is stopped
+// forcibly. Shutdown blocks until all handlers have returned.
+func (s *Server) Shutdown() {
+	done := make(chan struct{})
+	go func() {
+		s.rpc.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info().Msg("gRPC server drained")
+		return
+	case <-time.After(s.drain):
+	}
+
+	log.Warn().Dur("drain", s.drain).Msg("Drain period elapsed, canceling in-flight streams")
+	s.cancel()
+
+	select {
+	case <-done:
+		log.Info().Msg("gRPC server stopped after canceling streams")
+	case <-time.After(cancelGrace):
+		log.Warn().Msg("Streams did not return in time, forcing gRPC server stop")
+		s.rpc.Stop()
+		<-done
+	}
+}
+
+func (s *Server) streamInterceptor(
+	srv any,
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	ctx, cancel := context.WithCancel(ss.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.ctx, cancel)
+	defer stop()
+
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	if err != nil && s.ctx.Err() != nil {
+		log.Debug().Err(err).Str("method", info.FullMethod).Msg("Stream canceled by shutdown")
+		return status.Error(codes.Unavailable, "agent is shutting down")
+	}
+	return err
+}
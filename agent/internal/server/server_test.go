@@ -0,0 +1,95 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type healthRegistrar struct {
+	srv *health.Server
+}
+
+func (h *healthRegistrar) Register(rpc *grpc.Server) {
+	healthpb.RegisterHealthServer(rpc, h.srv)
+}
+
+func startTestServer(t *testing.T, drain time.Duration) (*Server, *grpc.ClientConn, chan error) {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(drain)
+	s.Register(&healthRegistrar{srv: health.NewServer()})
+
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return s, conn, served
+}
+
+func TestServerServeAndShutdown(t *testing.T) {
+	s, conn, served := startTestServer(t, time.Second)
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+
+	s.Shutdown()
+
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+}
+
+func TestServerShutdownCancelsStreams(t *testing.T) {
+	s, conn, served := startTestServer(t, 50*time.Millisecond)
+
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	start := time.Now()
+	s.Shutdown()
+	assert.Less(t, time.Since(start), cancelGrace)
+
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.NoError(t, <-served)
+}
+
+func TestServerRejectsAfterShutdown(t *testing.T) {
+	s, conn, _ := startTestServer(t, time.Second)
+	s.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,191 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package server owns the lifecycle of the agent's gRPC server.
+// It creates the underlying grpc.Server, registers service implementations,
+// serves them on one or more listeners, and performs a bounded graceful shutdown.
+//
+// On shutdown the server stops accepting new RPCs and lets in-flight calls drain
+// for a configurable period. Long-lived streams (for example, followed logs or
+// stats) that are still open after the drain period are canceled through their
+// context, so handlers can release Docker resources and return cleanly before
+// the server is forcibly stopped.
+//
+// The package is intended for internal use by the agent's entrypoint.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// CertReloader serves the agent's TLS identity and the trusted client CA pool
+// from files on disk and reloads them when they change.
+// Handshakes always use the most recently loaded material; connections that are
+// already established, including open streams, are not affected by a reload.
+type CertReloader struct {
+	certFile    string
+	keyFile     string
+	caFile      string
+	clientNames []string
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+	stamps [3]fileStamp
+}
+
+// NewCertReloader loads the server certificate, private key, and client CA bundle.
+// If clientNames is not empty, a client certificate must additionally carry one of
+// these names as its common name or a DNS SAN.
+// It returns an error if any file cannot be read or parsed.
+func NewCertReloader(certFile, keyFile, caFile string, clientNames []string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile:    certFile,
+		keyFile:     keyFile,
+		caFile:      caFile,
+		clientNames: clientNames,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// TLSConfig returns a server configuration that enforces mutual TLS.
+// The certificate and client CA pool are resolved per handshake, so rotated files
+// take effect for new connections without restarting the server.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:       tls.VersionTLS12,
+				Certificates:     []tls.Certificate{*r.cert},
+				ClientCAs:        r.pool,
+				ClientAuth:       tls.RequireAndVerifyClientCert,
+				VerifyConnection: r.verifyClientName,
+			}, nil
+		},
+	}
+}
+
+// Watch polls the certificate files every interval and reloads them when their
+// modification time or size changes. A failed reload is logged and the previous
+// material stays in use. Watch blocks until ctx is canceled.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := r.changed()
+			if err != nil {
+				log.Warn().Err(err).Msg("Cannot stat TLS files")
+				continue
+			}
+			if !changed {
+				continue
+			}
+			if err = r.reload(); err != nil {
+				log.Error().Err(err).Msg("Cannot reload TLS certificates, keeping previous ones")
+				continue
+			}
+			log.Info().Str("cert", r.certFile).Msg("TLS certificates reloaded")
+		}
+	}
+}
+
+func (r *CertReloader) reload() error {
+	stamps, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	// Remember the attempt even if it fails, so broken files are reported once
+	// per change instead of on every poll.
+	r.mu.Lock()
+	r.stamps = stamps
+	r.mu.Unlock()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load server key pair: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(r.caFile)
+	if err != nil {
+		return fmt.Errorf("cannot read client CA %s: %w", r.caFile, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no certificates found in client CA %s", r.caFile)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *CertReloader) changed() (bool, error) {
+	stamps, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return stamps != r.stamps, nil
+}
+
+func (r *CertReloader) stat() ([3]fileStamp, error) {
+	var stamps [3]fileStamp
+	for i, name := range []string{r.certFile, r.keyFile, r.caFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return stamps, fmt.Errorf("cannot stat %s: %w", name, err)
+		}
+		stamps[i] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return stamps, nil
+}
+
+func (r *CertReloader) verifyClientName(cs tls.ConnectionState) error {
+	if len(r.clientNames) == 0 {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("client certificate is required")
+	}
+
+	leaf := cs.PeerCertificates[0]
+	if slices.Contains(r.clientNames, leaf.Subject.CommonName) {
+		return nil
+	}
+	for _, name := range leaf.DNSNames {
+		if slices.Contains(r.clientNames, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("client certificate %q is not allowed", leaf.Subject.CommonName)
+}
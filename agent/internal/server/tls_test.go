@@ -0,0 +1,251 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func handshake(t *testing.T, serverCfg *tls.Config, clientCfg *tls.Config) (*x509.Certificate, error) {
+	t.Helper()
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+
+	srvErr := make(chan error, 1)
+	go func() {
+		srv := tls.Server(sc, serverCfg)
+		if err := srv.Handshake(); err != nil {
+			_ = srv.Close()
+			srvErr <- err
+			return
+		}
+		_, err := srv.Write([]byte{1})
+		srvErr <- err
+	}()
+
+	client := tls.Client(cc, clientCfg)
+	err := client.Handshake()
+	if err == nil {
+		// TLS 1.3 reports client certificate rejection on the first read.
+		_, err = client.Read(make([]byte, 1))
+	}
+	sErr := <-srvErr
+	if err == nil {
+		err = sErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestCertReloaderMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "yadoma-ca")
+	srvCert, srvKey := ca.issue(t, "agent", x509.ExtKeyUsageServerAuth)
+	webCert, webKey := ca.issue(t, "webapp", x509.ExtKeyUsageClientAuth)
+	otherCert, otherKey := ca.issue(t, "intruder", x509.ExtKeyUsageClientAuth)
+
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, srvCert)
+	writeFile(t, keyFile, srvKey)
+	writeFile(t, caFile, ca.pem)
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+
+	clientCfg := func(certPEM, keyPEM []byte) *tls.Config {
+		cfg := &tls.Config{RootCAs: roots, ServerName: "agent", MinVersion: tls.VersionTLS12}
+		if certPEM != nil {
+			pair, err := tls.X509KeyPair(certPEM, keyPEM)
+			require.NoError(t, err)
+			cfg.Certificates = []tls.Certificate{pair}
+		}
+		return cfg
+	}
+
+	tests := []struct {
+		name        string
+		clientNames []string
+		certPEM     []byte
+		keyPEM      []byte
+		expectErr   bool
+	}{
+		{name: "valid client certificate", certPEM: webCert, keyPEM: webKey},
+		{name: "missing client certificate", expectErr: true},
+		{name: "allowed client name", clientNames: []string{"webapp"}, certPEM: webCert, keyPEM: webKey},
+		{name: "client name not allowed", clientNames: []string{"webapp"}, certPEM: otherCert, keyPEM: otherKey,
+			expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewCertReloader(certFile, keyFile, caFile, tt.clientNames)
+			require.NoError(t, err)
+
+			_, err = handshake(t, r.TLSConfig(), clientCfg(tt.certPEM, tt.keyPEM))
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "yadoma-ca")
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	srvCert, srvKey := ca.issue(t, "agent", x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, srvCert)
+	writeFile(t, keyFile, srvKey)
+	writeFile(t, caFile, ca.pem)
+
+	r, err := NewCertReloader(certFile, keyFile, caFile, nil)
+	require.NoError(t, err)
+
+	webCert, webKey := ca.issue(t, "webapp", x509.ExtKeyUsageClientAuth)
+	pair, err := tls.X509KeyPair(webCert, webKey)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	clientCfg := &tls.Config{RootCAs: roots, ServerName: "agent", Certificates: []tls.Certificate{pair},
+		MinVersion: tls.VersionTLS12}
+
+	before, err := handshake(t, r.TLSConfig(), clientCfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	t.Run("invalid files keep previous certificate", func(t *testing.T) {
+		writeFile(t, keyFile, []byte("garbage"))
+		time.Sleep(50 * time.Millisecond)
+
+		got, err := handshake(t, r.TLSConfig(), clientCfg)
+		require.NoError(t, err)
+		assert.Equal(t, before.SerialNumber, got.SerialNumber)
+	})
+
+	t.Run("rotated certificate is served", func(t *testing.T) {
+		newCert, newKey := ca.issue(t, "agent", x509.ExtKeyUsageServerAuth)
+		writeFile(t, certFile, newCert)
+		writeFile(t, keyFile, newKey)
+
+		assert.Eventually(t, func() bool {
+			got, err := handshake(t, r.TLSConfig(), clientCfg)
+			return err == nil && got.SerialNumber.Cmp(before.SerialNumber) != 0
+		}, 2*time.Second, 20*time.Millisecond)
+	})
+}
+
+func TestNewCertReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "yadoma-ca")
+	srvCert, srvKey := ca.issue(t, "agent", x509.ExtKeyUsageServerAuth)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeFile(t, certFile, srvCert)
+	writeFile(t, keyFile, srvKey)
+	badCA := filepath.Join(dir, "bad-ca.crt")
+	writeFile(t, badCA, []byte("not a certificate"))
+
+	tests := []struct {
+		name    string
+		caFile  string
+		errText string
+	}{
+		{name: "missing CA file", caFile: filepath.Join(dir, "missing.crt"), errText: "cannot stat"},
+		{name: "CA without certificates", caFile: badCA, errText: "no certificates found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewCertReloader(certFile, keyFile, tt.caFile, nil)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errText)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package server owns the lifecycle of the agent's gRPC server.
+// It creates the underlying grpc.Server, registers service implementations,
+// serves them on one or more listeners, and performs a bounded graceful shutdown.
+//
+// On shutdown the server stops accepting new RPCs and lets in-flight calls drain
+// for a configurable period. Long-lived streams (for example, followed logs or
+// stats) that are still open after the drain period are canceled through their
+// context, so handlers can release Docker resources and return cleanly before
+// the server is forcibly stopped.
+//
+// The package is intended for internal use by the agent's entrypoint.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// PeerCredInfo is the credentials.AuthInfo attached to connections accepted on
+// a Unix domain socket. It carries the kernel-reported identity of the peer
+// process (SO_PEERCRED), which cannot be forged by the client.
+type PeerCredInfo struct {
+	credentials.CommonAuthInfo
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+type transportCredentials struct {
+	base credentials.TransportCredentials
+}
+
+// AuthType identifies the peer-credential authentication mechanism.
+func (PeerCredInfo) AuthType() string {
+	return "peercred"
+}
+
+// ListenUnix creates a Unix domain socket listener at path.
+// A stale socket left by a previous run is removed first; any other existing
+// file is an error. The socket file mode is set to mode and, if group is not
+// empty, its group ownership is changed to the named (or numeric) group.
+func ListenUnix(path string, mode fs.FileMode, group string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("cannot listen on %s: file exists and is not a socket", path)
+		}
+		if err = os.Remove(path); err != nil {
+			return nil, fmt.Errorf("cannot remove stale socket %s: %w", path, err)
+		}
+	}
+
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on unix socket %s: %w", path, err)
+	}
+
+	if err = os.Chmod(path, mode); err != nil {
+		_ = lis.Close()
+		return nil, fmt.Errorf("cannot set mode of socket %s: %w", path, err)
+	}
+
+	if group != "" {
+		gid, gErr := lookupGroup(group)
+		if gErr != nil {
+			_ = lis.Close()
+			return nil, gErr
+		}
+		if err = os.Chown(path, -1, gid); err != nil {
+			_ = lis.Close()
+			return nil, fmt.Errorf("cannot change group of socket %s: %w", path, err)
+		}
+	}
+
+	return lis, nil
+}
+
+// NewTransportCredentials wraps base so that connections accepted on a Unix
+// domain socket skip base's handshake and are annotated with PeerCredInfo,
+// while every other connection is handed to base unchanged. This lets a single
+// gRPC server serve TLS over TCP and local peers over a Unix socket at once.
+func NewTransportCredentials(base credentials.TransportCredentials) credentials.TransportCredentials {
+	return &transportCredentials{base: base}
+}
+
+// ClientHandshake delegates to the wrapped credentials; the agent never dials.
+func (c *transportCredentials) ClientHandshake(
+	ctx context.Context,
+	authority string,
+	conn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	return c.base.ClientHandshake(ctx, authority, conn)
+}
+
+// ServerHandshake reads SO_PEERCRED for Unix socket connections and delegates
+// all other connections to the wrapped credentials.
+func (c *transportCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return c.base.ServerHandshake(conn)
+	}
+
+	info, err := peerCredentials(uc)
+	if err != nil {
+		return nil, nil, err
+	}
+	info.SecurityLevel = credentials.PrivacyAndIntegrity
+	return conn, info, nil
+}
+
+// Info returns the protocol information of the wrapped credentials.
+func (c *transportCredentials) Info() credentials.ProtocolInfo {
+	return c.base.Info()
+}
+
+// Clone returns a copy wrapping a clone of the underlying credentials.
+func (c *transportCredentials) Clone() credentials.TransportCredentials {
+	return &transportCredentials{base: c.base.Clone()}
+}
+
+// OverrideServerName delegates to the wrapped credentials.
+func (c *transportCredentials) OverrideServerName(name string) error {
+	return c.base.OverrideServerName(name)
+}
+
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		var unknown user.UnknownGroupError
+		if errors.As(err, &unknown) {
+			return 0, fmt.Errorf("unknown group %q", group)
+		}
+		return 0, fmt.Errorf("cannot look up group %q: %w", group, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
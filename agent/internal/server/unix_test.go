@@ -0,0 +1,106 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package server
+
+import (
+	"context"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+)
+
+type peerHealthServer struct {
+	healthpb.UnimplementedHealthServer
+	got chan *peer.Peer
+}
+
+func (h *peerHealthServer) Check(ctx context.Context,
+	_ *healthpb.HealthCheckRequest,
+) (*healthpb.HealthCheckResponse, error) {
+	p, _ := peer.FromContext(ctx)
+	h.got <- p
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func (h *peerHealthServer) Register(rpc *grpc.Server) {
+	healthpb.RegisterHealthServer(rpc, h)
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("sets mode and group", func(t *testing.T) {
+		path := filepath.Join(dir, "agent.sock")
+		lis, err := ListenUnix(path, 0o660, strconv.Itoa(os.Getgid()))
+		require.NoError(t, err)
+		defer lis.Close()
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, fs.ModeSocket, fi.Mode().Type())
+		assert.Equal(t, fs.FileMode(0o660), fi.Mode().Perm())
+	})
+
+	t.Run("replaces stale socket", func(t *testing.T) {
+		path := filepath.Join(dir, "stale.sock")
+		stale, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, stale.Close())
+
+		lis, err := ListenUnix(path, 0o600, "")
+		require.NoError(t, err)
+		assert.NoError(t, lis.Close())
+	})
+
+	t.Run("refuses regular file", func(t *testing.T) {
+		path := filepath.Join(dir, "regular")
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+		_, err := ListenUnix(path, 0o600, "")
+		assert.ErrorContains(t, err, "not a socket")
+	})
+
+	t.Run("unknown group", func(t *testing.T) {
+		_, err := ListenUnix(filepath.Join(dir, "group.sock"), 0o600, "yadoma-no-such-group")
+		assert.ErrorContains(t, err, "group")
+	})
+}
+
+func TestServeUnixWithPeerCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	lis, err := ListenUnix(path, 0o600, "")
+	require.NoError(t, err)
+
+	h := &peerHealthServer{got: make(chan *peer.Peer, 1)}
+	s := NewServer(time.Second, grpc.Creds(NewTransportCredentials(insecure.NewCredentials())))
+	s.Register(h)
+	go func() { _ = s.Serve(lis) }()
+	defer s.Shutdown()
+
+	conn, err := grpc.NewClient("unix://"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	p := <-h.got
+	info, ok := p.AuthInfo.(PeerCredInfo)
+	require.True(t, ok, "expected PeerCredInfo, got %T", p.AuthInfo)
+	assert.Equal(t, uint32(os.Getuid()), info.UID)
+	assert.Equal(t, uint32(os.Getgid()), info.GID)
+	assert.Equal(t, int32(os.Getpid()), info.PID)
+}
@@ -0,0 +1,289 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultStatsInterval = 5 * time.Second
+	minStatsInterval     = time.Second
+	maxStatsInterval     = time.Hour
+	// statsWorkers limits the Docker stats connections open at the same time.
+	statsWorkers = 8
+	// statsSampleTimeout bounds one sampling round, so a hanging container cannot
+	// stall the stream.
+	statsSampleTimeout = 10 * time.Second
+)
+
+// StreamAllStats streams statistics of all running containers in one stream.
+// Every interval (5 seconds by default, between 1 second and 1 hour) it samples each
+// running container once and sends a single batch with the computed metrics of
+// GetContainerStats; the first batch is sent right away. Samples are one-shot
+// snapshots taken by a small pool of workers, so only a bounded number of Docker
+// requests is open at a time. The CPU percent of a container is computed from its
+// sample in the previous batch, so it is zero in the first batch the container
+// appears in. Containers that fail to sample, for example because they stopped
+// meanwhile, are left out of the batch. Running containers are tracked through Docker events, so
+// started containers join the next batch and stopped ones are dropped. If the events are
+// interrupted, they are resubscribed with backoff from the last one received, and the
+// container list is read again on every tick until then.
+// Returns InvalidArgument for an out of range interval and Internal if the running
+// containers cannot be listed.
+func (s *Service) StreamAllStats(
+	req *protos.StreamAllStatsRequest,
+	stream protos.ContainerService_StreamAllStatsServer,
+) error {
+	interval := defaultStatsInterval
+	if req.GetIntervalMs() != 0 {
+		interval = time.Duration(req.GetIntervalMs()) * time.Millisecond
+	}
+	if interval < minStatsInterval || interval > maxStatsInterval {
+		return status.Errorf(codes.InvalidArgument, "interval must be between %s and %s",
+			minStatsInterval, maxStatsInterval)
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	// Subscribe before listing, so containers started in between are not missed.
+	tracker := &runningTracker{
+		service: s,
+		cursor:  service.NewEventCursor(time.Now().UnixNano()),
+		backoff: service.NewBackoff(service.EventsRetryMin, service.EventsRetryMax),
+	}
+	tracker.subscribe(ctx)
+	if err := tracker.list(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sampler := newStatsSampler(s.layer)
+	for {
+		samples := sampler.sampleAll(ctx, tracker.ids())
+		batch := make([]*protos.GetContainerStatsResponse, 0, len(samples))
+		for _, sample := range samples {
+			batch = append(batch, sample.stats)
+		}
+		err := stream.Send(&protos.StreamAllStatsResponse{
+			TimestampNano: time.Now().UnixNano(),
+			Stats:         batch,
+		})
+		if err != nil {
+			return err
+		}
+		if err = tracker.wait(ctx, ticker.C); err != nil {
+			return err
+		}
+	}
+}
+
+// runningTracker keeps the set of running containers up to date from Docker events.
+// The events are watched from cursor and resubscribed with backoff once interrupted.
+type runningTracker struct {
+	service     *Service
+	running     map[string]struct{}
+	events      <-chan events.Message
+	errs        <-chan error
+	resubscribe <-chan time.Time
+	cursor      *service.EventCursor
+	backoff     *service.Backoff
+}
+
+// subscribe watches start and die events of containers from the cursor.
+func (t *runningTracker) subscribe(ctx context.Context) {
+	t.events, t.errs = t.service.layer.GetEvents(ctx, events.ListOptions{
+		Since: t.cursor.Since(),
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("event", string(events.ActionStart)),
+			filters.Arg("event", string(events.ActionDie)),
+		),
+	})
+}
+
+func (t *runningTracker) list(ctx context.Context) error {
+	list, err := t.service.layer.GetContainers(ctx, container.ListOptions{})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot list containers: %v", err)
+	}
+	t.running = make(map[string]struct{}, len(list))
+	for _, c := range list {
+		t.running[c.ID] = struct{}{}
+	}
+	return nil
+}
+
+func (t *runningTracker) ids() []string {
+	ids := make([]string, 0, len(t.running))
+	for id := range t.running {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// wait applies container events until the next tick. While the events are
+// interrupted, it lists the running containers on every tick instead.
+func (t *runningTracker) wait(ctx context.Context, tick <-chan time.Time) error {
+	for {
+		select {
+		case ev := <-t.events:
+			if !t.cursor.Advance(ev) {
+				continue
+			}
+			t.backoff.Reset()
+			if ev.Action == events.ActionStart {
+				t.running[ev.Actor.ID] = struct{}{}
+			} else {
+				delete(t.running, ev.Actor.ID)
+			}
+		case err := <-t.errs:
+			t.events, t.errs = nil, nil
+			if ctx.Err() == nil {
+				retry := t.backoff.Next()
+				log.Warn().Err(err).Dur("retry_in", retry).Msg("Container events interrupted, resubscribing")
+				t.resubscribe = time.After(retry)
+			}
+		case <-t.resubscribe:
+			t.resubscribe = nil
+			t.subscribe(ctx)
+		case <-tick:
+			if t.events == nil {
+				return t.list(ctx)
+			}
+			return nil
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// statsSampler takes one-shot stats samples, which the daemon answers right away,
+// and computes the CPU percent of every container from its previous sample. As it
+// keeps those samples between rounds, a sampler belongs to one stream or recorder.
+type statsSampler struct {
+	layer layerAPI
+	mu    sync.Mutex
+	prev  map[string]container.CPUStats
+}
+
+func newStatsSampler(layer layerAPI) *statsSampler {
+	return &statsSampler{layer: layer, prev: make(map[string]container.CPUStats)}
+}
+
+// containerSample is one stats sample of a container. The CPU percent is only known
+// if the container was sampled before; otherwise it is zero.
+type containerSample struct {
+	stats    *protos.GetContainerStatsResponse
+	cpuKnown bool
+}
+
+// sampleAll takes one stats sample of every container in ids using at most
+// statsWorkers concurrent Docker requests, and forgets the previous samples of
+// containers not in ids. The result is ordered by container name.
+func (sm *statsSampler) sampleAll(ctx context.Context, ids []string) []containerSample {
+	ctx, cancel := context.WithTimeout(ctx, statsSampleTimeout)
+	defer cancel()
+
+	jobs := make(chan string)
+	results := make(chan containerSample, len(ids))
+	var wg sync.WaitGroup
+	for range min(statsWorkers, len(ids)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				sample, err := sm.sample(ctx, id)
+				if err != nil {
+					log.Debug().Err(err).Str("container", id).Msg("Cannot sample container stats")
+					continue
+				}
+				results <- sample
+			}
+		}()
+	}
+	for _, id := range ids {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	sm.mu.Lock()
+	for id := range sm.prev {
+		if !slices.Contains(ids, id) {
+			delete(sm.prev, id)
+		}
+	}
+	sm.mu.Unlock()
+
+	batch := make([]containerSample, 0, len(ids))
+	for sample := range results {
+		batch = append(batch, sample)
+	}
+	slices.SortFunc(batch, func(a, b containerSample) int {
+		if c := strings.Compare(a.stats.GetName(), b.stats.GetName()); c != 0 {
+			return c
+		}
+		return strings.Compare(a.stats.GetId(), b.stats.GetId())
+	})
+	return batch
+}
+
+// sample reads a one-shot stats sample of a container and computes its CPU percent
+// from the previous sample of the same container, if there is one.
+func (sm *statsSampler) sample(ctx context.Context, id string) (containerSample, error) {
+	reader, err := sm.layer.GetContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return containerSample{}, err
+	}
+	defer func() {
+		if cErr := reader.Body.Close(); cErr != nil {
+			log.Error().Err(cErr).Str("container", id).Msg("error closing stats reader")
+		}
+	}()
+
+	var stats container.StatsResponse
+	if err = json.NewDecoder(reader.Body).Decode(&stats); err != nil {
+		return containerSample{}, err
+	}
+
+	sm.mu.Lock()
+	pre, ok := sm.prev[id]
+	sm.prev[id] = stats.CPUStats
+	sm.mu.Unlock()
+
+	if !ok {
+		// Without a previous sample there is no usage delta to compute a percent from.
+		pre = stats.CPUStats
+	}
+	stats.PreCPUStats = pre
+	return containerSample{stats: mapStats(stats), cpuKnown: ok}, nil
+}
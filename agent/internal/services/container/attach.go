@@ -0,0 +1,242 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/whiteo/yadoma/internal/protos"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/moby/term"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AttachContainer attaches to stdin, stdout, and stderr of a running container's main
+// process over a bidirectional stream. The first client message must carry AttachStart
+// with the container ID, optional detach keys (Docker format, e.g. "ctrl-p,ctrl-q"),
+// and an initial terminal size. Subsequent client messages carry stdin bytes, terminal
+// resize events, or a stdin close. Stdin is only attached if the container keeps it open.
+// Whether the output is raw or multiplexed is decided from the container's TTY setting,
+// the same way GetContainerLogs does. When the output ends, a final frame reports either
+// that the client detached or the exit code of the main process.
+// Returns InvalidArgument for a malformed start message or detach keys, FailedPrecondition
+// if the container is not running, Internal for Docker failures, and the client's
+// cancellation status if it goes away.
+func (s *Service) AttachContainer(stream protos.ContainerService_AttachContainerServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot read attach start: %v", err)
+	}
+
+	start := first.GetStart()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry attach start")
+	}
+	if start.GetId() == "" {
+		return status.Error(codes.InvalidArgument, "container ID is required")
+	}
+	detachKeys, err := parseDetachKeys(start.GetDetachKeys())
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "detach_keys: %v", err)
+	}
+
+	ctx := stream.Context()
+	details, err := s.layer.GetContainerDetails(ctx, start.GetId())
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot inspect container: %v", err)
+	}
+	if details.State == nil || !details.State.Running {
+		return status.Error(codes.FailedPrecondition, "container is not running")
+	}
+	tty := details.Config.Tty
+
+	// Wait for the next exit before attaching, so an exit is not missed and a restart
+	// of the container does not look like a detach.
+	waitCtx, cancelWait := context.WithCancel(ctx)
+	defer cancelWait()
+	exits, waitErrs := s.layer.WaitContainer(waitCtx, start.GetId(), container.WaitConditionNextExit)
+
+	hijacked, err := s.layer.AttachContainer(ctx, start.GetId(), container.AttachOptions{
+		Stream:     true,
+		Stdin:      details.Config.OpenStdin,
+		Stdout:     true,
+		Stderr:     true,
+		DetachKeys: start.GetDetachKeys(),
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot attach to container: %v", err)
+	}
+	defer hijacked.Close()
+	// The hijacked connection ignores ctx, so close it when the client goes away.
+	stop := context.AfterFunc(ctx, hijacked.Close)
+	defer stop()
+
+	if size := mapConsoleSize(start.GetSize()); tty && size != nil {
+		if err = s.layer.ResizeContainer(ctx, start.GetId(), mapResizeOptions(start.GetSize())); err != nil {
+			log.Warn().Err(err).Str("container", start.GetId()).Msg("Cannot set initial TTY size")
+		}
+	}
+
+	log.Debug().
+		Str("container", start.GetId()).
+		Bool("tty", tty).
+		Bool("stdin", details.Config.OpenStdin).
+		Msg("Attached to container")
+
+	// The daemon only honors the detach keys with a TTY.
+	detach := &detachDetector{}
+	if tty {
+		detach.keys = detachKeys
+	}
+	go s.forwardAttachInput(ctx, stream, start.GetId(), &hijacked, detach)
+
+	err = streamOutput(hijacked.Reader, tty,
+		func(p []byte) error {
+			return stream.Send(&protos.AttachContainerResponse{
+				Payload: &protos.AttachContainerResponse_Stdout{Stdout: p},
+			})
+		},
+		func(p []byte) error {
+			return stream.Send(&protos.AttachContainerResponse{
+				Payload: &protos.AttachContainerResponse_Stderr{Stderr: p},
+			})
+		},
+	)
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	end, err := attachEnd(ctx, start.GetId(), detach.detached.Load(), exits, waitErrs)
+	if err != nil {
+		return err
+	}
+	return stream.Send(end)
+}
+
+// attachEnd returns the final frame once the attached output closed: a detach if the
+// client typed the detach keys, and otherwise the exit code of the container's next exit.
+func attachEnd(
+	ctx context.Context,
+	id string,
+	detached bool,
+	exits <-chan container.WaitResponse,
+	waitErrs <-chan error,
+) (*protos.AttachContainerResponse, error) {
+	if detached {
+		log.Debug().Str("container", id).Msg("Detached from container")
+		return &protos.AttachContainerResponse{
+			Payload: &protos.AttachContainerResponse_Detached{Detached: true},
+		}, nil
+	}
+
+	var exit container.WaitResponse
+	select {
+	case exit = <-exits:
+	case err := <-waitErrs:
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Errorf(codes.Internal, "cannot wait for container: %v", err)
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+
+	exitCode := clampToInt32(int(exit.StatusCode))
+	log.Debug().Str("container", id).Int32("exit_code", exitCode).Msg("Container exited while attached")
+	return &protos.AttachContainerResponse{
+		Payload: &protos.AttachContainerResponse_ExitCode{ExitCode: exitCode},
+	}, nil
+}
+
+// forwardAttachInput copies client messages to the container until the client
+// half-closes the stream or the stream context ends.
+func (s *Service) forwardAttachInput(
+	ctx context.Context,
+	stream protos.ContainerService_AttachContainerServer,
+	id string,
+	hijacked *types.HijackedResponse,
+	detach *detachDetector,
+) {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				_ = hijacked.CloseWrite()
+			}
+			return
+		}
+
+		switch p := req.GetPayload().(type) {
+		case *protos.AttachContainerRequest_Stdin:
+			// Record a detach before the daemon sees the keys and closes the output.
+			detach.observe(p.Stdin)
+			if _, err = hijacked.Conn.Write(p.Stdin); err != nil {
+				log.Debug().Err(err).Str("container", id).Msg("Cannot write container stdin")
+				return
+			}
+		case *protos.AttachContainerRequest_Resize:
+			if err = s.layer.ResizeContainer(ctx, id, mapResizeOptions(p.Resize)); err != nil {
+				log.Warn().Err(err).Str("container", id).Msg("Cannot resize container TTY")
+			}
+		case *protos.AttachContainerRequest_CloseStdin:
+			_ = hijacked.CloseWrite()
+		default:
+			log.Warn().Str("container", id).Msg("Ignoring unexpected attach message")
+		}
+	}
+}
+
+// defaultDetachKeys are the keys the daemon detaches on when none are given.
+const defaultDetachKeys = "ctrl-p,ctrl-q"
+
+// parseDetachKeys converts detach keys in Docker format to the bytes the daemon
+// looks for in stdin.
+func parseDetachKeys(keys string) ([]byte, error) {
+	if keys == "" {
+		keys = defaultDetachKeys
+	}
+	return term.ToBytes(keys)
+}
+
+// detachDetector follows stdin the same way the daemon does to tell whether the
+// client typed the detach keys, so a closed output can be told apart from an exit.
+// Like the daemon, it only recognizes keys that arrive one per write, so pasted
+// input that contains the keys does not count as a detach.
+type detachDetector struct {
+	keys     []byte
+	pos      int
+	detached atomic.Bool
+}
+
+func (d *detachDetector) observe(p []byte) {
+	if len(d.keys) == 0 {
+		return
+	}
+	if len(p) != 1 || p[0] != d.keys[d.pos] {
+		d.pos = 0
+		return
+	}
+	d.pos++
+	if d.pos == len(d.keys) {
+		d.detached.Store(true)
+	}
+}
@@ -1,18 +1,37 @@
 package container
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/whiteo/yadoma/internal/metrics"
 	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
 
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -46,6 +65,10 @@ func (m *MockLayer) GetContainerStats(ctx context.Context,
 	}
 	return args.Get(0).(container.StatsResponseReader), args.Error(1)
 }
+func (m *MockLayer) GetContainerStatsOneShot(ctx context.Context, id string) (container.StatsResponseReader, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(container.StatsResponseReader), args.Error(1)
+}
 func (m *MockLayer) CreateContainer(ctx context.Context, c *container.Config,
 	h *container.HostConfig, n *network.NetworkingConfig,
 	p *ocispec.Platform, name string) (container.CreateResponse, error) {
@@ -85,6 +108,89 @@ func (m *MockLayer) RenameContainer(ctx context.Context, id, name string) error
 	return args.Error(0)
 }
 
+func (m *MockLayer) UpdateContainer(ctx context.Context, id string,
+	config container.UpdateConfig,
+) (container.UpdateResponse, error) {
+	args := m.Called(ctx, id, config)
+	return args.Get(0).(container.UpdateResponse), args.Error(1)
+}
+
+func (m *MockLayer) WaitContainer(ctx context.Context, id string,
+	condition container.WaitCondition,
+) (<-chan container.WaitResponse, <-chan error) {
+	args := m.Called(ctx, id, condition)
+	return args.Get(0).(<-chan container.WaitResponse), args.Get(1).(<-chan error)
+}
+
+func (m *MockLayer) CreateExec(ctx context.Context, id string,
+	opts container.ExecOptions) (container.ExecCreateResponse, error) {
+	args := m.Called(ctx, id, opts)
+	return args.Get(0).(container.ExecCreateResponse), args.Error(1)
+}
+func (m *MockLayer) AttachExec(ctx context.Context, execID string,
+	opts container.ExecAttachOptions) (types.HijackedResponse, error) {
+	args := m.Called(ctx, execID, opts)
+	return args.Get(0).(types.HijackedResponse), args.Error(1)
+}
+func (m *MockLayer) ResizeExec(ctx context.Context, execID string, opts container.ResizeOptions) error {
+	args := m.Called(ctx, execID, opts)
+	return args.Error(0)
+}
+func (m *MockLayer) InspectExec(ctx context.Context, execID string) (container.ExecInspect, error) {
+	args := m.Called(ctx, execID)
+	return args.Get(0).(container.ExecInspect), args.Error(1)
+}
+
+func (m *MockLayer) AttachContainer(ctx context.Context, id string,
+	opts container.AttachOptions) (types.HijackedResponse, error) {
+	args := m.Called(ctx, id, opts)
+	return args.Get(0).(types.HijackedResponse), args.Error(1)
+}
+func (m *MockLayer) ResizeContainer(ctx context.Context, id string, opts container.ResizeOptions) error {
+	args := m.Called(ctx, id, opts)
+	return args.Error(0)
+}
+
+func (m *MockLayer) GetEvents(ctx context.Context,
+	opts events.ListOptions) (<-chan events.Message, <-chan error) {
+	args := m.Called(ctx, opts)
+	return args.Get(0).(<-chan events.Message), args.Get(1).(<-chan error)
+}
+
+func (m *MockLayer) GetVolumeDetails(ctx context.Context, id string) (volume.Volume, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(volume.Volume), args.Error(1)
+}
+
+func (m *MockLayer) CreateVolume(ctx context.Context, opts volume.CreateOptions) (volume.Volume, error) {
+	args := m.Called(ctx, opts)
+	return args.Get(0).(volume.Volume), args.Error(1)
+}
+
+func (m *MockLayer) RemoveVolume(ctx context.Context, id string, force bool) error {
+	args := m.Called(ctx, id, force)
+	return args.Error(0)
+}
+
+func (m *MockLayer) GetImageDetails(ctx context.Context, id string) (image.InspectResponse, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(image.InspectResponse), args.Error(1)
+}
+
+func (m *MockLayer) PullImage(ctx context.Context, link string, opts image.PullOptions) (io.ReadCloser, error) {
+	args := m.Called(ctx, link, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+func (m *MockLayer) ConnectNetwork(ctx context.Context, networkID, containerID string,
+	config *network.EndpointSettings,
+) error {
+	return m.Called(ctx, networkID, containerID, config).Error(0)
+}
+
 func grpcCode(err error) codes.Code {
 	if err == nil {
 		return codes.OK
@@ -98,7 +204,7 @@ func grpcCode(err error) codes.Code {
 
 func TestContainerServiceRegister(t *testing.T) {
 	s := grpc.NewServer()
-	NewContainerService(nil).Register(s)
+	NewContainerService(nil, nil).Register(s)
 	if _, ok := s.GetServiceInfo()["container.v1.ContainerService"]; !ok {
 		keys := make([]string, 0, len(s.GetServiceInfo()))
 		for k := range s.GetServiceInfo() {
@@ -172,6 +278,7 @@ func TestServiceGetContainerDetails(t *testing.T) {
 		expectErr bool
 		code      codes.Code
 		expectID  string
+		check     func(*testing.T, *protos.GetContainerDetailsResponse)
 	}{
 		{
 			name:      "missing id",
@@ -193,6 +300,67 @@ func TestServiceGetContainerDetails(t *testing.T) {
 			code:      codes.OK,
 			expectID:  "c1",
 		},
+		{
+			name: "state, config and host config",
+			req:  &protos.GetContainerDetailsRequest{Id: "db"},
+			setup: func(ml *MockLayer) {
+				pids := int64(100)
+				inspect := container.InspectResponse{
+					ContainerJSONBase: &container.ContainerJSONBase{
+						ID:           "db",
+						Name:         "/db",
+						RestartCount: 3,
+						State: &container.State{
+							Status:     "exited",
+							OOMKilled:  true,
+							ExitCode:   137,
+							StartedAt:  "2025-01-01T00:00:00Z",
+							FinishedAt: "2025-01-01T01:00:00Z",
+							Health: &container.Health{
+								Status:        container.Unhealthy,
+								FailingStreak: 2,
+								Log:           []*container.HealthcheckResult{{ExitCode: 1, Output: "refused"}},
+							},
+						},
+						HostConfig: &container.HostConfig{
+							RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 5},
+							Resources:     container.Resources{Memory: 512 << 20, NanoCPUs: 1_000_000_000, PidsLimit: &pids},
+							PortBindings:  nat.PortMap{"5432/tcp": {{HostIP: "127.0.0.1", HostPort: "5432"}}},
+						},
+					},
+					Config: &container.Config{
+						Env:        []string{"POSTGRES_USER=app", "POSTGRES_PASSWORD=hunter2"},
+						Cmd:        []string{"postgres"},
+						Entrypoint: []string{"docker-entrypoint.sh"},
+						Labels:     map[string]string{"tier": "db"},
+					},
+				}
+				ml.On("GetContainerDetails", mock.Anything, "db").Return(inspect, nil)
+			},
+			code:     codes.OK,
+			expectID: "db",
+			check: func(t *testing.T, resp *protos.GetContainerDetailsResponse) {
+				state := resp.GetState()
+				assert.Equal(t, "exited", state.GetStatus())
+				assert.True(t, state.GetOomKilled())
+				assert.Equal(t, int32(137), state.GetExitCode())
+				assert.Equal(t, "2025-01-01T01:00:00Z", state.GetFinishedAt())
+				assert.Equal(t, container.Unhealthy, state.GetHealth())
+				assert.Equal(t, int32(2), state.GetFailingStreak())
+				assert.Equal(t, "refused", state.GetLastHealthCheck().GetOutput())
+				assert.Equal(t, int32(3), resp.GetRestartCount())
+				assert.Equal(t, []string{"POSTGRES_USER=app", "POSTGRES_PASSWORD=********"}, resp.GetEnv())
+				assert.Equal(t, []string{"postgres"}, resp.GetCmd())
+				assert.Equal(t, []string{"docker-entrypoint.sh"}, resp.GetEntrypoint())
+				assert.Equal(t, map[string]string{"tier": "db"}, resp.GetLabels())
+				assert.Equal(t, &protos.RestartPolicy{Name: "on-failure", MaximumRetryCount: 5}, resp.GetRestartPolicy())
+				assert.Equal(t, int64(512<<20), resp.GetResources().GetMemory())
+				assert.Equal(t, int64(100), resp.GetResources().GetPidsLimit())
+				assert.Equal(t, []*protos.PortMapping{
+					{HostIp: "127.0.0.1", HostPort: 5432, ContainerPort: 5432, Protocol: "tcp"},
+				}, resp.GetPortBindings())
+			},
+		},
 		{
 			name: "layer error",
 			req:  &protos.GetContainerDetailsRequest{Id: "c2"},
@@ -219,11 +387,27 @@ func TestServiceGetContainerDetails(t *testing.T) {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectID, resp.GetId())
 			}
+			if tt.check != nil {
+				tt.check(t, resp)
+			}
 			ml.AssertExpectations(t)
 		})
 	}
 }
 
+func dataVolume(create bool) *protos.Mount {
+	return &protos.Mount{
+		Type:   protos.MountType_MOUNT_TYPE_VOLUME,
+		Source: "pgdata",
+		Target: "/var/lib/postgresql/data",
+		VolumeOptions: &protos.MountVolumeOptions{
+			Labels:       map[string]string{"app": "db"},
+			DriverConfig: &protos.MountDriverConfig{Name: "local", Options: map[string]string{"type": "none"}},
+		},
+		CreateVolume: create,
+	}
+}
+
 func TestServiceCreateContainer(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -253,6 +437,157 @@ func TestServiceCreateContainer(t *testing.T) {
 			code:      codes.OK,
 			expectID:  "container-123",
 		},
+		{
+			name: "published ports are exposed and reported",
+			req: &protos.CreateContainerRequest{
+				Image: "coredns/coredns",
+				Name:  "dns",
+				HostConfig: &protos.HostConfig{PortBindings: map[string]*protos.PortBinding{
+					"dns": {HostPorts: []*protos.PortMapping{{HostPort: 53, ContainerPort: 53, Protocol: "udp"}}},
+				}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("CreateContainer",
+					mock.Anything,
+					mock.MatchedBy(func(c *container.Config) bool {
+						_, ok := c.ExposedPorts["53/udp"]
+						return ok
+					}),
+					mock.Anything,
+					mock.Anything,
+					mock.Anything,
+					"dns",
+				).Return(container.CreateResponse{ID: "container-dns"}, nil)
+			},
+			expectErr: false,
+			code:      codes.OK,
+			expectID:  "container-dns",
+		},
+		{
+			name: "existing volume is mounted",
+			req: &protos.CreateContainerRequest{
+				Image:      "postgres:17",
+				Name:       "db",
+				HostConfig: &protos.HostConfig{Mounts: []*protos.Mount{dataVolume(false)}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("GetVolumeDetails", mock.Anything, "pgdata").Return(volume.Volume{Name: "pgdata"}, nil)
+				ml.On("CreateContainer",
+					mock.Anything,
+					mock.Anything,
+					mock.MatchedBy(func(h *container.HostConfig) bool {
+						return len(h.Mounts) == 1 && h.Mounts[0].Type == mount.TypeVolume && h.Mounts[0].Source == "pgdata"
+					}),
+					mock.Anything,
+					mock.Anything,
+					"db",
+				).Return(container.CreateResponse{ID: "container-db"}, nil)
+			},
+			expectErr: false,
+			code:      codes.OK,
+			expectID:  "container-db",
+		},
+		{
+			name: "missing volume error",
+			req: &protos.CreateContainerRequest{
+				Image:      "postgres:17",
+				Name:       "db",
+				HostConfig: &protos.HostConfig{Mounts: []*protos.Mount{dataVolume(false)}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("GetVolumeDetails", mock.Anything, "pgdata").
+					Return(volume.Volume{}, fmt.Errorf("cannot inspect volume pgdata: %w", cerrdefs.ErrNotFound))
+			},
+			expectErr: true,
+			code:      codes.NotFound,
+		},
+		{
+			name: "missing volume is created",
+			req: &protos.CreateContainerRequest{
+				Image:      "postgres:17",
+				Name:       "db",
+				HostConfig: &protos.HostConfig{Mounts: []*protos.Mount{dataVolume(true)}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("GetVolumeDetails", mock.Anything, "pgdata").
+					Return(volume.Volume{}, fmt.Errorf("cannot inspect volume pgdata: %w", cerrdefs.ErrNotFound))
+				ml.On("CreateVolume", mock.Anything, volume.CreateOptions{
+					Name:       "pgdata",
+					Driver:     "local",
+					DriverOpts: map[string]string{"type": "none"},
+					Labels:     map[string]string{"app": "db"},
+				}).Return(volume.Volume{Name: "pgdata"}, nil)
+				ml.On("CreateContainer",
+					mock.Anything,
+					mock.Anything,
+					mock.Anything,
+					mock.Anything,
+					mock.Anything,
+					"db",
+				).Return(container.CreateResponse{ID: "container-db"}, nil)
+			},
+			expectErr: false,
+			code:      codes.OK,
+			expectID:  "container-db",
+		},
+		{
+			name: "created volume is removed when the create fails",
+			req: &protos.CreateContainerRequest{
+				Image:      "postgres:17",
+				Name:       "db",
+				HostConfig: &protos.HostConfig{Mounts: []*protos.Mount{dataVolume(true)}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("GetVolumeDetails", mock.Anything, "pgdata").
+					Return(volume.Volume{}, fmt.Errorf("cannot inspect volume pgdata: %w", cerrdefs.ErrNotFound))
+				ml.On("CreateVolume", mock.Anything, mock.Anything).Return(volume.Volume{Name: "pgdata"}, nil)
+				ml.On("CreateContainer",
+					mock.Anything,
+					mock.Anything,
+					mock.Anything,
+					mock.Anything,
+					mock.Anything,
+					"db",
+				).Return(container.CreateResponse{}, errors.New("name already in use"))
+				ml.On("RemoveVolume", mock.Anything, "pgdata", false).Return(nil)
+			},
+			expectErr: true,
+			code:      codes.Internal,
+		},
+		{
+			name: "created volume is removed when a later mount fails",
+			req: &protos.CreateContainerRequest{
+				Image: "postgres:17",
+				Name:  "db",
+				HostConfig: &protos.HostConfig{Mounts: []*protos.Mount{
+					dataVolume(true),
+					{Type: protos.MountType_MOUNT_TYPE_VOLUME, Source: "pglogs", Target: "/var/log/postgresql"},
+				}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("GetVolumeDetails", mock.Anything, "pgdata").
+					Return(volume.Volume{}, fmt.Errorf("cannot inspect volume pgdata: %w", cerrdefs.ErrNotFound))
+				ml.On("CreateVolume", mock.Anything, mock.Anything).Return(volume.Volume{Name: "pgdata"}, nil)
+				ml.On("GetVolumeDetails", mock.Anything, "pglogs").
+					Return(volume.Volume{}, fmt.Errorf("cannot inspect volume pglogs: %w", cerrdefs.ErrNotFound))
+				ml.On("RemoveVolume", mock.Anything, "pgdata", false).Return(nil)
+			},
+			expectErr: true,
+			code:      codes.NotFound,
+		},
+		{
+			name: "volume inspect error",
+			req: &protos.CreateContainerRequest{
+				Image:      "postgres:17",
+				Name:       "db",
+				HostConfig: &protos.HostConfig{Mounts: []*protos.Mount{dataVolume(true)}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("GetVolumeDetails", mock.Anything, "pgdata").Return(volume.Volume{}, errors.New("docker error"))
+			},
+			expectErr: true,
+			code:      codes.Internal,
+		},
 		{
 			name: "missing image error",
 			req: &protos.CreateContainerRequest{
@@ -262,6 +597,16 @@ func TestServiceCreateContainer(t *testing.T) {
 			expectErr: true,
 			code:      codes.InvalidArgument,
 		},
+		{
+			name: "invalid field error",
+			req: &protos.CreateContainerRequest{
+				Image:      "nginx:latest",
+				Name:       "test-container",
+				WorkingDir: "relative/dir",
+			},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
 		{
 			name: "layer error",
 			req: &protos.CreateContainerRequest{
@@ -451,289 +796,2438 @@ func TestServiceStateChangingOps(t *testing.T) {
 	}
 }
 
-func TestServiceGetContainerLogs(t *testing.T) {
+func TestServiceUpdateContainer(t *testing.T) {
+	pids := int64(200)
 	tests := []struct {
-		name      string
-		req       *protos.GetContainerLogsRequest
-		setup     func(*MockLayer, *mockContainerLogsStream)
-		expectErr bool
-		code      codes.Code
+		name     string
+		req      *protos.UpdateContainerRequest
+		setup    func(*MockLayer)
+		code     codes.Code
+		errField string
+		warnings []string
 	}{
 		{
-			name:      "missing id",
-			req:       &protos.GetContainerLogsRequest{Id: ""},
-			expectErr: true,
-			code:      codes.InvalidArgument,
-		},
-		{
-			name: "successful logs retrieval - non-TTY container",
-			req:  &protos.GetContainerLogsRequest{Id: "c1", Follow: true},
-			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
-				inspect := container.InspectResponse{
-					ContainerJSONBase: &container.ContainerJSONBase{
-						ID: "c1",
-					},
-					Config: &container.Config{
-						Tty: false,
+			name: "throttle a running container",
+			req: &protos.UpdateContainerRequest{
+				Id:            "c1",
+				NanoCpus:      500_000_000,
+				Memory:        256 << 20,
+				MemorySwap:    512 << 20,
+				PidsLimit:     &pids,
+				BlkioWeight:   100,
+				RestartPolicy: &protos.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("UpdateContainer", mock.Anything, "c1", container.UpdateConfig{
+					Resources: container.Resources{
+						NanoCPUs:    500_000_000,
+						Memory:      256 << 20,
+						MemorySwap:  512 << 20,
+						PidsLimit:   &pids,
+						BlkioWeight: 100,
 					},
-				}
-				ml.On("GetContainerDetails", mock.Anything, "c1").Return(inspect, nil)
-
-				logLine := "test log line\n"
-				header := []byte{
-					1, 0, 0, 0,
-					0, 0, 0, byte(len(logLine)),
-				}
-				multiplexedData := append(header, []byte(logLine)...)
-				mockReader := &mockReadCloser{data: multiplexedData}
-
-				ml.On("GetContainerLogs",
-					mock.Anything,
-					"c1",
-					mock.MatchedBy(func(opts container.LogsOptions) bool {
-						return opts.Follow == true && opts.ShowStdout == true && opts.ShowStderr == true
-					}),
-				).Return(mockReader, nil)
-
-				stream.On("Send", mock.Anything).Return(nil)
+					RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyOnFailure, MaximumRetryCount: 3},
+				}).Return(container.UpdateResponse{Warnings: []string{"swap limit not supported"}}, nil)
 			},
-			expectErr: false,
-			code:      codes.OK,
+			code:     codes.OK,
+			warnings: []string{"swap limit not supported"},
 		},
 		{
-			name: "successful logs retrieval - TTY container",
-			req:  &protos.GetContainerLogsRequest{Id: "c1-tty", Follow: false},
-			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
-				inspect := container.InspectResponse{
-					ContainerJSONBase: &container.ContainerJSONBase{
-						ID: "c1-tty",
-					},
-					Config: &container.Config{
-						Tty: true,
-					},
-				}
-				ml.On("GetContainerDetails", mock.Anything, "c1-tty").Return(inspect, nil)
-
-				mockReader := &mockReadCloser{data: []byte("raw log output\n")}
-				ml.On("GetContainerLogs",
-					mock.Anything,
-					"c1-tty",
-					mock.MatchedBy(func(opts container.LogsOptions) bool {
-						return opts.Follow == false && opts.ShowStdout == true && opts.ShowStderr == true
-					}),
-				).Return(mockReader, nil)
-
-				stream.On("Send", mock.Anything).Return(nil)
+			name: "restart policy only",
+			req: &protos.UpdateContainerRequest{
+				Id:            "c1",
+				RestartPolicy: &protos.RestartPolicy{Name: "unless-stopped"},
 			},
-			expectErr: false,
-			code:      codes.OK,
+			setup: func(ml *MockLayer) {
+				ml.On("UpdateContainer", mock.Anything, "c1", container.UpdateConfig{
+					RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+				}).Return(container.UpdateResponse{}, nil)
+			},
+			code: codes.OK,
 		},
 		{
-			name: "layer error - inspect fails",
-			req:  &protos.GetContainerLogsRequest{Id: "c2", Follow: false},
-			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
-				ml.On("GetContainerDetails", mock.Anything, "c2").Return(
-					container.InspectResponse{}, errors.New("container not found"))
+			name: "missing ID",
+			req:  &protos.UpdateContainerRequest{Memory: 256 << 20},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "nothing to update",
+			req:  &protos.UpdateContainerRequest{Id: "c1"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:     "nano cpus with quota",
+			req:      &protos.UpdateContainerRequest{Id: "c1", NanoCpus: 1_000_000_000, CpuQuota: 50000},
+			code:     codes.InvalidArgument,
+			errField: "nano_cpus",
+		},
+		{
+			name:     "memory below minimum",
+			req:      &protos.UpdateContainerRequest{Id: "c1", Memory: 1024},
+			code:     codes.InvalidArgument,
+			errField: "memory",
+		},
+		{
+			name:     "blkio weight out of range",
+			req:      &protos.UpdateContainerRequest{Id: "c1", BlkioWeight: 5},
+			code:     codes.InvalidArgument,
+			errField: "blkio_weight",
+		},
+		{
+			name: "retry count without on-failure",
+			req: &protos.UpdateContainerRequest{
+				Id:            "c1",
+				RestartPolicy: &protos.RestartPolicy{Name: "always", MaximumRetryCount: 5},
 			},
-			expectErr: true,
-			code:      codes.Internal,
+			code:     codes.InvalidArgument,
+			errField: "restart_policy",
 		},
 		{
-			name: "layer error - logs retrieval fails",
-			req:  &protos.GetContainerLogsRequest{Id: "c3", Follow: false},
-			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
-				inspect := container.InspectResponse{
-					ContainerJSONBase: &container.ContainerJSONBase{ID: "c3"},
-					Config:            &container.Config{Tty: false},
-				}
-				ml.On("GetContainerDetails", mock.Anything, "c3").Return(inspect, nil)
-				ml.On("GetContainerLogs",
-					mock.Anything,
-					"c3",
-					mock.Anything,
-				).Return(nil, errors.New("cannot get logs"))
+			name: "layer error",
+			req:  &protos.UpdateContainerRequest{Id: "c1", CpuShares: 512},
+			setup: func(ml *MockLayer) {
+				ml.On("UpdateContainer", mock.Anything, "c1", mock.Anything).
+					Return(container.UpdateResponse{}, errors.New("no such container"))
 			},
-			expectErr: true,
-			code:      codes.Internal,
+			code: codes.Internal,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ml := &MockLayer{}
-			mockStream := &mockContainerLogsStream{}
-
 			if tt.setup != nil {
-				tt.setup(ml, mockStream)
+				tt.setup(ml)
 			}
-
 			svc := &Service{layer: ml}
-			err := svc.GetContainerLogs(tt.req, mockStream)
 
-			if tt.expectErr {
-				assert.Error(t, err)
-				assert.Equal(t, tt.code, grpcCode(err))
-			} else {
-				assert.NoError(t, err)
+			resp, err := svc.UpdateContainer(context.Background(), tt.req)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			if tt.code == codes.OK {
+				assert.Equal(t, tt.warnings, resp.GetWarnings())
+			}
+			if tt.errField != "" {
+				msg := status.Convert(err).Message()
+				assert.True(t, strings.HasPrefix(msg, tt.errField+":"), msg)
 			}
 			ml.AssertExpectations(t)
-			mockStream.AssertExpectations(t)
 		})
 	}
 }
 
-func TestServiceGetContainerStats(t *testing.T) {
+func TestServiceRecreateContainer(t *testing.T) {
+	inspectOld := func(running, autoRemove bool) container.InspectResponse {
+		return container.InspectResponse{
+			ContainerJSONBase: &container.ContainerJSONBase{
+				ID:         oldContainerID,
+				Name:       "/web",
+				Image:      "sha256:old",
+				State:      &container.State{Running: running},
+				HostConfig: &container.HostConfig{AutoRemove: autoRemove},
+			},
+			Config: &container.Config{Image: "nginx:1.27"},
+		}
+	}
+	inspectNew := func(state *container.State) container.InspectResponse {
+		return container.InspectResponse{
+			ContainerJSONBase: &container.ContainerJSONBase{ID: "new", State: state},
+			NetworkSettings: &container.NetworkSettings{NetworkSettingsBase: container.NetworkSettingsBase{
+				Ports: nat.PortMap{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}}},
+			}},
+		}
+	}
+	replace := func(ml *MockLayer) {
+		ml.On("GetContainerDetails", mock.Anything, "web").Return(inspectOld(true, false), nil)
+		ml.On("GetImageDetails", mock.Anything, "sha256:old").Return(image.InspectResponse{}, nil)
+		ml.On("StopContainer", mock.Anything, oldContainerID, container.StopOptions{}).Return(nil)
+		ml.On("RenameContainer", mock.Anything, oldContainerID, "web-0123456789ab-old").Return(nil)
+		ml.On("CreateContainer", mock.Anything,
+			mock.MatchedBy(func(c *container.Config) bool { return c.Image == "nginx:1.28" }),
+			mock.Anything, mock.Anything, mock.Anything, "web",
+		).Return(container.CreateResponse{ID: "new"}, nil)
+	}
+	rollback := func(ml *MockLayer) {
+		ml.On("RemoveContainer", mock.Anything, "new", container.RemoveOptions{Force: true}).Return(nil)
+		ml.On("RenameContainer", mock.Anything, oldContainerID, "web").Return(nil)
+		ml.On("StartContainer", mock.Anything, oldContainerID, container.StartOptions{}).Return(nil)
+	}
+
+	tests := []struct {
+		name     string
+		req      *protos.RecreateContainerRequest
+		setup    func(*MockLayer)
+		code     codes.Code
+		warnings []string
+	}{
+		{
+			name: "new container replaces the old one",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28"},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "new").Return(inspectNew(&container.State{Running: true}), nil)
+				ml.On("RemoveContainer", mock.Anything, oldContainerID, container.RemoveOptions{}).Return(nil)
+			},
+			code: codes.OK,
+		},
+		{
+			name: "old container is removed once the new one is healthy",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28", WaitHealthy: true},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "new").Return(inspectNew(&container.State{
+					Running: true, Health: &container.Health{Status: container.Starting},
+				}), nil).Once()
+				ml.On("GetContainerDetails", mock.Anything, "new").Return(inspectNew(&container.State{
+					Running: true, Health: &container.Health{Status: container.Healthy},
+				}), nil).Once()
+				ml.On("RemoveContainer", mock.Anything, oldContainerID, container.RemoveOptions{}).Return(nil)
+			},
+			code: codes.OK,
+		},
+		{
+			name: "old container removal failure is a warning",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28"},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "new").Return(inspectNew(&container.State{Running: true}), nil)
+				ml.On("RemoveContainer", mock.Anything, oldContainerID, container.RemoveOptions{}).
+					Return(errors.New("device busy"))
+			},
+			code:     codes.OK,
+			warnings: []string{"old container " + oldContainerID + " was not removed: device busy"},
+		},
+		{
+			name: "start failure restores the old container",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28"},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).
+					Return(errors.New("port is already allocated"))
+				rollback(ml)
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "exited new container restores the old one",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28"},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "new").
+					Return(inspectNew(&container.State{ExitCode: 1}), nil)
+				rollback(ml)
+			},
+			code: codes.Unavailable,
+		},
+		{
+			name: "unhealthy new container restores the old one",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28", WaitHealthy: true},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "new").Return(inspectNew(&container.State{
+					Running: true, Health: &container.Health{Status: container.Unhealthy},
+				}), nil)
+				rollback(ml)
+			},
+			code: codes.Unavailable,
+		},
+		{
+			name: "inspect cut off by the health timeout restores the old one",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28", HealthTimeoutSeconds: 1},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "new").
+					Run(func(args mock.Arguments) { <-args.Get(0).(context.Context).Done() }).
+					Return(container.InspectResponse{}, context.DeadlineExceeded)
+				rollback(ml)
+			},
+			code: codes.Unavailable,
+		},
+		{
+			name: "old container restarts under its temporary name if the new one cannot be removed",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28"},
+			setup: func(ml *MockLayer) {
+				replace(ml)
+				ml.On("StartContainer", mock.Anything, "new", container.StartOptions{}).
+					Return(errors.New("port is already allocated"))
+				ml.On("RemoveContainer", mock.Anything, "new", container.RemoveOptions{Force: true}).
+					Return(errors.New("device busy"))
+				ml.On("StartContainer", mock.Anything, oldContainerID, container.StartOptions{}).Return(nil)
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "stopped container is not restarted on rollback",
+			req:  &protos.RecreateContainerRequest{Id: "web"},
+			setup: func(ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "web").Return(inspectOld(false, false), nil)
+				ml.On("StopContainer", mock.Anything, oldContainerID, container.StopOptions{}).Return(nil)
+				ml.On("RenameContainer", mock.Anything, oldContainerID, "web-0123456789ab-old").Return(nil)
+				ml.On("CreateContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+					"web").Return(container.CreateResponse{}, errors.New("no such image"))
+				ml.On("RenameContainer", mock.Anything, oldContainerID, "web").Return(nil)
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "old image inspect error",
+			req:  &protos.RecreateContainerRequest{Id: "web", Image: "nginx:1.28"},
+			setup: func(ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "web").Return(inspectOld(true, false), nil)
+				ml.On("GetImageDetails", mock.Anything, "sha256:old").Return(image.InspectResponse{}, errors.New("daemon down"))
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "unknown container",
+			req:  &protos.RecreateContainerRequest{Id: "web"},
+			setup: func(ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "web").
+					Return(container.InspectResponse{}, fmt.Errorf("cannot inspect: %w", cerrdefs.ErrNotFound))
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "auto-remove container",
+			req:  &protos.RecreateContainerRequest{Id: "web"},
+			setup: func(ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "web").Return(inspectOld(true, true), nil)
+			},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "invalid env",
+			req:  &protos.RecreateContainerRequest{Id: "web", Env: []string{"=value"}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing ID",
+			req:  &protos.RecreateContainerRequest{Image: "nginx:1.28"},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			if tt.setup != nil {
+				tt.setup(ml)
+			}
+			svc := &Service{layer: ml}
+
+			resp, err := svc.RecreateContainer(context.Background(), tt.req)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			if tt.code == codes.OK {
+				assert.Equal(t, "new", resp.GetId())
+				assert.Equal(t, "web", resp.GetName())
+				assert.Equal(t, []*protos.PortMapping{
+					{HostIp: "0.0.0.0", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+				}, resp.GetPorts())
+				assert.Equal(t, tt.warnings, resp.GetWarnings())
+			}
+			ml.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServiceWaitContainer(t *testing.T) {
+	exited := func(resp container.WaitResponse) (<-chan container.WaitResponse, <-chan error) {
+		waits := make(chan container.WaitResponse, 1)
+		waits <- resp
+		return waits, make(chan error)
+	}
+	failed := func(err error) (<-chan container.WaitResponse, <-chan error) {
+		errs := make(chan error, 1)
+		errs <- err
+		return make(chan container.WaitResponse), errs
+	}
+	withState := func(state *container.State) container.InspectResponse {
+		return container.InspectResponse{ContainerJSONBase: &container.ContainerJSONBase{ID: "c1", State: state}}
+	}
+	check := &container.HealthcheckResult{
+		Start:    time.Unix(1700000000, 0),
+		End:      time.Unix(1700000001, 0),
+		ExitCode: 1,
+		Output:   "connection refused",
+	}
+	healthEvents := func(msgs ...events.Message) (<-chan events.Message, <-chan error) {
+		ch := make(chan events.Message, len(msgs))
+		for _, m := range msgs {
+			ch <- m
+		}
+		return ch, make(chan error)
+	}
+
+	tests := []struct {
+		name     string
+		req      *protos.WaitContainerRequest
+		timeout  time.Duration
+		setup    func(*MockLayer)
+		code     codes.Code
+		expected *protos.WaitContainerResponse
+	}{
+		{
+			name: "not running reports exit code and OOM kill",
+			req:  &protos.WaitContainerRequest{Id: "c1"},
+			setup: func(ml *MockLayer) {
+				waits, errs := exited(container.WaitResponse{StatusCode: 137})
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNotRunning).Return(waits, errs)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(withState(&container.State{
+					OOMKilled: true,
+					ExitCode:  137,
+					Health:    &container.Health{Status: container.Unhealthy, Log: []*container.HealthcheckResult{check}},
+				}), nil)
+			},
+			code: codes.OK,
+			expected: &protos.WaitContainerResponse{
+				ExitCode:  137,
+				OomKilled: true,
+				Health:    container.Unhealthy,
+				LastHealthCheck: &protos.HealthcheckResult{
+					StartNano: check.Start.UnixNano(),
+					EndNano:   check.End.UnixNano(),
+					ExitCode:  1,
+					Output:    "connection refused",
+				},
+			},
+		},
+		{
+			name: "removed container is not inspected",
+			req:  &protos.WaitContainerRequest{Id: "c1", Condition: protos.WaitCondition_WAIT_CONDITION_REMOVED},
+			setup: func(ml *MockLayer) {
+				waits, errs := exited(container.WaitResponse{
+					StatusCode: 1,
+					Error:      &container.WaitExitError{Message: "exec format error"},
+				})
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionRemoved).Return(waits, errs)
+			},
+			code:     codes.OK,
+			expected: &protos.WaitContainerResponse{ExitCode: 1, Error: "exec format error"},
+		},
+		{
+			name: "unknown container",
+			req:  &protos.WaitContainerRequest{Id: "c1", Condition: protos.WaitCondition_WAIT_CONDITION_NEXT_EXIT},
+			setup: func(ml *MockLayer) {
+				waits, errs := failed(fmt.Errorf("no such container: %w", cerrdefs.ErrNotFound))
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(waits, errs)
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "healthy after a health event",
+			req:  &protos.WaitContainerRequest{Id: "c1", Condition: protos.WaitCondition_WAIT_CONDITION_HEALTHY},
+			setup: func(ml *MockLayer) {
+				msgs, errs := healthEvents(events.Message{Action: "health_status: healthy"})
+				ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+					return o.Filters.ExactMatch("container", "c1") && o.Filters.ExactMatch("event", "health_status")
+				})).Return(msgs, errs)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(withState(&container.State{
+					Running: true, Health: &container.Health{Status: container.Starting},
+				}), nil).Once()
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(withState(&container.State{
+					Running: true, Health: &container.Health{Status: container.Healthy},
+				}), nil).Once()
+			},
+			code:     codes.OK,
+			expected: &protos.WaitContainerResponse{Health: container.Healthy},
+		},
+		{
+			name:    "health wait times out",
+			req:     &protos.WaitContainerRequest{Id: "c1", Condition: protos.WaitCondition_WAIT_CONDITION_HEALTHY},
+			timeout: 20 * time.Millisecond,
+			setup: func(ml *MockLayer) {
+				msgs, errs := healthEvents()
+				ml.On("GetEvents", mock.Anything, mock.Anything).Return(msgs, errs)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(withState(&container.State{
+					Running: true, Health: &container.Health{Status: container.Starting},
+				}), nil)
+			},
+			code: codes.DeadlineExceeded,
+		},
+		{
+			name: "health wait without healthcheck",
+			req:  &protos.WaitContainerRequest{Id: "c1", Condition: protos.WaitCondition_WAIT_CONDITION_HEALTHY},
+			setup: func(ml *MockLayer) {
+				msgs, errs := healthEvents()
+				ml.On("GetEvents", mock.Anything, mock.Anything).Return(msgs, errs)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(withState(&container.State{Running: true}), nil)
+			},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "container exits before becoming healthy",
+			req:  &protos.WaitContainerRequest{Id: "c1", Condition: protos.WaitCondition_WAIT_CONDITION_HEALTHY},
+			setup: func(ml *MockLayer) {
+				msgs, errs := healthEvents()
+				ml.On("GetEvents", mock.Anything, mock.Anything).Return(msgs, errs)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(withState(&container.State{
+					ExitCode: 1, Health: &container.Health{Status: container.Unhealthy},
+				}), nil)
+			},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "unknown condition",
+			req:  &protos.WaitContainerRequest{Id: "c1", Condition: protos.WaitCondition(42)},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "negative timeout",
+			req:  &protos.WaitContainerRequest{Id: "c1", TimeoutSeconds: -1},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing ID",
+			req:  &protos.WaitContainerRequest{},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			if tt.setup != nil {
+				tt.setup(ml)
+			}
+			svc := &Service{layer: ml}
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			resp, err := svc.WaitContainer(ctx, tt.req)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			assert.Equal(t, tt.expected, resp)
+			ml.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServiceGetContainerLogs(t *testing.T) {
+	tail, negativeTail := int64(10), int64(-1)
+
 	tests := []struct {
 		name      string
-		req       *protos.GetContainerStatsRequest
-		setup     func(*MockLayer, *mockContainerStatsStream)
+		req       *protos.GetContainerLogsRequest
+		setup     func(*MockLayer, *mockContainerLogsStream)
 		expectErr bool
 		code      codes.Code
 	}{
 		{
-			name:      "missing id",
-			req:       &protos.GetContainerStatsRequest{Id: ""},
-			expectErr: true,
-			code:      codes.InvalidArgument,
+			name:      "missing id",
+			req:       &protos.GetContainerLogsRequest{Id: ""},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name: "successful logs retrieval - non-TTY container",
+			req:  &protos.GetContainerLogsRequest{Id: "c1", Follow: true},
+			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
+				inspect := container.InspectResponse{
+					ContainerJSONBase: &container.ContainerJSONBase{
+						ID: "c1",
+					},
+					Config: &container.Config{
+						Tty: false,
+					},
+				}
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(inspect, nil)
+
+				logLine := "test log line\n"
+				header := []byte{
+					1, 0, 0, 0,
+					0, 0, 0, byte(len(logLine)),
+				}
+				multiplexedData := append(header, []byte(logLine)...)
+				mockReader := &mockReadCloser{data: multiplexedData}
+
+				ml.On("GetContainerLogs",
+					mock.Anything,
+					"c1",
+					mock.MatchedBy(func(opts container.LogsOptions) bool {
+						return opts.Follow == true && opts.ShowStdout == true && opts.ShowStderr == true
+					}),
+				).Return(mockReader, nil)
+
+				stream.On("Send", &protos.GetContainerLogsResponse{
+					Line:   []byte("test log line"),
+					Stream: protos.LogStream_LOG_STREAM_STDOUT,
+					Seq:    1,
+				}).Return(nil).Once()
+			},
+			expectErr: false,
+			code:      codes.OK,
+		},
+		{
+			name: "successful logs retrieval - TTY container",
+			req:  &protos.GetContainerLogsRequest{Id: "c1-tty", Follow: false},
+			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
+				inspect := container.InspectResponse{
+					ContainerJSONBase: &container.ContainerJSONBase{
+						ID: "c1-tty",
+					},
+					Config: &container.Config{
+						Tty: true,
+					},
+				}
+				ml.On("GetContainerDetails", mock.Anything, "c1-tty").Return(inspect, nil)
+
+				mockReader := &mockReadCloser{data: []byte("raw log output\n")}
+				ml.On("GetContainerLogs",
+					mock.Anything,
+					"c1-tty",
+					mock.MatchedBy(func(opts container.LogsOptions) bool {
+						return opts.Follow == false && opts.ShowStdout == true && opts.ShowStderr == true
+					}),
+				).Return(mockReader, nil)
+
+				stream.On("Send", mock.Anything).Return(nil)
+			},
+			expectErr: false,
+			code:      codes.OK,
+		},
+		{
+			name:      "negative tail",
+			req:       &protos.GetContainerLogsRequest{Id: "c1", Tail: &negativeTail},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name:      "negative since",
+			req:       &protos.GetContainerLogsRequest{Id: "c1", SinceNano: -1},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name:      "negative until",
+			req:       &protos.GetContainerLogsRequest{Id: "c1", UntilNano: -1},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name:      "until before since",
+			req:       &protos.GetContainerLogsRequest{Id: "c1", SinceNano: 2000, UntilNano: 1000},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name:      "max line length out of range",
+			req:       &protos.GetContainerLogsRequest{Id: "c1", MaxLineLength: 4 * 1024 * 1024},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name:      "invalid include pattern",
+			req:       &protos.GetContainerLogsRequest{Id: "c1", Include: "(oops"},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name:      "unknown stream",
+			req:       &protos.GetContainerLogsRequest{Id: "c1", Stream: protos.LogStream(42)},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name: "tail and stderr only",
+			req: &protos.GetContainerLogsRequest{Id: "c1", Tail: &tail,
+				Stream: protos.LogStream_LOG_STREAM_STDERR, Timestamps: true},
+			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
+				inspect := container.InspectResponse{
+					ContainerJSONBase: &container.ContainerJSONBase{ID: "c1"},
+					Config:            &container.Config{Tty: true},
+				}
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(inspect, nil)
+				ml.On("GetContainerLogs", mock.Anything, "c1", container.LogsOptions{
+					ShowStderr: true, Timestamps: true, Tail: "10",
+				}).Return(&mockReadCloser{data: []byte("2025-01-01T00:00:00Z oops\n")}, nil)
+				stream.On("Send", mock.Anything).Return(nil)
+			},
+			expectErr: false,
+			code:      codes.OK,
+		},
+		{
+			name: "layer error - inspect fails",
+			req:  &protos.GetContainerLogsRequest{Id: "c2", Follow: false},
+			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
+				ml.On("GetContainerDetails", mock.Anything, "c2").Return(
+					container.InspectResponse{}, errors.New("container not found"))
+			},
+			expectErr: true,
+			code:      codes.Internal,
+		},
+		{
+			name: "layer error - logs retrieval fails",
+			req:  &protos.GetContainerLogsRequest{Id: "c3", Follow: false},
+			setup: func(ml *MockLayer, stream *mockContainerLogsStream) {
+				inspect := container.InspectResponse{
+					ContainerJSONBase: &container.ContainerJSONBase{ID: "c3"},
+					Config:            &container.Config{Tty: false},
+				}
+				ml.On("GetContainerDetails", mock.Anything, "c3").Return(inspect, nil)
+				ml.On("GetContainerLogs",
+					mock.Anything,
+					"c3",
+					mock.Anything,
+				).Return(nil, errors.New("cannot get logs"))
+			},
+			expectErr: true,
+			code:      codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			mockStream := &mockContainerLogsStream{}
+
+			if tt.setup != nil {
+				tt.setup(ml, mockStream)
+			}
+
+			svc := &Service{layer: ml}
+			err := svc.GetContainerLogs(tt.req, mockStream)
+
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, tt.code, grpcCode(err))
+			} else {
+				assert.NoError(t, err)
+			}
+			ml.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServiceGetContainerStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       *protos.GetContainerStatsRequest
+		setup     func(*MockLayer, *mockContainerStatsStream)
+		expectErr bool
+		code      codes.Code
+	}{
+		{
+			name:      "missing id",
+			req:       &protos.GetContainerStatsRequest{Id: ""},
+			expectErr: true,
+			code:      codes.InvalidArgument,
+		},
+		{
+			name: "successful stats retrieval",
+			req:  &protos.GetContainerStatsRequest{Id: "c1", Stream: true},
+			setup: func(ml *MockLayer, stream *mockContainerStatsStream) {
+				mockReader := &mockReadCloser{
+					data: []byte(`{"read":"2024-01-01T00:00:00Z","cpu_stats":{"cpu_usage":{"total_usage":1000}}}`),
+				}
+				statsReader := container.StatsResponseReader{
+					Body: mockReader,
+				}
+				ml.On("GetContainerStats",
+					mock.Anything,
+					"c1",
+					true,
+				).Return(statsReader, nil)
+
+				stream.On("Send", mock.Anything).Return(nil)
+			},
+			expectErr: false,
+			code:      codes.OK,
+		},
+		{
+			name: "layer error",
+			req:  &protos.GetContainerStatsRequest{Id: "c2", Stream: false},
+			setup: func(ml *MockLayer, stream *mockContainerStatsStream) {
+				ml.On("GetContainerStats",
+					mock.Anything,
+					"c2",
+					false,
+				).Return(container.StatsResponseReader{}, errors.New("container not found"))
+			},
+			expectErr: true,
+			code:      codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			mockStream := &mockContainerStatsStream{}
+
+			if tt.setup != nil {
+				tt.setup(ml, mockStream)
+			}
+
+			svc := &Service{layer: ml}
+			err := svc.GetContainerStats(tt.req, mockStream)
+
+			if tt.expectErr {
+				assert.Error(t, err)
+				assert.Equal(t, tt.code, grpcCode(err))
+			} else {
+				assert.NoError(t, err)
+			}
+			ml.AssertExpectations(t)
+			mockStream.AssertExpectations(t)
+		})
+	}
+}
+
+type mockReadCloser struct {
+	data []byte
+	pos  int
+}
+
+func (m *mockReadCloser) Read(p []byte) (n int, err error) {
+	if m.pos >= len(m.data) {
+		return 0, io.EOF
+	}
+	n = copy(p, m.data[m.pos:])
+	m.pos += n
+	return n, nil
+}
+
+func (m *mockReadCloser) Close() error {
+	return nil
+}
+
+type mockContainerLogsStream struct {
+	mock.Mock
+}
+
+func (m *mockContainerLogsStream) Send(resp *protos.GetContainerLogsResponse) error {
+	args := m.Called(resp)
+	return args.Error(0)
+}
+
+func (m *mockContainerLogsStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockContainerLogsStream) SendMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockContainerLogsStream) RecvMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockContainerLogsStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockContainerLogsStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockContainerLogsStream) SetTrailer(metadata.MD) {
+}
+
+type mockContainerStatsStream struct {
+	mock.Mock
+}
+
+func (m *mockContainerStatsStream) Send(resp *protos.GetContainerStatsResponse) error {
+	args := m.Called(resp)
+	return args.Error(0)
+}
+
+func (m *mockContainerStatsStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockContainerStatsStream) SendMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockContainerStatsStream) RecvMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockContainerStatsStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockContainerStatsStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockContainerStatsStream) SetTrailer(metadata.MD) {
+}
+
+type mockExecStream struct {
+	ctx  context.Context
+	recv chan *protos.ExecContainerRequest
+	mu   sync.Mutex
+	sent []*protos.ExecContainerResponse
+}
+
+func newMockExecStream(msgs ...*protos.ExecContainerRequest) *mockExecStream {
+	recv := make(chan *protos.ExecContainerRequest, len(msgs))
+	for _, m := range msgs {
+		recv <- m
+	}
+	close(recv)
+	return &mockExecStream{ctx: context.Background(), recv: recv}
+}
+
+func (m *mockExecStream) Recv() (*protos.ExecContainerRequest, error) {
+	select {
+	case req, ok := <-m.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-m.ctx.Done():
+		return nil, m.ctx.Err()
+	}
+}
+
+// Send records a copy of the output, as gRPC serializes it before the service reuses its buffer.
+func (m *mockExecStream) Send(resp *protos.ExecContainerResponse) error {
+	switch p := resp.GetPayload().(type) {
+	case *protos.ExecContainerResponse_Stdout:
+		resp = &protos.ExecContainerResponse{Payload: &protos.ExecContainerResponse_Stdout{Stdout: bytes.Clone(p.Stdout)}}
+	case *protos.ExecContainerResponse_Stderr:
+		resp = &protos.ExecContainerResponse{Payload: &protos.ExecContainerResponse_Stderr{Stderr: bytes.Clone(p.Stderr)}}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, resp)
+	return nil
+}
+
+func (m *mockExecStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockExecStream) SendMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockExecStream) RecvMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockExecStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockExecStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockExecStream) SetTrailer(metadata.MD) {
+}
+
+// fakeHijack returns a hijacked connection whose daemon side expects stdin and
+// answers with output produced by reply, then closes.
+func fakeHijack(t *testing.T, stdin string, reply func(w io.Writer)) types.HijackedResponse {
+	t.Helper()
+	agentSide, daemonSide := net.Pipe()
+	go func() {
+		defer daemonSide.Close()
+		buf := make([]byte, len(stdin))
+		if _, err := io.ReadFull(daemonSide, buf); err != nil || string(buf) != stdin {
+			return
+		}
+		reply(daemonSide)
+	}()
+	return types.HijackedResponse{Conn: agentSide, Reader: bufio.NewReader(agentSide)}
+}
+
+func TestServiceExecContainer(t *testing.T) {
+	start := func(s *protos.ExecStart) *protos.ExecContainerRequest {
+		return &protos.ExecContainerRequest{Payload: &protos.ExecContainerRequest_Start{Start: s}}
+	}
+	stdin := func(b string) *protos.ExecContainerRequest {
+		return &protos.ExecContainerRequest{Payload: &protos.ExecContainerRequest_Stdin{Stdin: []byte(b)}}
+	}
+	resize := &protos.ExecContainerRequest{Payload: &protos.ExecContainerRequest_Resize{
+		Resize: &protos.TerminalSize{Height: 40, Width: 120}}}
+
+	tests := []struct {
+		name     string
+		msgs     []*protos.ExecContainerRequest
+		setup    func(*testing.T, *MockLayer)
+		code     codes.Code
+		expected []*protos.ExecContainerResponse
+	}{
+		{
+			name: "first message is not start",
+			msgs: []*protos.ExecContainerRequest{stdin("ls")},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing container id",
+			msgs: []*protos.ExecContainerRequest{start(&protos.ExecStart{Cmd: []string{"sh"}})},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing command",
+			msgs: []*protos.ExecContainerRequest{start(&protos.ExecStart{Id: "c1"})},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "create exec fails",
+			msgs: []*protos.ExecContainerRequest{start(&protos.ExecStart{Id: "c1", Cmd: []string{"sh"}})},
+			setup: func(_ *testing.T, ml *MockLayer) {
+				ml.On("CreateExec", mock.Anything, "c1", mock.Anything).
+					Return(container.ExecCreateResponse{}, errors.New("not running"))
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "attach fails",
+			msgs: []*protos.ExecContainerRequest{start(&protos.ExecStart{Id: "c1", Cmd: []string{"sh"}})},
+			setup: func(_ *testing.T, ml *MockLayer) {
+				ml.On("CreateExec", mock.Anything, "c1", mock.Anything).
+					Return(container.ExecCreateResponse{ID: "e1"}, nil)
+				ml.On("AttachExec", mock.Anything, "e1", mock.Anything).
+					Return(types.HijackedResponse{}, errors.New("gone"))
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "demultiplexed output and exit code",
+			msgs: []*protos.ExecContainerRequest{
+				start(&protos.ExecStart{Id: "c1", Cmd: []string{"sh"}, Env: []string{"A=1"}, User: "app",
+					WorkingDir: "/srv"}),
+				stdin("ls\n"),
+				resize,
+			},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("CreateExec", mock.Anything, "c1", mock.MatchedBy(func(o container.ExecOptions) bool {
+					return !o.Tty && o.AttachStdin && o.User == "app" && o.WorkingDir == "/srv" &&
+						len(o.Env) == 1 && o.Cmd[0] == "sh"
+				})).Return(container.ExecCreateResponse{ID: "e1"}, nil)
+				ml.On("AttachExec", mock.Anything, "e1", container.ExecAttachOptions{}).
+					Return(fakeHijack(t, "ls\n", func(w io.Writer) {
+						_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("out"))
+						_, _ = stdcopy.NewStdWriter(w, stdcopy.Stderr).Write([]byte("err"))
+					}), nil)
+				ml.On("ResizeExec", mock.Anything, "e1", container.ResizeOptions{Height: 40, Width: 120}).
+					Return(nil).Maybe()
+				ml.On("InspectExec", mock.Anything, "e1").
+					Return(container.ExecInspect{ExecID: "e1", ExitCode: 2}, nil)
+			},
+			code: codes.OK,
+			expected: []*protos.ExecContainerResponse{
+				{Payload: &protos.ExecContainerResponse_Stdout{Stdout: []byte("out")}},
+				{Payload: &protos.ExecContainerResponse_Stderr{Stderr: []byte("err")}},
+				{Payload: &protos.ExecContainerResponse_ExitCode{ExitCode: 2}},
+			},
+		},
+		{
+			name: "tty output is raw",
+			msgs: []*protos.ExecContainerRequest{
+				start(&protos.ExecStart{Id: "c1", Cmd: []string{"sh"}, Tty: true,
+					Size: &protos.TerminalSize{Height: 24, Width: 80}}),
+				stdin("x"),
+			},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("CreateExec", mock.Anything, "c1", mock.MatchedBy(func(o container.ExecOptions) bool {
+					return o.Tty && o.ConsoleSize != nil && *o.ConsoleSize == [2]uint{24, 80}
+				})).Return(container.ExecCreateResponse{ID: "e2"}, nil)
+				ml.On("AttachExec", mock.Anything, "e2",
+					container.ExecAttachOptions{Tty: true, ConsoleSize: &[2]uint{24, 80}}).
+					Return(fakeHijack(t, "x", func(w io.Writer) {
+						_, _ = w.Write([]byte("$ "))
+					}), nil)
+				ml.On("InspectExec", mock.Anything, "e2").
+					Return(container.ExecInspect{ExecID: "e2", ExitCode: 0}, nil)
+			},
+			code: codes.OK,
+			expected: []*protos.ExecContainerResponse{
+				{Payload: &protos.ExecContainerResponse_Stdout{Stdout: []byte("$ ")}},
+				{Payload: &protos.ExecContainerResponse_ExitCode{ExitCode: 0}},
+			},
+		},
+		{
+			name: "exit code after the exec dies",
+			msgs: []*protos.ExecContainerRequest{
+				start(&protos.ExecStart{Id: "c1", Cmd: []string{"sh"}, Tty: true}),
+				stdin("x"),
+			},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("CreateExec", mock.Anything, "c1", mock.Anything).
+					Return(container.ExecCreateResponse{ID: "e3"}, nil)
+				ml.On("AttachExec", mock.Anything, "e3", container.ExecAttachOptions{Tty: true}).
+					Return(fakeHijack(t, "x", func(io.Writer) {}), nil)
+				ml.On("InspectExec", mock.Anything, "e3").
+					Return(container.ExecInspect{ExecID: "e3", Running: true}, nil).Once()
+				msgs := make(chan events.Message, 2)
+				msgs <- events.Message{Action: events.ActionExecDie,
+					Actor: events.Actor{ID: "c1", Attributes: map[string]string{"execID": "other"}}}
+				msgs <- events.Message{Action: events.ActionExecDie,
+					Actor: events.Actor{ID: "c1", Attributes: map[string]string{"execID": "e3"}}}
+				ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+					return o.Since != "" && o.Filters.ExactMatch("container", "c1") &&
+						o.Filters.ExactMatch("event", string(events.ActionExecDie))
+				})).Return((<-chan events.Message)(msgs), (<-chan error)(make(chan error)))
+				ml.On("InspectExec", mock.Anything, "e3").
+					Return(container.ExecInspect{ExecID: "e3", ExitCode: 3}, nil).Once()
+			},
+			code: codes.OK,
+			expected: []*protos.ExecContainerResponse{
+				{Payload: &protos.ExecContainerResponse_ExitCode{ExitCode: 3}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			if tt.setup != nil {
+				tt.setup(t, ml)
+			}
+			stream := newMockExecStream(tt.msgs...)
+
+			err := (&Service{layer: ml}).ExecContainer(stream)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			if tt.expected != nil {
+				assert.Equal(t, tt.expected, stream.sent)
+			}
+			ml.AssertExpectations(t)
+		})
+	}
+}
+
+type mockAttachStream struct {
+	ctx  context.Context
+	recv chan *protos.AttachContainerRequest
+	mu   sync.Mutex
+	sent []*protos.AttachContainerResponse
+}
+
+func newMockAttachStream(msgs ...*protos.AttachContainerRequest) *mockAttachStream {
+	recv := make(chan *protos.AttachContainerRequest, len(msgs))
+	for _, m := range msgs {
+		recv <- m
+	}
+	close(recv)
+	return &mockAttachStream{ctx: context.Background(), recv: recv}
+}
+
+func (m *mockAttachStream) Recv() (*protos.AttachContainerRequest, error) {
+	select {
+	case req, ok := <-m.recv:
+		if !ok {
+			return nil, io.EOF
+		}
+		return req, nil
+	case <-m.ctx.Done():
+		return nil, m.ctx.Err()
+	}
+}
+
+// Send records a copy of the output, as gRPC serializes it before the service reuses its buffer.
+func (m *mockAttachStream) Send(resp *protos.AttachContainerResponse) error {
+	switch p := resp.GetPayload().(type) {
+	case *protos.AttachContainerResponse_Stdout:
+		resp = &protos.AttachContainerResponse{Payload: &protos.AttachContainerResponse_Stdout{Stdout: bytes.Clone(p.Stdout)}}
+	case *protos.AttachContainerResponse_Stderr:
+		resp = &protos.AttachContainerResponse{Payload: &protos.AttachContainerResponse_Stderr{Stderr: bytes.Clone(p.Stderr)}}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, resp)
+	return nil
+}
+
+func (m *mockAttachStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockAttachStream) SendMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockAttachStream) RecvMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockAttachStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockAttachStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockAttachStream) SetTrailer(metadata.MD) {
+}
+
+func TestServiceAttachContainer(t *testing.T) {
+	start := func(s *protos.AttachStart) *protos.AttachContainerRequest {
+		return &protos.AttachContainerRequest{Payload: &protos.AttachContainerRequest_Start{Start: s}}
+	}
+	stdin := func(b string) *protos.AttachContainerRequest {
+		return &protos.AttachContainerRequest{Payload: &protos.AttachContainerRequest_Stdin{Stdin: []byte(b)}}
+	}
+	details := func(running, tty bool) container.InspectResponse {
+		return container.InspectResponse{
+			ContainerJSONBase: &container.ContainerJSONBase{
+				ID:    "c1",
+				State: &container.State{Running: running},
+			},
+			Config: &container.Config{Tty: tty, OpenStdin: true},
+		}
+	}
+	waiting := func() (<-chan container.WaitResponse, <-chan error) {
+		return make(chan container.WaitResponse), make(chan error)
+	}
+	exited := func(code int64) (<-chan container.WaitResponse, <-chan error) {
+		waits := make(chan container.WaitResponse, 1)
+		waits <- container.WaitResponse{StatusCode: code}
+		return waits, make(chan error)
+	}
+
+	tests := []struct {
+		name           string
+		msgs           []*protos.AttachContainerRequest
+		cancelOnAttach bool
+		setup          func(*testing.T, *MockLayer)
+		code           codes.Code
+		expected       []*protos.AttachContainerResponse
+	}{
+		{
+			name: "first message is not start",
+			msgs: []*protos.AttachContainerRequest{stdin("ls")},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing container id",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{})},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "invalid detach keys",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1", DetachKeys: "bogus"})},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "inspect fails",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"})},
+			setup: func(_ *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").
+					Return(container.InspectResponse{}, errors.New("no such container"))
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "container not running",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"})},
+			setup: func(_ *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(false, true), nil)
+			},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "attach fails",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"})},
+			setup: func(_ *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(true, false), nil)
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(waiting())
+				ml.On("AttachContainer", mock.Anything, "c1", mock.Anything).
+					Return(types.HijackedResponse{}, errors.New("invalid detach keys"))
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "multiplexed output until exit",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"}), stdin("q")},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(true, false), nil)
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(exited(137))
+				ml.On("AttachContainer", mock.Anything, "c1", container.AttachOptions{
+					Stream: true, Stdin: true, Stdout: true, Stderr: true,
+				}).Return(fakeHijack(t, "q", func(w io.Writer) {
+					_, _ = stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("bye"))
+					_, _ = stdcopy.NewStdWriter(w, stdcopy.Stderr).Write([]byte("killed"))
+				}), nil)
+			},
+			code: codes.OK,
+			expected: []*protos.AttachContainerResponse{
+				{Payload: &protos.AttachContainerResponse_Stdout{Stdout: []byte("bye")}},
+				{Payload: &protos.AttachContainerResponse_Stderr{Stderr: []byte("killed")}},
+				{Payload: &protos.AttachContainerResponse_ExitCode{ExitCode: 137}},
+			},
+		},
+		{
+			name: "tty detach keeps container running",
+			msgs: []*protos.AttachContainerRequest{
+				start(&protos.AttachStart{Id: "c1", DetachKeys: "ctrl-x",
+					Size: &protos.TerminalSize{Height: 24, Width: 80}}),
+				stdin("\x18"),
+			},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(true, true), nil)
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(waiting())
+				ml.On("AttachContainer", mock.Anything, "c1", container.AttachOptions{
+					Stream: true, Stdin: true, Stdout: true, Stderr: true, DetachKeys: "ctrl-x",
+				}).Return(fakeHijack(t, "\x18", func(w io.Writer) {
+					_, _ = w.Write([]byte("# "))
+				}), nil)
+				ml.On("ResizeContainer", mock.Anything, "c1", container.ResizeOptions{Height: 24, Width: 80}).
+					Return(nil)
+			},
+			code: codes.OK,
+			expected: []*protos.AttachContainerResponse{
+				{Payload: &protos.AttachContainerResponse_Stdout{Stdout: []byte("# ")}},
+				{Payload: &protos.AttachContainerResponse_Detached{Detached: true}},
+			},
+		},
+		{
+			name: "detach keys typed one by one detach",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"}), stdin("\x10"), stdin("\x11")},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(true, true), nil)
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(waiting())
+				ml.On("AttachContainer", mock.Anything, "c1", mock.Anything).
+					Return(fakeHijack(t, "\x10\x11", func(io.Writer) {}), nil)
+			},
+			code: codes.OK,
+			expected: []*protos.AttachContainerResponse{
+				{Payload: &protos.AttachContainerResponse_Detached{Detached: true}},
+			},
+		},
+		{
+			name: "pasted detach keys do not detach",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"}), stdin("\x10\x11")},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(true, true), nil)
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(exited(0))
+				ml.On("AttachContainer", mock.Anything, "c1", mock.Anything).
+					Return(fakeHijack(t, "\x10\x11", func(io.Writer) {}), nil)
+			},
+			code: codes.OK,
+			expected: []*protos.AttachContainerResponse{
+				{Payload: &protos.AttachContainerResponse_ExitCode{ExitCode: 0}},
+			},
+		},
+		{
+			name: "tty output closing without detach keys waits for the exit",
+			msgs: []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"}), stdin("exit\n")},
+			setup: func(t *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(true, true), nil)
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(exited(0))
+				ml.On("AttachContainer", mock.Anything, "c1", mock.Anything).
+					Return(fakeHijack(t, "exit\n", func(io.Writer) {}), nil)
+			},
+			code: codes.OK,
+			expected: []*protos.AttachContainerResponse{
+				{Payload: &protos.AttachContainerResponse_ExitCode{ExitCode: 0}},
+			},
+		},
+		{
+			name:           "client goes away",
+			msgs:           []*protos.AttachContainerRequest{start(&protos.AttachStart{Id: "c1"})},
+			cancelOnAttach: true,
+			setup: func(_ *testing.T, ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(details(true, false), nil)
+				ml.On("WaitContainer", mock.Anything, "c1", container.WaitConditionNextExit).Return(waiting())
+			},
+			code: codes.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			if tt.setup != nil {
+				tt.setup(t, ml)
+			}
+			stream := newMockAttachStream(tt.msgs...)
+			if tt.cancelOnAttach {
+				ctx, cancel := context.WithCancel(context.Background())
+				stream.ctx = ctx
+				ml.On("AttachContainer", mock.Anything, "c1", mock.Anything).
+					Run(func(mock.Arguments) { cancel() }).
+					Return(fakeHijack(t, "never", func(io.Writer) {}), nil)
+			}
+
+			err := (&Service{layer: ml}).AttachContainer(stream)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			if tt.expected != nil {
+				assert.Equal(t, tt.expected, stream.sent)
+			}
+			ml.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLogSplitter(t *testing.T) {
+	const ts1, ts2 = "2025-01-01T00:00:00.5Z ", "2025-01-01T00:00:01Z "
+	nano1 := time.Date(2025, 1, 1, 0, 0, 0, 500000000, time.UTC).UnixNano()
+	nano2 := time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC).UnixNano()
+	stdout, stderr := protos.LogStream_LOG_STREAM_STDOUT, protos.LogStream_LOG_STREAM_STDERR
+
+	type write struct {
+		stream protos.LogStream
+		data   string
+	}
+	tests := []struct {
+		name           string
+		maxLen         int
+		keepTimestamps bool
+		writes         []write
+		expected       []logLine
+	}{
+		{
+			name:   "lines keep their stream and timestamp",
+			maxLen: 100,
+			writes: []write{{stdout, ts1 + "hello\n" + ts2 + "world\n"}, {stderr, ts2 + "oops\n"}},
+			expected: []logLine{
+				{stream: stdout, timestamp: nano1, text: []byte("hello")},
+				{stream: stdout, timestamp: nano2, text: []byte("world")},
+				{stream: stderr, timestamp: nano2, text: []byte("oops")},
+			},
+		},
+		{
+			name:           "timestamp prefix kept on request",
+			maxLen:         100,
+			keepTimestamps: true,
+			writes:         []write{{stdout, ts1 + "hello\n"}},
+			expected:       []logLine{{stream: stdout, timestamp: nano1, text: []byte(ts1 + "hello")}},
+		},
+		{
+			name:   "line and timestamp split across writes",
+			maxLen: 100,
+			writes: []write{{stdout, ts1[:10]}, {stdout, ts1[10:] + "hel"}, {stdout, "lo\n"}},
+			expected: []logLine{
+				{stream: stdout, timestamp: nano1, text: []byte("hello")},
+			},
+		},
+		{
+			name:   "long line is cut into partial lines",
+			maxLen: 4,
+			writes: []write{{stderr, ts2 + "abcdefghij\n"}},
+			expected: []logLine{
+				{stream: stderr, timestamp: nano2, text: []byte("abcd"), partial: true},
+				{stream: stderr, timestamp: nano2, text: []byte("efgh"), partial: true},
+				{stream: stderr, timestamp: nano2, text: []byte("ij")},
+			},
+		},
+		{
+			name:     "line without timestamp",
+			maxLen:   100,
+			writes:   []write{{stdout, "plain text\n"}},
+			expected: []logLine{{stream: stdout, text: []byte("plain text")}},
+		},
+		{
+			name:     "unterminated output is flushed on close",
+			maxLen:   100,
+			writes:   []write{{stdout, ts1 + "$ "}},
+			expected: []logLine{{stream: stdout, timestamp: nano1, text: []byte("$ ")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []logLine
+			s := newLogSplitter(tt.maxLen, 0, tt.keepTimestamps, func(l logLine) error {
+				got = append(got, l)
+				return nil
+			})
+			for _, w := range tt.writes {
+				n, err := s.writer(w.stream).Write([]byte(w.data))
+				require.NoError(t, err)
+				assert.Equal(t, len(w.data), n)
+			}
+			require.NoError(t, s.Close())
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestLogSplitterIdleFlush(t *testing.T) {
+	var mu sync.Mutex
+	var got []logLine
+	s := newLogSplitter(100, 10*time.Millisecond, false, func(l logLine) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, l)
+		return nil
+	})
+	w := s.writer(protos.LogStream_LOG_STREAM_STDOUT)
+
+	_, err := w.Write([]byte("2025-01-01T00:00:01Z Password: "))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	_, err = w.Write([]byte("***\n"))
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	nano := time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC).UnixNano()
+	assert.Equal(t, []logLine{
+		{stream: protos.LogStream_LOG_STREAM_STDOUT, timestamp: nano, text: []byte("Password: "), partial: true},
+		{stream: protos.LogStream_LOG_STREAM_STDOUT, timestamp: nano, text: []byte("***")},
+	}, got)
+}
+
+func TestLogSplitterEmitError(t *testing.T) {
+	calls := 0
+	s := newLogSplitter(100, 0, false, func(logLine) error {
+		calls++
+		return errors.New("client gone")
+	})
+	w := s.writer(protos.LogStream_LOG_STREAM_STDERR)
+
+	_, err := w.Write([]byte("a\nb\n"))
+	assert.EqualError(t, err, "client gone")
+	_, err = w.Write([]byte("c\n"))
+	assert.EqualError(t, err, "client gone")
+	assert.EqualError(t, s.Close(), "client gone")
+	assert.Equal(t, 1, calls)
+}
+
+func TestLogFilter(t *testing.T) {
+	lines := []string{
+		`{"level":"info","msg":"boot"}`,
+		`{"level":"debug","msg":"cache warm"}`,
+		`{"level":"error","msg":"db timeout"}`,
+		`{"level":30,"msg":"retry"}`,
+		`{"level":"WARNING","msg":"slow"}`,
+		`plain text line`,
+	}
+
+	tests := []struct {
+		name     string
+		req      *protos.GetContainerLogsRequest
+		expected []string
+		context  []bool
+		levels   []string
+	}{
+		{
+			name:     "no filter passes everything",
+			req:      &protos.GetContainerLogsRequest{},
+			expected: lines,
+			context:  []bool{false, false, false, false, false, false},
+			levels:   []string{"", "", "", "", "", ""},
+		},
+		{
+			name:     "include is case sensitive by default",
+			req:      &protos.GetContainerLogsRequest{Include: "DB|slow"},
+			expected: []string{lines[4]},
+			context:  []bool{false},
+			levels:   []string{""},
+		},
+		{
+			name:     "case-insensitive include with exclude",
+			req:      &protos.GetContainerLogsRequest{Include: "DB|SLOW|BOOT", Exclude: "warn", IgnoreCase: true},
+			expected: []string{lines[0], lines[2]},
+			context:  []bool{false, false},
+			levels:   []string{"", ""},
+		},
+		{
+			name:     "levels from string and numeric fields",
+			req:      &protos.GetContainerLogsRequest{Levels: []string{"info", "warn"}},
+			expected: []string{lines[0], lines[3], lines[4]},
+			context:  []bool{false, false, false},
+			levels:   []string{"info", "info", "warn"},
+		},
+		{
+			name:     "context around a match",
+			req:      &protos.GetContainerLogsRequest{Levels: []string{"error"}, ContextBefore: 1, ContextAfter: 2},
+			expected: []string{lines[1], lines[2], lines[3], lines[4]},
+			context:  []bool{true, false, true, true},
+			levels:   []string{"debug", "error", "info", "warn"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newLogFilter(tt.req)
+			require.NoError(t, err)
+
+			var got []string
+			var context []bool
+			var levels []string
+			for i, l := range lines {
+				err = f.apply(&protos.GetContainerLogsResponse{Line: []byte(l), Seq: uint64(i + 1)},
+					func(r *protos.GetContainerLogsResponse) error {
+						got = append(got, string(r.GetLine()))
+						context = append(context, r.GetContext())
+						levels = append(levels, r.GetLevel())
+						return nil
+					})
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, got)
+			assert.Equal(t, tt.context, context)
+			assert.Equal(t, tt.levels, levels)
+		})
+	}
+}
+
+func TestNewLogFilterErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *protos.GetContainerLogsRequest
+		errText string
+	}{
+		{
+			name:    "invalid include",
+			req:     &protos.GetContainerLogsRequest{Include: "(unclosed"},
+			errText: "invalid include pattern",
+		},
+		{
+			name:    "pattern too long",
+			req:     &protos.GetContainerLogsRequest{Exclude: strings.Repeat("a", maxLogPatternLength+1)},
+			errText: "longer than",
+		},
+		{
+			name:    "pattern too complex",
+			req:     &protos.GetContainerLogsRequest{Include: strings.Repeat("[a-z]{1000}", 11)},
+			errText: "too complex",
+		},
+		{
+			name:    "unknown level",
+			req:     &protos.GetContainerLogsRequest{Levels: []string{"verbose"}},
+			errText: "unknown log level",
+		},
+		{
+			name:    "too many context lines",
+			req:     &protos.GetContainerLogsRequest{ContextAfter: maxLogContextLines + 1},
+			errText: "context lines",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newLogFilter(tt.req)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errText)
+		})
+	}
+}
+
+func TestExtractLogLevel(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{line: `{"level":"error","message":"zerolog"}`, expected: "error"},
+		{line: `{"level":"warning","msg":"logrus"}`, expected: "warn"},
+		{line: `{"name":"app","level":50,"msg":"bunyan"}`, expected: "error"},
+		{line: `{"level":10,"msg":"bunyan"}`, expected: "trace"},
+		{line: `2025-01-01T00:00:00Z {"level":"info"}`, expected: "info"},
+		{line: `{"msg":"no level"}`, expected: ""},
+		{line: `{"level":"verbose"}`, expected: ""},
+		{line: `level=error msg=logfmt`, expected: ""},
+		{line: `{broken`, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			assert.Equal(t, tt.expected, extractLogLevel([]byte(tt.line)))
+		})
+	}
+}
+
+type mockMultiLogsStream struct {
+	ctx    context.Context
+	sent   []*protos.StreamMultiContainerLogsResponse
+	onSend func(n int)
+}
+
+func (m *mockMultiLogsStream) Send(resp *protos.StreamMultiContainerLogsResponse) error {
+	m.sent = append(m.sent, resp)
+	if m.onSend != nil {
+		m.onSend(len(m.sent))
+	}
+	return nil
+}
+
+func (m *mockMultiLogsStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockMultiLogsStream) SendMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockMultiLogsStream) RecvMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockMultiLogsStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockMultiLogsStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockMultiLogsStream) SetTrailer(metadata.MD) {
+}
+
+func (m *mockMultiLogsStream) lines() []string {
+	res := make([]string, 0, len(m.sent))
+	for _, r := range m.sent {
+		res = append(res, r.GetContainerName()+": "+string(r.GetLog().GetLine()))
+	}
+	return res
+}
+
+func ttyContainer(id, name string) container.InspectResponse {
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{ID: id, Name: "/" + name},
+		Config:            &container.Config{Tty: true},
+	}
+}
+
+func TestServiceStreamMultiContainerLogs(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *protos.StreamMultiContainerLogsRequest
+		setup    func(*MockLayer)
+		code     codes.Code
+		expected []string
+	}{
+		{
+			name: "no selection",
+			req:  &protos.StreamMultiContainerLogsRequest{},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "ids and labels together",
+			req:  &protos.StreamMultiContainerLogsRequest{Ids: []string{"c1"}, Labels: []string{"app=shop"}},
+			code: codes.InvalidArgument,
 		},
 		{
-			name: "successful stats retrieval",
-			req:  &protos.GetContainerStatsRequest{Id: "c1", Stream: true},
-			setup: func(ml *MockLayer, stream *mockContainerStatsStream) {
-				mockReader := &mockReadCloser{
-					data: []byte(`{"read":"2024-01-01T00:00:00Z","cpu_stats":{"cpu_usage":{"total_usage":1000}}}`),
-				}
-				statsReader := container.StatsResponseReader{
-					Body: mockReader,
-				}
-				ml.On("GetContainerStats",
-					mock.Anything,
-					"c1",
-					true,
-				).Return(statsReader, nil)
-
-				stream.On("Send", mock.Anything).Return(nil)
+			name: "invalid log options",
+			req: &protos.StreamMultiContainerLogsRequest{Ids: []string{"c1"},
+				Options: &protos.GetContainerLogsRequest{Include: "(bad"}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "unknown container",
+			req:  &protos.StreamMultiContainerLogsRequest{Ids: []string{"c1"}},
+			setup: func(ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").
+					Return(container.InspectResponse{}, errors.New("no such container"))
 			},
-			expectErr: false,
-			code:      codes.OK,
+			code: codes.Internal,
 		},
 		{
-			name: "layer error",
-			req:  &protos.GetContainerStatsRequest{Id: "c2", Stream: false},
-			setup: func(ml *MockLayer, stream *mockContainerStatsStream) {
-				ml.On("GetContainerStats",
-					mock.Anything,
-					"c2",
-					false,
-				).Return(container.StatsResponseReader{}, errors.New("container not found"))
+			name: "lines merged by timestamp",
+			req:  &protos.StreamMultiContainerLogsRequest{Ids: []string{"c1", "c2"}},
+			setup: func(ml *MockLayer) {
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(ttyContainer("c1", "api"), nil)
+				ml.On("GetContainerDetails", mock.Anything, "c2").Return(ttyContainer("c2", "db"), nil)
+				ml.On("GetContainerLogs", mock.Anything, "c1", mock.Anything).Return(&mockReadCloser{
+					data: []byte("2025-01-01T00:00:01Z request\n2025-01-01T00:00:03Z response\n"),
+				}, nil)
+				ml.On("GetContainerLogs", mock.Anything, "c2", mock.Anything).Return(&mockReadCloser{
+					data: []byte("2025-01-01T00:00:02Z query\n"),
+				}, nil)
 			},
-			expectErr: true,
-			code:      codes.Internal,
+			code:     codes.OK,
+			expected: []string{"api: request", "db: query", "api: response"},
+		},
+		{
+			name: "label selection includes stopped containers",
+			req:  &protos.StreamMultiContainerLogsRequest{Labels: []string{"com.docker.compose.project=shop"}},
+			setup: func(ml *MockLayer) {
+				ml.On("GetContainers", mock.Anything, container.ListOptions{
+					All:     true,
+					Filters: filters.NewArgs(filters.Arg("label", "com.docker.compose.project=shop")),
+				}).Return([]container.Summary{{ID: "c1"}}, nil)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(ttyContainer("c1", "shop-web-1"), nil)
+				ml.On("GetContainerLogs", mock.Anything, "c1", mock.Anything).
+					Return(&mockReadCloser{data: []byte("2025-01-01T00:00:01Z ready\n")}, nil)
+			},
+			code:     codes.OK,
+			expected: []string{"shop-web-1: ready"},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ml := &MockLayer{}
-			mockStream := &mockContainerStatsStream{}
-
 			if tt.setup != nil {
-				tt.setup(ml, mockStream)
+				tt.setup(ml)
 			}
+			stream := &mockMultiLogsStream{ctx: context.Background()}
 
-			svc := &Service{layer: ml}
-			err := svc.GetContainerStats(tt.req, mockStream)
+			err := (&Service{layer: ml}).StreamMultiContainerLogs(tt.req, stream)
 
-			if tt.expectErr {
-				assert.Error(t, err)
-				assert.Equal(t, tt.code, grpcCode(err))
-			} else {
-				assert.NoError(t, err)
+			assert.Equal(t, tt.code, grpcCode(err))
+			if tt.expected != nil {
+				assert.Equal(t, tt.expected, stream.lines())
 			}
 			ml.AssertExpectations(t)
-			mockStream.AssertExpectations(t)
 		})
 	}
 }
 
-type mockReadCloser struct {
-	data []byte
-	pos  int
+func TestServiceStreamMultiContainerLogsPicksUpStartedContainers(t *testing.T) {
+	started := time.Now().Add(time.Second).UnixNano()
+	starts := make(chan events.Message, 1)
+	starts <- events.Message{
+		Type:     events.ContainerEventType,
+		Action:   events.ActionStart,
+		Actor:    events.Actor{ID: "c2"},
+		TimeNano: started,
+	}
+
+	ml := &MockLayer{}
+	ml.On("GetContainers", mock.Anything, mock.Anything).Return([]container.Summary{{ID: "c1"}}, nil)
+	ml.On("GetContainerDetails", mock.Anything, "c1").Return(ttyContainer("c1", "web-1"), nil)
+	ml.On("GetContainerDetails", mock.Anything, "c2").Return(ttyContainer("c2", "web-2"), nil)
+	ml.On("GetContainerLogs", mock.Anything, "c1", mock.MatchedBy(func(o container.LogsOptions) bool {
+		return o.Follow && o.Tail == "5"
+	})).Return(&mockReadCloser{data: []byte("2025-01-01T00:00:01Z old\n")}, nil)
+	ml.On("GetContainerLogs", mock.Anything, "c2", mock.MatchedBy(func(o container.LogsOptions) bool {
+		return o.Follow && o.Tail == "all" && o.Since == service.FormatUnixNano(started)
+	})).Return(&mockReadCloser{data: []byte("2025-01-01T00:00:05Z new\n")}, nil)
+	ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+		return o.Filters.ExactMatch("event", "start") && o.Filters.ExactMatch("label", "app=web")
+	})).Return((<-chan events.Message)(starts), (<-chan error)(make(chan error)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &mockMultiLogsStream{ctx: ctx, onSend: func(n int) {
+		if n == 2 {
+			cancel()
+		}
+	}}
+	tail := int64(5)
+
+	err := (&Service{layer: ml}).StreamMultiContainerLogs(&protos.StreamMultiContainerLogsRequest{
+		Labels:  []string{"app=web"},
+		Options: &protos.GetContainerLogsRequest{Follow: true, Tail: &tail},
+	}, stream)
+
+	assert.Equal(t, codes.Canceled, grpcCode(err))
+	assert.Equal(t, []string{"web-1: old", "web-2: new"}, stream.lines())
+	ml.AssertExpectations(t)
 }
 
-func (m *mockReadCloser) Read(p []byte) (n int, err error) {
-	if m.pos >= len(m.data) {
-		return 0, io.EOF
-	}
-	n = copy(p, m.data[m.pos:])
-	m.pos += n
-	return n, nil
+func TestServiceStreamMultiContainerLogsResubscribesToStartEvents(t *testing.T) {
+	interrupted := make(chan error, 1)
+	interrupted <- errors.New("unexpected EOF")
+	started := time.Now().Add(time.Second).UnixNano()
+	starts := make(chan events.Message, 2)
+	starts <- events.Message{Type: events.ContainerEventType, Action: events.ActionStart,
+		Actor: events.Actor{ID: "c2"}, TimeNano: started}
+	// The same start is replayed, as since is inclusive, and must not be picked up twice.
+	starts <- events.Message{Type: events.ContainerEventType, Action: events.ActionStart,
+		Actor: events.Actor{ID: "c2"}, TimeNano: started}
+
+	ml := &MockLayer{}
+	ml.On("GetContainerDetails", mock.Anything, "c1").Return(ttyContainer("c1", "web-1"), nil)
+	ml.On("GetContainerDetails", mock.Anything, "c2").Return(ttyContainer("c2", "web-2"), nil)
+	ml.On("GetContainerLogs", mock.Anything, "c1", mock.Anything).
+		Return(&mockReadCloser{data: []byte("2025-01-01T00:00:01Z old\n")}, nil)
+	ml.On("GetContainerLogs", mock.Anything, "c2", mock.Anything).
+		Return(&mockReadCloser{data: []byte("2025-01-01T00:00:05Z new\n")}, nil).Once()
+	ml.On("GetEvents", mock.Anything, mock.Anything).
+		Return((<-chan events.Message)(make(chan events.Message)), (<-chan error)(interrupted)).Once()
+	ml.On("GetEvents", mock.Anything, mock.Anything).
+		Return((<-chan events.Message)(starts), (<-chan error)(make(chan error))).Once()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream := &mockMultiLogsStream{ctx: ctx, onSend: func(n int) {
+		if n == 2 {
+			time.AfterFunc(2*logMergeWindow, cancel)
+		}
+	}}
+
+	err := (&Service{layer: ml}).StreamMultiContainerLogs(&protos.StreamMultiContainerLogsRequest{
+		Ids:     []string{"c1"},
+		Options: &protos.GetContainerLogsRequest{Follow: true},
+	}, stream)
+
+	assert.Equal(t, codes.Canceled, grpcCode(err))
+	assert.Equal(t, []string{"web-1: old", "web-2: new"}, stream.lines())
+	ml.AssertExpectations(t)
 }
 
-func (m *mockReadCloser) Close() error {
-	return nil
+func TestLogMerger(t *testing.T) {
+	line := func(id string, ts int64) *mergedLine {
+		return &mergedLine{target: logTarget{id: id}, frame: &protos.GetContainerLogsResponse{TimestampNano: ts}}
+	}
+	now := time.Now()
+	m := newLogMerger(time.Second)
+	m.add("a")
+	m.add("b")
+
+	var sent []int64
+	send := func(l *mergedLine) error {
+		sent = append(sent, l.frame.GetTimestampNano())
+		return nil
+	}
+
+	m.push(line("a", 10), now)
+	m.push(line("a", 30), now)
+	require.NoError(t, m.flush(now, send))
+	assert.Empty(t, sent, "waits for b to catch up")
+
+	m.push(line("b", 20), now)
+	require.NoError(t, m.flush(now, send))
+	assert.Equal(t, []int64{10, 20}, sent)
+
+	require.NoError(t, m.flush(now.Add(time.Second), send))
+	assert.Equal(t, []int64{10, 20, 30}, sent, "merge window expired")
+
+	m.push(line("a", 40), now)
+	m.finish("b")
+	require.NoError(t, m.flush(now, send))
+	assert.Equal(t, []int64{10, 20, 30, 40}, sent)
+	assert.False(t, m.drained())
+
+	m.finish("a")
+	assert.True(t, m.drained())
 }
 
-type mockContainerLogsStream struct {
-	mock.Mock
+type mockAllStatsStream struct {
+	ctx    context.Context
+	sent   []*protos.StreamAllStatsResponse
+	onSend func(n int)
 }
 
-func (m *mockContainerLogsStream) Send(resp *protos.GetContainerLogsResponse) error {
-	args := m.Called(resp)
-	return args.Error(0)
+func (m *mockAllStatsStream) Send(resp *protos.StreamAllStatsResponse) error {
+	m.sent = append(m.sent, resp)
+	if m.onSend != nil {
+		m.onSend(len(m.sent))
+	}
+	return nil
 }
 
-func (m *mockContainerLogsStream) Context() context.Context {
-	return context.Background()
+func (m *mockAllStatsStream) Context() context.Context {
+	return m.ctx
 }
 
-func (m *mockContainerLogsStream) SendMsg(msg interface{}) error {
+func (m *mockAllStatsStream) SendMsg(msg interface{}) error {
 	return nil
 }
 
-func (m *mockContainerLogsStream) RecvMsg(msg interface{}) error {
+func (m *mockAllStatsStream) RecvMsg(msg interface{}) error {
 	return nil
 }
 
-func (m *mockContainerLogsStream) SetHeader(metadata.MD) error {
+func (m *mockAllStatsStream) SetHeader(metadata.MD) error {
 	return nil
 }
 
-func (m *mockContainerLogsStream) SendHeader(metadata.MD) error {
+func (m *mockAllStatsStream) SendHeader(metadata.MD) error {
 	return nil
 }
 
-func (m *mockContainerLogsStream) SetTrailer(metadata.MD) {
+func (m *mockAllStatsStream) SetTrailer(metadata.MD) {
 }
 
-type mockContainerStatsStream struct {
-	mock.Mock
+func statsReader(id, name string) container.StatsResponseReader {
+	return container.StatsResponseReader{
+		Body: &mockReadCloser{data: []byte(`{"id":"` + id + `","name":"/` + name + `","pids_stats":{"current":3}}`)},
+	}
 }
 
-func (m *mockContainerStatsStream) Send(resp *protos.GetContainerStatsResponse) error {
-	args := m.Called(resp)
-	return args.Error(0)
+func cpuStatsReader(id string, total, system uint64) container.StatsResponseReader {
+	return container.StatsResponseReader{
+		Body: &mockReadCloser{data: []byte(fmt.Sprintf(
+			`{"id":%q,"name":"/%s","cpu_stats":{"cpu_usage":{"total_usage":%d},"system_cpu_usage":%d,"online_cpus":2}}`,
+			id, id, total, system))},
+	}
 }
 
-func (m *mockContainerStatsStream) Context() context.Context {
-	return context.Background()
+func batchNames(resp *protos.StreamAllStatsResponse) []string {
+	var names []string
+	for _, s := range resp.GetStats() {
+		names = append(names, s.GetName())
+	}
+	return names
 }
 
-func (m *mockContainerStatsStream) SendMsg(msg interface{}) error {
-	return nil
+func TestServiceStreamAllStats(t *testing.T) {
+	noEvents := func(ml *MockLayer) {
+		ml.On("GetEvents", mock.Anything, mock.Anything).
+			Return((<-chan events.Message)(make(chan events.Message)), (<-chan error)(make(chan error)))
+	}
+	later := func() int64 { return time.Now().Add(time.Minute).UnixNano() }
+
+	tests := []struct {
+		name     string
+		req      *protos.StreamAllStatsRequest
+		setup    func(*MockLayer)
+		code     codes.Code
+		expected [][]string
+	}{
+		{
+			name: "interval too short",
+			req:  &protos.StreamAllStatsRequest{IntervalMs: 500},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "list error",
+			req:  &protos.StreamAllStatsRequest{},
+			setup: func(ml *MockLayer) {
+				noEvents(ml)
+				ml.On("GetContainers", mock.Anything, container.ListOptions{}).
+					Return([]container.Summary(nil), errors.New("daemon down"))
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "batch skips containers that fail to sample",
+			req:  &protos.StreamAllStatsRequest{},
+			setup: func(ml *MockLayer) {
+				noEvents(ml)
+				ml.On("GetContainers", mock.Anything, container.ListOptions{}).
+					Return([]container.Summary{{ID: "c1"}, {ID: "c2"}, {ID: "c3"}}, nil)
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(statsReader("c1", "web"), nil)
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c2").Return(statsReader("c2", "api"), nil)
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c3").
+					Return(container.StatsResponseReader{}, errors.New("container stopped"))
+			},
+			code:     codes.Canceled,
+			expected: [][]string{{"api", "web"}},
+		},
+		{
+			name: "events add and drop containers",
+			req:  &protos.StreamAllStatsRequest{IntervalMs: 1000},
+			setup: func(ml *MockLayer) {
+				started := later()
+				evs := make(chan events.Message, 2)
+				evs <- events.Message{Action: events.ActionStart, Actor: events.Actor{ID: "c2"}, TimeNano: started}
+				evs <- events.Message{Action: events.ActionDie, Actor: events.Actor{ID: "c1"}, TimeNano: started + 1}
+				ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+					return o.Filters.ExactMatch("event", "start") && o.Filters.ExactMatch("event", "die")
+				})).Return((<-chan events.Message)(evs), (<-chan error)(make(chan error)))
+				ml.On("GetContainers", mock.Anything, container.ListOptions{}).
+					Return([]container.Summary{{ID: "c1"}}, nil)
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(statsReader("c1", "web"), nil).Once()
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c2").Return(statsReader("c2", "db"), nil).Once()
+			},
+			code:     codes.Canceled,
+			expected: [][]string{{"web"}, {"db"}},
+		},
+		{
+			name: "interrupted events are resubscribed",
+			req:  &protos.StreamAllStatsRequest{IntervalMs: 1000},
+			setup: func(ml *MockLayer) {
+				interrupted := make(chan error, 1)
+				interrupted <- errors.New("unexpected EOF")
+				evs := make(chan events.Message, 1)
+				evs <- events.Message{Action: events.ActionStart, Actor: events.Actor{ID: "c2"}, TimeNano: later()}
+				ml.On("GetEvents", mock.Anything, mock.Anything).
+					Return((<-chan events.Message)(make(chan events.Message)), (<-chan error)(interrupted)).Once()
+				ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+					return o.Since != ""
+				})).Return((<-chan events.Message)(evs), (<-chan error)(make(chan error))).Once()
+				ml.On("GetContainers", mock.Anything, container.ListOptions{}).
+					Return([]container.Summary{{ID: "c1"}}, nil).Once()
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(statsReader("c1", "web"), nil).Once()
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(statsReader("c1", "web"), nil).Once()
+				ml.On("GetContainerStatsOneShot", mock.Anything, "c2").Return(statsReader("c2", "db"), nil).Once()
+			},
+			code:     codes.Canceled,
+			expected: [][]string{{"web"}, {"db", "web"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			if tt.setup != nil {
+				tt.setup(ml)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stream := &mockAllStatsStream{ctx: ctx, onSend: func(n int) {
+				if n == len(tt.expected) {
+					cancel()
+				}
+			}}
+
+			err := (&Service{layer: ml}).StreamAllStats(tt.req, stream)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			require.Len(t, stream.sent, len(tt.expected))
+			for i, names := range tt.expected {
+				assert.Equal(t, names, batchNames(stream.sent[i]))
+			}
+			ml.AssertExpectations(t)
+		})
+	}
 }
 
-func (m *mockContainerStatsStream) RecvMsg(msg interface{}) error {
-	return nil
+func TestStatsSampler(t *testing.T) {
+	t.Run("cpu percent from the previous sample", func(t *testing.T) {
+		ml := &MockLayer{}
+		ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(cpuStatsReader("c1", 100, 1000), nil).Once()
+		ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(cpuStatsReader("c1", 300, 2000), nil).Once()
+		sampler := newStatsSampler(ml)
+
+		first := sampler.sampleAll(context.Background(), []string{"c1"})
+		require.Len(t, first, 1)
+		assert.False(t, first[0].cpuKnown)
+		assert.Zero(t, first[0].stats.GetCpuPercent())
+
+		second := sampler.sampleAll(context.Background(), []string{"c1"})
+		require.Len(t, second, 1)
+		assert.True(t, second[0].cpuKnown)
+		assert.InDelta(t, 40.0, second[0].stats.GetCpuPercent(), 1e-9)
+		ml.AssertExpectations(t)
+	})
+
+	t.Run("forgets containers that are gone", func(t *testing.T) {
+		ml := &MockLayer{}
+		ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(cpuStatsReader("c1", 100, 1000), nil).Once()
+		ml.On("GetContainerStatsOneShot", mock.Anything, "c2").Return(cpuStatsReader("c2", 100, 1000), nil).Once()
+		sampler := newStatsSampler(ml)
+
+		sampler.sampleAll(context.Background(), []string{"c1"})
+		sampler.sampleAll(context.Background(), []string{"c2"})
+		assert.Len(t, sampler.prev, 1)
+		assert.Contains(t, sampler.prev, "c2")
+	})
+
+	t.Run("more containers than workers with slow samples", func(t *testing.T) {
+		const delay = 50 * time.Millisecond
+		ml := &MockLayer{}
+		ids := make([]string, 5*statsWorkers)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("c%02d", i)
+			ml.On("GetContainerStatsOneShot", mock.Anything, ids[i]).
+				Return(cpuStatsReader(ids[i], 100, 1000), nil).After(delay)
+		}
+
+		started := time.Now()
+		batch := newStatsSampler(ml).sampleAll(context.Background(), ids)
+
+		require.Len(t, batch, len(ids))
+		for i, sample := range batch {
+			assert.Equal(t, ids[i], sample.stats.GetName())
+		}
+		assert.Less(t, time.Since(started), time.Duration(len(ids))*delay, "samples are taken concurrently")
+	})
 }
 
-func (m *mockContainerStatsStream) SetHeader(metadata.MD) error {
-	return nil
+func TestServiceGetStatsHistory(t *testing.T) {
+	history, err := metrics.NewStore([]metrics.Tier{{Resolution: 10 * time.Second, Retention: time.Hour}}, 1<<20)
+	require.NoError(t, err)
+
+	ml := &MockLayer{}
+	ml.On("GetContainers", mock.Anything, container.ListOptions{}).
+		Return([]container.Summary{{ID: "c1"}, {ID: "c2"}}, nil)
+	ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(statsReader("c1", "web"), nil).Once()
+	ml.On("GetContainerStatsOneShot", mock.Anything, "c1").Return(statsReader("c1", "web"), nil).Once()
+	ml.On("GetContainerStatsOneShot", mock.Anything, "c2").
+		Return(container.StatsResponseReader{}, errors.New("container stopped"))
+
+	svc := &Service{layer: ml, history: history}
+	sampler := newStatsSampler(ml)
+	svc.recordStats(context.Background(), sampler)
+	assert.Empty(t, history.Query(nil, time.Time{}, time.Time{}, 0), "the first sample only primes the CPU usage")
+	svc.recordStats(context.Background(), sampler)
+	ml.AssertExpectations(t)
+
+	since := time.Now().Add(-time.Minute).UnixNano()
+	tests := []struct {
+		name    string
+		svc     *Service
+		req     *protos.GetStatsHistoryRequest
+		code    codes.Code
+		ids     []string
+		samples int
+	}{
+		{
+			name: "history disabled",
+			svc:  &Service{layer: ml},
+			req:  &protos.GetStatsHistoryRequest{},
+			code: codes.FailedPrecondition,
+		},
+		{
+			name: "until before since",
+			svc:  svc,
+			req:  &protos.GetStatsHistoryRequest{SinceNano: since, UntilNano: since - 1},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "negative resolution",
+			svc:  svc,
+			req:  &protos.GetStatsHistoryRequest{ResolutionMs: -1},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:    "all recorded containers",
+			svc:     svc,
+			req:     &protos.GetStatsHistoryRequest{SinceNano: since},
+			code:    codes.OK,
+			ids:     []string{"c1"},
+			samples: 1,
+		},
+		{
+			name: "unknown container",
+			svc:  svc,
+			req:  &protos.GetStatsHistoryRequest{Ids: []string{"c2"}, SinceNano: since},
+			code: codes.OK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.svc.GetStatsHistory(context.Background(), tt.req)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			if err != nil {
+				return
+			}
+			require.Len(t, resp.GetSeries(), len(tt.ids))
+			for i, id := range tt.ids {
+				se := resp.GetSeries()[i]
+				assert.Equal(t, id, se.GetContainerId())
+				assert.Equal(t, "web", se.GetContainerName())
+				assert.Equal(t, int64(10000), se.GetResolutionMs())
+				require.Len(t, se.GetPoints(), tt.samples)
+				assert.Equal(t, uint64(3), se.GetPoints()[0].GetPids())
+			}
+		})
+	}
 }
 
-func (m *mockContainerStatsStream) SendHeader(metadata.MD) error {
+type mockRunStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*protos.RunContainerResponse
+}
+
+func (m *mockRunStream) Send(resp *protos.RunContainerResponse) error {
+	m.sent = append(m.sent, resp)
 	return nil
 }
 
-func (m *mockContainerStatsStream) SetTrailer(metadata.MD) {
+func (m *mockRunStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockRunStream) phases() []protos.RunPhase {
+	var phases []protos.RunPhase
+	for _, resp := range m.sent {
+		phases = append(phases, resp.GetPhase())
+	}
+	return phases
+}
+
+func TestServiceRunContainer(t *testing.T) {
+	nginx := &protos.CreateContainerRequest{Image: "nginx:latest", Name: "web"}
+	running := container.InspectResponse{NetworkSettings: &container.NetworkSettings{
+		NetworkSettingsBase: container.NetworkSettingsBase{Ports: nat.PortMap{
+			"80/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}},
+		}},
+	}}
+	notFound := fmt.Errorf("cannot get image details: %w", cerrdefs.ErrNotFound)
+
+	tests := []struct {
+		name   string
+		req    *protos.RunContainerRequest
+		setup  func(*MockLayer)
+		code   codes.Code
+		phases []protos.RunPhase
+	}{
+		{
+			name: "present image is created, connected and started",
+			req: &protos.RunContainerRequest{
+				Container:       nginx,
+				ConnectNetworks: []*protos.NetworkEndpoint{{Network: "backend", Aliases: []string{"web"}}},
+			},
+			setup: func(ml *MockLayer) {
+				ml.On("GetImageDetails", mock.Anything, "nginx:latest").Return(image.InspectResponse{}, nil)
+				ml.On("CreateContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+					"web").Return(container.CreateResponse{ID: "c1"}, nil)
+				ml.On("ConnectNetwork", mock.Anything, "backend", "c1",
+					&network.EndpointSettings{Aliases: []string{"web"}}).Return(nil)
+				ml.On("StartContainer", mock.Anything, "c1", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(running, nil)
+			},
+			code: codes.OK,
+			phases: []protos.RunPhase{
+				protos.RunPhase_RUN_PHASE_CREATING,
+				protos.RunPhase_RUN_PHASE_CONNECTING,
+				protos.RunPhase_RUN_PHASE_STARTING,
+				protos.RunPhase_RUN_PHASE_RUNNING,
+			},
+		},
+		{
+			name: "missing image is pulled",
+			req:  &protos.RunContainerRequest{Container: nginx, RegistryAuth: "auth"},
+			setup: func(ml *MockLayer) {
+				ml.On("GetImageDetails", mock.Anything, "nginx:latest").Return(image.InspectResponse{}, notFound)
+				ml.On("PullImage", mock.Anything, "nginx:latest", image.PullOptions{RegistryAuth: "auth"}).
+					Return(io.NopCloser(strings.NewReader(
+						`{"status":"Downloading","id":"a1","progressDetail":{"current":10,"total":20}}`+
+							`{"status":"Pull complete","id":"a1"}`)), nil)
+				ml.On("CreateContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+					"web").Return(container.CreateResponse{ID: "c1"}, nil)
+				ml.On("StartContainer", mock.Anything, "c1", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(running, nil)
+			},
+			code: codes.OK,
+			phases: []protos.RunPhase{
+				protos.RunPhase_RUN_PHASE_PULLING,
+				protos.RunPhase_RUN_PHASE_PULLING,
+				protos.RunPhase_RUN_PHASE_CREATING,
+				protos.RunPhase_RUN_PHASE_STARTING,
+				protos.RunPhase_RUN_PHASE_RUNNING,
+			},
+		},
+		{
+			name: "always policy pulls a present image",
+			req:  &protos.RunContainerRequest{Container: nginx, PullPolicy: protos.PullPolicy_PULL_POLICY_ALWAYS},
+			setup: func(ml *MockLayer) {
+				ml.On("PullImage", mock.Anything, "nginx:latest", image.PullOptions{}).
+					Return(io.NopCloser(strings.NewReader(`{"status":"Image is up to date"}`)), nil)
+				ml.On("CreateContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+					"web").Return(container.CreateResponse{ID: "c1"}, nil)
+				ml.On("StartContainer", mock.Anything, "c1", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "c1").Return(running, nil)
+			},
+			code: codes.OK,
+			phases: []protos.RunPhase{
+				protos.RunPhase_RUN_PHASE_PULLING,
+				protos.RunPhase_RUN_PHASE_CREATING,
+				protos.RunPhase_RUN_PHASE_STARTING,
+				protos.RunPhase_RUN_PHASE_RUNNING,
+			},
+		},
+		{
+			name: "never policy fails on a missing image",
+			req:  &protos.RunContainerRequest{Container: nginx, PullPolicy: protos.PullPolicy_PULL_POLICY_NEVER},
+			setup: func(ml *MockLayer) {
+				ml.On("GetImageDetails", mock.Anything, "nginx:latest").Return(image.InspectResponse{}, notFound)
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "pull error fails the run",
+			req:  &protos.RunContainerRequest{Container: nginx},
+			setup: func(ml *MockLayer) {
+				ml.On("GetImageDetails", mock.Anything, "nginx:latest").Return(image.InspectResponse{}, notFound)
+				ml.On("PullImage", mock.Anything, "nginx:latest", image.PullOptions{}).
+					Return(io.NopCloser(strings.NewReader(`{"errorDetail":{"message":"manifest unknown"}}`)), nil)
+			},
+			code: codes.Internal,
+		},
+		{
+			name: "start failure removes the container",
+			req:  &protos.RunContainerRequest{Container: nginx},
+			setup: func(ml *MockLayer) {
+				ml.On("GetImageDetails", mock.Anything, "nginx:latest").Return(image.InspectResponse{}, nil)
+				ml.On("CreateContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+					"web").Return(container.CreateResponse{ID: "c1"}, nil)
+				ml.On("StartContainer", mock.Anything, "c1", container.StartOptions{}).
+					Return(errors.New("port is already allocated"))
+				ml.On("RemoveContainer", mock.Anything, "c1", container.RemoveOptions{Force: true}).Return(nil)
+			},
+			code: codes.Internal,
+			phases: []protos.RunPhase{
+				protos.RunPhase_RUN_PHASE_CREATING,
+				protos.RunPhase_RUN_PHASE_STARTING,
+				protos.RunPhase_RUN_PHASE_ROLLING_BACK,
+			},
+		},
+		{
+			name: "start failure removes the volumes created for the container",
+			req: &protos.RunContainerRequest{Container: &protos.CreateContainerRequest{
+				Image:      "nginx:latest",
+				Name:       "web",
+				HostConfig: &protos.HostConfig{Mounts: []*protos.Mount{dataVolume(true)}},
+			}},
+			setup: func(ml *MockLayer) {
+				ml.On("GetImageDetails", mock.Anything, "nginx:latest").Return(image.InspectResponse{}, nil)
+				ml.On("GetVolumeDetails", mock.Anything, "pgdata").
+					Return(volume.Volume{}, fmt.Errorf("cannot inspect volume pgdata: %w", cerrdefs.ErrNotFound))
+				ml.On("CreateVolume", mock.Anything, mock.Anything).Return(volume.Volume{Name: "pgdata"}, nil)
+				ml.On("CreateContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+					"web").Return(container.CreateResponse{ID: "c1"}, nil)
+				ml.On("StartContainer", mock.Anything, "c1", container.StartOptions{}).
+					Return(errors.New("port is already allocated"))
+				ml.On("RemoveContainer", mock.Anything, "c1", container.RemoveOptions{Force: true}).Return(nil)
+				ml.On("RemoveVolume", mock.Anything, "pgdata", false).Return(nil)
+			},
+			code: codes.Internal,
+			phases: []protos.RunPhase{
+				protos.RunPhase_RUN_PHASE_CREATING,
+				protos.RunPhase_RUN_PHASE_STARTING,
+				protos.RunPhase_RUN_PHASE_ROLLING_BACK,
+			},
+		},
+		{
+			name: "inspect failure after start keeps the container",
+			req:  &protos.RunContainerRequest{Container: nginx},
+			setup: func(ml *MockLayer) {
+				ml.On("GetImageDetails", mock.Anything, "nginx:latest").Return(image.InspectResponse{}, nil)
+				ml.On("CreateContainer", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything,
+					"web").Return(container.CreateResponse{ID: "c1"}, nil)
+				ml.On("StartContainer", mock.Anything, "c1", container.StartOptions{}).Return(nil)
+				ml.On("GetContainerDetails", mock.Anything, "c1").
+					Return(container.InspectResponse{}, errors.New("connection reset"))
+			},
+			code: codes.Internal,
+			phases: []protos.RunPhase{
+				protos.RunPhase_RUN_PHASE_CREATING,
+				protos.RunPhase_RUN_PHASE_STARTING,
+			},
+		},
+		{
+			name: "network joined twice",
+			req: &protos.RunContainerRequest{
+				Container:       &protos.CreateContainerRequest{Image: "nginx", Networks: []string{"backend"}},
+				ConnectNetworks: []*protos.NetworkEndpoint{{Network: "backend"}},
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "missing container",
+			req:  &protos.RunContainerRequest{},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &MockLayer{}
+			if tt.setup != nil {
+				tt.setup(ml)
+			}
+			stream := &mockRunStream{ctx: context.Background()}
+			svc := &Service{layer: ml}
+
+			err := svc.RunContainer(tt.req, stream)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			assert.Equal(t, tt.phases, stream.phases())
+			if tt.code == codes.OK {
+				last := stream.sent[len(stream.sent)-1]
+				assert.Equal(t, "c1", last.GetContainerId())
+				assert.Equal(t, []*protos.PortMapping{
+					{HostIp: "0.0.0.0", HostPort: 32768, ContainerPort: 80, Protocol: "tcp"},
+				}, last.GetPorts())
+			}
+			ml.AssertExpectations(t)
+		})
+	}
 }
@@ -15,7 +15,13 @@ import (
 
 	"github.com/whiteo/yadoma/internal/protos"
 
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-connections/nat"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog/log"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -24,24 +30,149 @@ import (
 // CreateContainer creates a Docker container from the request.
 // It validates that an image is provided, maps request fields into container, host, and networking configs,
 // and delegates creation to the Docker layer with the given name and a default OCI platform.
-// On success, it returns the new container ID.
-// On failure, it returns a gRPC error (codes.InvalidArgument for missing image, codes.Internal for creation errors).
+// Besides image, command and environment, the request covers entrypoint, working directory, user,
+// host and domain name, labels, exposed ports, stop signal and timeout, healthcheck, and TTY/stdin settings.
+// The host config covers CPU, memory, PIDs and ulimit limits, devices, capabilities, privileged mode,
+// read-only root filesystem, security options, sysctls, tmpfs, DNS and extra hosts, the log driver,
+// shared memory size, and init. Published ports are also exposed and may be tcp, udp, or sctp
+// ports or ranges; a zero host port is allocated by Docker when the container starts.
+// On success, it returns the new container ID and the requested port bindings; the host ports
+// Docker allocated are reported by GetContainerDetails once the container runs.
+// Mounts may be bind, volume, or tmpfs mounts with their options. Named volumes must exist,
+// unless the mount asks to create them, in which case they are created with the driver and
+// labels of the mount's volume options, and removed again if the container cannot be created.
+// Networks join with default settings unless an endpoint configures static IPv4/IPv6 and
+// link-local addresses, aliases, MAC address, links, or driver options.
+// On failure, it returns a gRPC error (codes.InvalidArgument for a missing image or an invalid field,
+// with the message naming the field, codes.NotFound for a missing volume, codes.Internal for
+// volume and container creation errors).
 func (s *Service) CreateContainer(
 	ctx context.Context,
 	req *protos.CreateContainerRequest,
 ) (*protos.CreateContainerResponse, error) {
+	spec, err := mapCreateSpec(req)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _, err := s.createContainer(ctx, req, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &protos.CreateContainerResponse{
+		Id:    id,
+		Ports: mapPortMap(spec.hostConfig.PortBindings),
+	}, nil
+}
+
+// createSpec holds the Docker configs a container is created with.
+type createSpec struct {
+	config     *container.Config
+	hostConfig *container.HostConfig
+	networking *network.NetworkingConfig
+}
+
+// mapCreateSpec validates the request and maps it into the configs of a new container.
+// Published ports are added to the exposed ports. It returns codes.InvalidArgument
+// errors naming the invalid field.
+func mapCreateSpec(req *protos.CreateContainerRequest) (*createSpec, error) {
 	if req.GetImage() == "" {
 		return nil, status.Error(codes.InvalidArgument, "image is required")
 	}
 
-	config := mapConfig(req)
-	hostConfig := mapHostConfig(req.GetHostConfig())
-	networkingConfig := mapNetworking(req.GetNetworks())
+	config, err := mapConfig(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	hostConfig, err := mapHostConfig(req.GetHostConfig())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err = validateNetworking(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for port := range hostConfig.PortBindings {
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = nat.PortSet{}
+		}
+		config.ExposedPorts[port] = struct{}{}
+	}
+
+	return &createSpec{config: config, hostConfig: hostConfig, networking: mapNetworking(req)}, nil
+}
 
-	resp, err := s.layer.CreateContainer(ctx, config, hostConfig, networkingConfig, &ocispec.Platform{}, req.GetName())
+// createContainer ensures the volumes of the request exist and creates the container
+// from spec. Volumes created for it are removed again if that fails. It returns the
+// new container ID and the names of the volumes created for it, or a gRPC status error.
+func (s *Service) createContainer(
+	ctx context.Context,
+	req *protos.CreateContainerRequest,
+	spec *createSpec,
+) (string, []string, error) {
+	created, err := s.ensureVolumes(ctx, req.GetHostConfig().GetMounts())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "cannot create container: %v", err)
+		s.removeVolumes(ctx, created)
+		return "", nil, err
 	}
 
-	return &protos.CreateContainerResponse{Id: resp.ID}, nil
+	resp, err := s.layer.CreateContainer(ctx, spec.config, spec.hostConfig, spec.networking,
+		&ocispec.Platform{}, req.GetName())
+	if err != nil {
+		s.removeVolumes(ctx, created)
+		return "", nil, status.Errorf(codes.Internal, "cannot create container: %v", err)
+	}
+	return resp.ID, created, nil
+}
+
+// ensureVolumes checks that the named volumes of volume mounts exist and creates the
+// missing ones whose mount asks for it. It returns the names of the volumes it
+// created, also when it fails on a later mount.
+func (s *Service) ensureVolumes(ctx context.Context, mounts []*protos.Mount) ([]string, error) {
+	var created []string
+	for i, m := range mounts {
+		if m.GetType() != protos.MountType_MOUNT_TYPE_VOLUME || m.GetSource() == "" {
+			continue
+		}
+		_, err := s.layer.GetVolumeDetails(ctx, m.GetSource())
+		switch {
+		case err == nil:
+			continue
+		case !cerrdefs.IsNotFound(err):
+			return created, status.Errorf(codes.Internal, "cannot inspect volume %s: %v", m.GetSource(), err)
+		case !m.GetCreateVolume():
+			return created, status.Errorf(codes.NotFound,
+				"host_config.mounts[%d].source: volume %q does not exist, set create_volume to create it", i, m.GetSource())
+		}
+
+		opts := volume.CreateOptions{Name: m.GetSource(), Labels: m.GetVolumeOptions().GetLabels()}
+		if d := m.GetVolumeOptions().GetDriverConfig(); d != nil {
+			opts.Driver = d.GetName()
+			opts.DriverOpts = d.GetOptions()
+		}
+		if _, err = s.layer.CreateVolume(ctx, opts); err != nil {
+			return created, status.Errorf(codes.Internal, "cannot create volume %s: %v", m.GetSource(), err)
+		}
+		created = append(created, m.GetSource())
+		log.Info().Str("volume", m.GetSource()).Msg("Created volume for container mount")
+	}
+	return created, nil
+}
+
+// removeVolumes removes the volumes created for a container that could not be
+// created or run. Failures are logged, as the error of the failed step is the one
+// returned.
+func (s *Service) removeVolumes(ctx context.Context, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	for _, name := range names {
+		if err := s.layer.RemoveVolume(ctx, name, false); err != nil {
+			log.Error().Err(err).Str("volume", name).Msg("cannot remove volume of failed container")
+			continue
+		}
+		log.Info().Str("volume", name).Msg("Removed volume of failed container")
+	}
 }
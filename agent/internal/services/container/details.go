@@ -23,7 +23,12 @@ import (
 // It validates the request (returns codes.InvalidArgument when the ID is empty) and delegates
 // to the Docker layer using the provided context.
 // On success, it maps the result to protos.GetContainerDetailsResponse, including status,
-// created time, mounts, and network settings. On Docker layer failure, it returns a
+// created time, mounts, network settings, and published ports with the host ports Docker
+// allocated for them. The state covers the exit code, OOM kill, PID, error, start and finish
+// times, and health with the last health check; the restart count, labels, command,
+// entrypoint, restart policy, resource limits, and configured port bindings are included too.
+// The environment is returned with the values of variables whose names suggest a secret,
+// such as passwords, tokens, and keys, masked. On Docker layer failure, it returns a
 // gRPC error with codes.Internal and additional context.
 func (s *Service) GetContainerDetails(
 	ctx context.Context,
@@ -37,14 +42,37 @@ func (s *Service) GetContainerDetails(
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot get container details: %v", err)
 	}
+	if details.ContainerJSONBase == nil {
+		return nil, status.Errorf(codes.Internal, "cannot get container details: empty response for %s", req.GetId())
+	}
 
-	return &protos.GetContainerDetailsResponse{
-		Id:       details.ID,
-		Image:    details.Image,
-		Name:     details.Name,
-		Status:   extractStatus(details.State),
-		Created:  details.Created,
-		Mounts:   mapMounts(details.Mounts),
-		Networks: mapNetworks(details.NetworkSettings.Networks),
-	}, nil
+	resp := &protos.GetContainerDetailsResponse{
+		Id:           details.ID,
+		Image:        details.Image,
+		Name:         details.Name,
+		Status:       extractStatus(details.State),
+		Created:      details.Created,
+		Mounts:       mapMounts(details.Mounts),
+		State:        mapState(details.State),
+		RestartCount: clampToInt32(details.RestartCount),
+	}
+	if details.NetworkSettings != nil {
+		resp.Networks = mapNetworks(details.NetworkSettings.Networks)
+		resp.Ports = mapPortMap(details.NetworkSettings.Ports)
+	}
+	if c := details.Config; c != nil {
+		resp.Labels = c.Labels
+		resp.Env = maskEnv(c.Env)
+		resp.Cmd = c.Cmd
+		resp.Entrypoint = c.Entrypoint
+	}
+	if h := details.HostConfig; h != nil {
+		resp.RestartPolicy = &protos.RestartPolicy{
+			Name:              string(h.RestartPolicy.Name),
+			MaximumRetryCount: clampToInt32(h.RestartPolicy.MaximumRetryCount),
+		}
+		resp.Resources = mapResourceLimits(h.Resources)
+		resp.PortBindings = mapPortMap(h.PortBindings)
+	}
+	return resp, nil
 }
@@ -0,0 +1,221 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// execExitPollInterval is how often a finished exec is inspected in case its
+// exec_die event is missed.
+const execExitPollInterval = time.Second
+
+// ExecContainer runs a command inside a running container over a bidirectional stream.
+// The first client message must carry ExecStart with the container ID, command, and
+// optional env, user, working directory, TTY flag, and initial terminal size.
+// Subsequent client messages carry stdin bytes, terminal resize events, or a stdin close;
+// half-closing the client side also closes the process stdin.
+// Output is sent as stdout/stderr frames (demultiplexed with stdcopy when no TTY is
+// allocated), followed by a final frame with the exit code from exec inspect.
+// Returns InvalidArgument for a malformed start message, Internal for Docker failures,
+// and the client's cancellation status if it goes away.
+func (s *Service) ExecContainer(stream protos.ContainerService_ExecContainerServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot read exec start: %v", err)
+	}
+
+	start := first.GetStart()
+	if start == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry exec start")
+	}
+	if start.GetId() == "" {
+		return status.Error(codes.InvalidArgument, "container ID is required")
+	}
+	if len(start.GetCmd()) == 0 {
+		return status.Error(codes.InvalidArgument, "command is required")
+	}
+
+	ctx := stream.Context()
+	started := time.Now()
+	exec, err := s.layer.CreateExec(ctx, start.GetId(), mapExecOptions(start))
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot create exec: %v", err)
+	}
+
+	hijacked, err := s.layer.AttachExec(ctx, exec.ID, container.ExecAttachOptions{
+		Tty:         start.GetTty(),
+		ConsoleSize: mapConsoleSize(start.GetSize()),
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot attach to exec: %v", err)
+	}
+	defer hijacked.Close()
+	// The hijacked connection ignores ctx, so close it when the client goes away.
+	stop := context.AfterFunc(ctx, hijacked.Close)
+	defer stop()
+
+	log.Debug().
+		Str("container", start.GetId()).
+		Str("exec", exec.ID).
+		Bool("tty", start.GetTty()).
+		Msg("Exec session started")
+
+	go s.forwardExecInput(ctx, stream, exec.ID, &hijacked)
+
+	err = streamOutput(hijacked.Reader, start.GetTty(),
+		func(p []byte) error {
+			return stream.Send(&protos.ExecContainerResponse{
+				Payload: &protos.ExecContainerResponse_Stdout{Stdout: p},
+			})
+		},
+		func(p []byte) error {
+			return stream.Send(&protos.ExecContainerResponse{
+				Payload: &protos.ExecContainerResponse_Stderr{Stderr: p},
+			})
+		},
+	)
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	exitCode, err := s.waitExecExit(ctx, start.GetId(), exec.ID, started)
+	if ctx.Err() != nil {
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot inspect exec: %v", err)
+	}
+
+	log.Debug().Str("exec", exec.ID).Int("exit_code", exitCode).Msg("Exec session finished")
+	return stream.Send(&protos.ExecContainerResponse{
+		Payload: &protos.ExecContainerResponse_ExitCode{ExitCode: clampToInt32(exitCode)},
+	})
+}
+
+// forwardExecInput copies client messages to the exec process until the client
+// half-closes the stream or the stream context ends.
+func (s *Service) forwardExecInput(
+	ctx context.Context,
+	stream protos.ContainerService_ExecContainerServer,
+	execID string,
+	hijacked *types.HijackedResponse,
+) {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				_ = hijacked.CloseWrite()
+			}
+			return
+		}
+
+		switch p := req.GetPayload().(type) {
+		case *protos.ExecContainerRequest_Stdin:
+			if _, err = hijacked.Conn.Write(p.Stdin); err != nil {
+				log.Debug().Err(err).Str("exec", execID).Msg("Cannot write exec stdin")
+				return
+			}
+		case *protos.ExecContainerRequest_Resize:
+			if err = s.layer.ResizeExec(ctx, execID, mapResizeOptions(p.Resize)); err != nil {
+				log.Warn().Err(err).Str("exec", execID).Msg("Cannot resize exec TTY")
+			}
+		case *protos.ExecContainerRequest_CloseStdin:
+			_ = hijacked.CloseWrite()
+		default:
+			log.Warn().Str("exec", execID).Msg("Ignoring unexpected exec message")
+		}
+	}
+}
+
+// waitExecExit returns the exit code of a finished exec. The output stream may
+// close slightly before the daemon marks the process as exited, so a running exec
+// is inspected again when the container reports an exec_die event since the exec
+// started, and periodically in case the event is missed, until the stream ends.
+func (s *Service) waitExecExit(ctx context.Context, id, execID string, since time.Time) (int, error) {
+	inspect, err := s.layer.InspectExec(ctx, execID)
+	if err != nil {
+		return 0, err
+	}
+	if !inspect.Running {
+		return inspect.ExitCode, nil
+	}
+
+	evCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs, errs := s.layer.GetEvents(evCtx, events.ListOptions{
+		Since: service.FormatUnixNano(since.UnixNano()),
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("container", id),
+			filters.Arg("event", string(events.ActionExecDie)),
+		),
+	})
+
+	ticker := time.NewTicker(execExitPollInterval)
+	defer ticker.Stop()
+
+	for inspect.Running {
+		select {
+		case msg := <-msgs:
+			if msg.Actor.Attributes["execID"] != execID {
+				continue
+			}
+		case err = <-errs:
+			if evCtx.Err() == nil {
+				log.Warn().Err(err).Str("exec", execID).Msg("Cannot follow exec events, polling only")
+			}
+			msgs, errs = nil, nil
+			continue
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+
+		if inspect, err = s.layer.InspectExec(ctx, execID); err != nil {
+			return 0, err
+		}
+	}
+	return inspect.ExitCode, nil
+}
+
+// streamOutput forwards process output to the given senders. With a TTY the
+// output is raw and sent as stdout; otherwise it is demultiplexed with stdcopy.
+func streamOutput(r io.Reader, tty bool, stdout, stderr func([]byte) error) error {
+	if tty {
+		return service.StreamReader(r, stdout)
+	}
+
+	_, err := stdcopy.StdCopy(service.NewStreamWriter(stdout), service.NewStreamWriter(stderr), r)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
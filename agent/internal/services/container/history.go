@@ -0,0 +1,104 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetStatsHistory returns recorded stats of one or more containers, or of all
+// recorded containers if no IDs are given. The range is given in Unix nanoseconds;
+// a zero Until means up to now. Points come from the finest history tier that still
+// covers Since, or, if a resolution is requested, from the finest tier at least as
+// coarse. Containers without history are left out.
+// Returns InvalidArgument for Until before Since or a negative resolution, and
+// FailedPrecondition if the agent does not record stats history.
+func (s *Service) GetStatsHistory(
+	_ context.Context,
+	req *protos.GetStatsHistoryRequest,
+) (*protos.GetStatsHistoryResponse, error) {
+	if s.history == nil {
+		return nil, status.Error(codes.FailedPrecondition, "stats history is disabled")
+	}
+	if req.GetUntilNano() != 0 && req.GetUntilNano() < req.GetSinceNano() {
+		return nil, status.Error(codes.InvalidArgument, "until must not be before since")
+	}
+	if req.GetResolutionMs() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "resolution must not be negative")
+	}
+
+	var until time.Time
+	if req.GetUntilNano() != 0 {
+		until = time.Unix(0, req.GetUntilNano())
+	}
+	series := s.history.Query(req.GetIds(),
+		time.Unix(0, req.GetSinceNano()),
+		until,
+		time.Duration(req.GetResolutionMs())*time.Millisecond,
+	)
+
+	res := &protos.GetStatsHistoryResponse{Series: make([]*protos.StatsSeries, 0, len(series))}
+	for _, se := range series {
+		res.Series = append(res.Series, mapStatsSeries(se))
+	}
+	return res, nil
+}
+
+// RecordStats samples all running containers at the resolution of the stats history
+// and records them until ctx is canceled. The first sample of a container has no CPU
+// percent yet and is not recorded. It does nothing if the history is disabled.
+func (s *Service) RecordStats(ctx context.Context) {
+	if s.history == nil {
+		return
+	}
+	ticker := time.NewTicker(s.history.Resolution())
+	defer ticker.Stop()
+
+	sampler := newStatsSampler(s.layer)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.recordStats(ctx, sampler)
+		}
+	}
+}
+
+func (s *Service) recordStats(ctx context.Context, sampler *statsSampler) {
+	list, err := s.layer.GetContainers(ctx, container.ListOptions{})
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Cannot list containers for stats history")
+		}
+		return
+	}
+	ids := make([]string, 0, len(list))
+	for _, c := range list {
+		ids = append(ids, c.ID)
+	}
+
+	now := time.Now()
+	for _, sample := range sampler.sampleAll(ctx, ids) {
+		if sample.cpuKnown {
+			s.history.Add(sample.stats.GetId(), sample.stats.GetName(), now, mapHistorySample(sample.stats))
+		}
+	}
+}
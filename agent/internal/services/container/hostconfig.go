@@ -0,0 +1,423 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/whiteo/yadoma/internal/protos"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/strslice"
+)
+
+const (
+	// minMemory is the smallest memory limit Docker accepts.
+	minMemory = 6 << 20
+	// hostGateway is the special extra hosts address Docker resolves to the host.
+	hostGateway = "host-gateway"
+)
+
+// volumeNamePattern matches the names Docker accepts for named volumes.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// cpusetPattern matches a list of CPUs or memory nodes such as "0-3,6".
+var cpusetPattern = regexp.MustCompile(`^\d+(-\d+)?(,\d+(-\d+)?)*$`)
+
+// capabilities are the Linux capabilities that can be added or dropped, without the CAP_ prefix.
+var capabilities = map[string]struct{}{
+	"AUDIT_CONTROL": {}, "AUDIT_READ": {}, "AUDIT_WRITE": {}, "BLOCK_SUSPEND": {}, "BPF": {},
+	"CHECKPOINT_RESTORE": {}, "CHOWN": {}, "DAC_OVERRIDE": {}, "DAC_READ_SEARCH": {}, "FOWNER": {},
+	"FSETID": {}, "IPC_LOCK": {}, "IPC_OWNER": {}, "KILL": {}, "LEASE": {}, "LINUX_IMMUTABLE": {},
+	"MAC_ADMIN": {}, "MAC_OVERRIDE": {}, "MKNOD": {}, "NET_ADMIN": {}, "NET_BIND_SERVICE": {},
+	"NET_BROADCAST": {}, "NET_RAW": {}, "PERFMON": {}, "SETFCAP": {}, "SETGID": {}, "SETPCAP": {},
+	"SETUID": {}, "SYS_ADMIN": {}, "SYS_BOOT": {}, "SYS_CHROOT": {}, "SYS_MODULE": {}, "SYS_NICE": {},
+	"SYS_PACCT": {}, "SYS_PTRACE": {}, "SYS_RAWIO": {}, "SYS_RESOURCE": {}, "SYS_TIME": {},
+	"SYS_TTY_CONFIG": {}, "SYSLOG": {}, "WAKE_ALARM": {},
+}
+
+// ulimitNames are the resource limits that can be set for a container.
+var ulimitNames = map[string]struct{}{
+	"core": {}, "cpu": {}, "data": {}, "fsize": {}, "locks": {}, "memlock": {}, "msgqueue": {},
+	"nice": {}, "nofile": {}, "nproc": {}, "rss": {}, "rtprio": {}, "rttime": {}, "sigpending": {},
+	"stack": {},
+}
+
+// securityOpts are the security option keys and whether they require a value.
+var securityOpts = map[string]bool{
+	"apparmor":          true,
+	"label":             true,
+	"no-new-privileges": false,
+	"seccomp":           true,
+	"systempaths":       true,
+	"writable-cgroups":  true,
+}
+
+// validateHostConfig checks the resource, security, and runtime settings of a host
+// config. It returns an error naming the request field of the first invalid value.
+func validateHostConfig(h *protos.HostConfig) error {
+	if err := validateResources("host_config.", mapResources(h)); err != nil {
+		return err
+	}
+	if h.GetMemorySwap() > 0 && h.GetMemory() == 0 {
+		return errors.New("host_config.memory_swap: requires memory to be set")
+	}
+	if h.GetShmSize() < 0 {
+		return fmt.Errorf("host_config.shm_size: %d must not be negative", h.GetShmSize())
+	}
+	if err := validateLimits(h); err != nil {
+		return err
+	}
+	if err := validateSecurity(h); err != nil {
+		return err
+	}
+	if err := validateRestartPolicy(h.GetRestartPolicy()); err != nil {
+		return fmt.Errorf("host_config.%w", err)
+	}
+	return validateRuntime(h)
+}
+
+// validateResources checks the CPU, memory, and PIDs settings that create and update
+// requests share. Field names in errors are prefixed with prefix, the path of the
+// message that holds them.
+func validateResources(prefix string, res container.Resources) error {
+	if err := validateCPU(prefix, res); err != nil {
+		return err
+	}
+	if err := validateMemory(prefix, res); err != nil {
+		return err
+	}
+	if res.PidsLimit != nil && *res.PidsLimit < -1 {
+		return fmt.Errorf("%spids_limit: %d must be -1 or 0 for no limit or a limit", prefix, *res.PidsLimit)
+	}
+	return nil
+}
+
+func validateCPU(prefix string, res container.Resources) error {
+	if res.CPUShares < 0 {
+		return fmt.Errorf("%scpu_shares: %d must not be negative", prefix, res.CPUShares)
+	}
+	if p := res.CPUPeriod; p != 0 && (p < 1000 || p > 1000000) {
+		return fmt.Errorf("%scpu_period: %d must be between 1000 and 1000000 microseconds", prefix, p)
+	}
+	if q := res.CPUQuota; q != 0 && q != -1 && q < 1000 {
+		return fmt.Errorf("%scpu_quota: %d must be at least 1000 microseconds or -1 for no limit", prefix, q)
+	}
+	if res.NanoCPUs < 0 {
+		return fmt.Errorf("%snano_cpus: %d must not be negative", prefix, res.NanoCPUs)
+	}
+	if res.NanoCPUs > 0 && (res.CPUPeriod != 0 || res.CPUQuota != 0) {
+		return fmt.Errorf("%snano_cpus: cannot be combined with cpu_period or cpu_quota", prefix)
+	}
+	if c := res.CpusetCpus; c != "" && !cpusetPattern.MatchString(c) {
+		return fmt.Errorf("%scpuset_cpus: %q is not a CPU list such as 0-3,6", prefix, c)
+	}
+	if m := res.CpusetMems; m != "" && !cpusetPattern.MatchString(m) {
+		return fmt.Errorf("%scpuset_mems: %q is not a memory node list such as 0-1", prefix, m)
+	}
+	return nil
+}
+
+func validateMemory(prefix string, res container.Resources) error {
+	if m := res.Memory; m < 0 || (m > 0 && m < minMemory) {
+		return fmt.Errorf("%smemory: %d must be 0 or at least %d bytes", prefix, m, minMemory)
+	}
+	if r := res.MemoryReservation; r < 0 || (res.Memory > 0 && r > res.Memory) {
+		return fmt.Errorf("%smemory_reservation: %d must not be negative or exceed memory", prefix, r)
+	}
+	swap := res.MemorySwap
+	switch {
+	case swap < -1:
+		return fmt.Errorf("%smemory_swap: %d must be -1 for unlimited swap or a limit", prefix, swap)
+	case swap > 0 && swap < res.Memory:
+		return fmt.Errorf("%smemory_swap: %d must not be less than memory, it includes memory", prefix, swap)
+	}
+	return nil
+}
+
+func validateLimits(h *protos.HostConfig) error {
+	seen := make(map[string]struct{}, len(h.GetUlimits()))
+	for i, u := range h.GetUlimits() {
+		name := strings.ToLower(u.GetName())
+		if _, ok := ulimitNames[name]; !ok {
+			return fmt.Errorf("host_config.ulimits[%d].name: unknown ulimit %q", i, u.GetName())
+		}
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("host_config.ulimits[%d].name: duplicate ulimit %q", i, name)
+		}
+		seen[name] = struct{}{}
+		if u.GetHard() != -1 && (u.GetSoft() == -1 || u.GetSoft() > u.GetHard()) {
+			return fmt.Errorf("host_config.ulimits[%d].soft: %d must not exceed hard limit %d",
+				i, u.GetSoft(), u.GetHard())
+		}
+	}
+	for i, d := range h.GetDevices() {
+		if !path.IsAbs(d.GetPathOnHost()) {
+			return fmt.Errorf("host_config.devices[%d].path_on_host: %q is not an absolute path", i, d.GetPathOnHost())
+		}
+		if p := d.GetPathInContainer(); p != "" && !path.IsAbs(p) {
+			return fmt.Errorf("host_config.devices[%d].path_in_container: %q is not an absolute path", i, p)
+		}
+		if p := d.GetCgroupPermissions(); strings.Trim(p, "rwm") != "" {
+			return fmt.Errorf("host_config.devices[%d].cgroup_permissions: %q must combine r, w and m", i, p)
+		}
+	}
+	return nil
+}
+
+func validateSecurity(h *protos.HostConfig) error {
+	for _, caps := range []struct {
+		field string
+		list  []string
+	}{{"cap_add", h.GetCapAdd()}, {"cap_drop", h.GetCapDrop()}} {
+		for i, c := range caps.list {
+			if normalizeCapability(c) == "" {
+				return fmt.Errorf("host_config.%s[%d]: unknown capability %q", caps.field, i, c)
+			}
+		}
+	}
+	for i, opt := range h.GetSecurityOpt() {
+		key, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			key, value, ok = strings.Cut(opt, ":")
+		}
+		needsValue, known := securityOpts[key]
+		if !known {
+			return fmt.Errorf("host_config.security_opt[%d]: unknown option %q", i, key)
+		}
+		if needsValue && (!ok || value == "") {
+			return fmt.Errorf("host_config.security_opt[%d]: %s requires a value, such as %s=unconfined", i, key, key)
+		}
+	}
+	for key := range h.GetSysctls() {
+		if key == "" || strings.ContainsAny(key, " =") {
+			return fmt.Errorf("host_config.sysctls: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+func validateRuntime(h *protos.HostConfig) error {
+	for target := range h.GetTmpfs() {
+		if !path.IsAbs(target) {
+			return fmt.Errorf("host_config.tmpfs: %q is not an absolute path", target)
+		}
+	}
+	for i, dns := range h.GetDns() {
+		if _, err := netip.ParseAddr(dns); err != nil {
+			return fmt.Errorf("host_config.dns[%d]: %q is not an IP address", i, dns)
+		}
+	}
+	for i, entry := range h.GetExtraHosts() {
+		host, ip, ok := strings.Cut(entry, "=")
+		if !ok {
+			host, ip, ok = strings.Cut(entry, ":")
+		}
+		if !ok || host == "" {
+			return fmt.Errorf("host_config.extra_hosts[%d]: %q must be host:ip or host=ip", i, entry)
+		}
+		if _, err := netip.ParseAddr(strings.Trim(ip, "[]")); err != nil && ip != hostGateway {
+			return fmt.Errorf("host_config.extra_hosts[%d]: %q is not an IP address or %s", i, ip, hostGateway)
+		}
+	}
+	if lc := h.GetLogConfig(); lc.GetType() == "" && len(lc.GetConfig()) > 0 {
+		return errors.New("host_config.log_config.type: required when log options are set")
+	}
+	return nil
+}
+
+// normalizeCapability returns the capability in the CAP_ prefixed form Docker uses,
+// ALL for all capabilities, or an empty string for unknown capabilities.
+func normalizeCapability(c string) string {
+	name := strings.TrimPrefix(strings.ToUpper(c), "CAP_")
+	if name == "ALL" {
+		return name
+	}
+	if _, ok := capabilities[name]; !ok {
+		return ""
+	}
+	return "CAP_" + name
+}
+
+func mapCapabilities(caps []string) strslice.StrSlice {
+	if len(caps) == 0 {
+		return nil
+	}
+	res := make(strslice.StrSlice, 0, len(caps))
+	for _, c := range caps {
+		res = append(res, normalizeCapability(c))
+	}
+	return res
+}
+
+func mapResources(h *protos.HostConfig) container.Resources {
+	res := container.Resources{
+		CPUShares:         h.GetCpuShares(),
+		CPUPeriod:         h.GetCpuPeriod(),
+		CPUQuota:          h.GetCpuQuota(),
+		NanoCPUs:          h.GetNanoCpus(),
+		CpusetCpus:        h.GetCpusetCpus(),
+		CpusetMems:        h.GetCpusetMems(),
+		Memory:            h.GetMemory(),
+		MemoryReservation: h.GetMemoryReservation(),
+		MemorySwap:        h.GetMemorySwap(),
+	}
+	if h.PidsLimit != nil {
+		limit := h.GetPidsLimit()
+		res.PidsLimit = &limit
+	}
+	for _, u := range h.GetUlimits() {
+		res.Ulimits = append(res.Ulimits, &container.Ulimit{
+			Name: strings.ToLower(u.GetName()),
+			Soft: u.GetSoft(),
+			Hard: u.GetHard(),
+		})
+	}
+	for _, d := range h.GetDevices() {
+		device := container.DeviceMapping{
+			PathOnHost:        d.GetPathOnHost(),
+			PathInContainer:   d.GetPathInContainer(),
+			CgroupPermissions: d.GetCgroupPermissions(),
+		}
+		if device.PathInContainer == "" {
+			device.PathInContainer = device.PathOnHost
+		}
+		if device.CgroupPermissions == "" {
+			device.CgroupPermissions = "rwm"
+		}
+		res.Devices = append(res.Devices, device)
+	}
+	return res
+}
+
+// mapHostMounts maps the mounts of a host config. A mount without a type is a bind
+// mount; bind mounts need an absolute host path, volume mounts a volume name or none
+// for an anonymous volume, and tmpfs mounts no source. Options of another mount type
+// are rejected.
+func mapHostMounts(mounts []*protos.Mount) ([]mount.Mount, error) {
+	var res []mount.Mount
+	for i, m := range mounts {
+		mapped, err := mapHostMount(m)
+		if err != nil {
+			return nil, fmt.Errorf("host_config.mounts[%d].%w", i, err)
+		}
+		res = append(res, mapped)
+	}
+	return res, nil
+}
+
+func mapHostMount(m *protos.Mount) (mount.Mount, error) {
+	if !path.IsAbs(m.GetTarget()) {
+		return mount.Mount{}, fmt.Errorf("target: %q is not an absolute path", m.GetTarget())
+	}
+	res := mount.Mount{Source: m.GetSource(), Target: m.GetTarget(), ReadOnly: m.GetReadOnly()}
+
+	var err error
+	switch m.GetType() {
+	case protos.MountType_MOUNT_TYPE_UNSPECIFIED, protos.MountType_MOUNT_TYPE_BIND:
+		err = validateBindMount(m)
+		res.Type = mount.TypeBind
+		res.BindOptions = mapBindOptions(m.GetBindOptions())
+	case protos.MountType_MOUNT_TYPE_VOLUME:
+		err = validateVolumeMount(m)
+		res.Type = mount.TypeVolume
+		res.VolumeOptions = mapVolumeOptions(m.GetVolumeOptions())
+	case protos.MountType_MOUNT_TYPE_TMPFS:
+		err = validateTmpfsMount(m)
+		res.Type = mount.TypeTmpfs
+		res.TmpfsOptions = mapTmpfsOptions(m.GetTmpfsOptions())
+	default:
+		err = fmt.Errorf("type: unsupported mount type %s", m.GetType())
+	}
+	if err != nil {
+		return mount.Mount{}, err
+	}
+	return res, nil
+}
+
+func validateBindMount(m *protos.Mount) error {
+	if m.GetVolumeOptions() != nil || m.GetTmpfsOptions() != nil || m.GetCreateVolume() {
+		return errors.New("type: bind mounts accept bind options only")
+	}
+	if !path.IsAbs(m.GetSource()) {
+		return fmt.Errorf("source: %q is not an absolute host path", m.GetSource())
+	}
+	propagation := mount.Propagation(m.GetBindOptions().GetPropagation())
+	if propagation != "" && !slices.Contains(mount.Propagations, propagation) {
+		return fmt.Errorf("bind_options.propagation: unknown propagation %q", propagation)
+	}
+	return nil
+}
+
+func validateVolumeMount(m *protos.Mount) error {
+	if m.GetBindOptions() != nil || m.GetTmpfsOptions() != nil {
+		return errors.New("type: volume mounts accept volume options only")
+	}
+	if src := m.GetSource(); src != "" && !volumeNamePattern.MatchString(src) {
+		return fmt.Errorf("source: %q is not a valid volume name", src)
+	}
+	if m.GetCreateVolume() && m.GetSource() == "" {
+		return errors.New("create_volume: requires a volume name in source")
+	}
+	return nil
+}
+
+func validateTmpfsMount(m *protos.Mount) error {
+	if m.GetSource() != "" {
+		return errors.New("source: must be empty for tmpfs mounts")
+	}
+	if m.GetBindOptions() != nil || m.GetVolumeOptions() != nil || m.GetCreateVolume() {
+		return errors.New("type: tmpfs mounts accept tmpfs options only")
+	}
+	if o := m.GetTmpfsOptions(); o.GetSizeBytes() < 0 {
+		return fmt.Errorf("tmpfs_options.size_bytes: %d must not be negative", o.GetSizeBytes())
+	}
+	if mode := m.GetTmpfsOptions().GetMode(); mode > 0o7777 {
+		return fmt.Errorf("tmpfs_options.mode: %#o is not a file mode", mode)
+	}
+	return nil
+}
+
+func mapBindOptions(o *protos.MountBindOptions) *mount.BindOptions {
+	if o == nil {
+		return nil
+	}
+	return &mount.BindOptions{
+		Propagation:      mount.Propagation(o.GetPropagation()),
+		CreateMountpoint: o.GetCreateMountpoint(),
+	}
+}
+
+func mapVolumeOptions(o *protos.MountVolumeOptions) *mount.VolumeOptions {
+	if o == nil {
+		return nil
+	}
+	res := &mount.VolumeOptions{NoCopy: o.GetNoCopy(), Labels: o.GetLabels(), Subpath: o.GetSubpath()}
+	if d := o.GetDriverConfig(); d != nil {
+		res.DriverConfig = &mount.Driver{Name: d.GetName(), Options: d.GetOptions()}
+	}
+	return res
+}
+
+func mapTmpfsOptions(o *protos.MountTmpfsOptions) *mount.TmpfsOptions {
+	if o == nil {
+		return nil
+	}
+	return &mount.TmpfsOptions{SizeBytes: o.GetSizeBytes(), Mode: os.FileMode(o.GetMode())}
+}
@@ -0,0 +1,279 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package container
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/whiteo/yadoma/internal/protos"
+)
+
+func TestValidateHostConfig(t *testing.T) {
+	pidsLimit := int64(-2)
+
+	tests := []struct {
+		name     string
+		req      *protos.HostConfig
+		errField string
+	}{
+		{
+			name: "valid limits",
+			req: &protos.HostConfig{
+				CpuPeriod:  100000,
+				CpuQuota:   50000,
+				Memory:     64 << 20,
+				MemorySwap: -1,
+				Ulimits:    []*protos.Ulimit{{Name: "core", Soft: -1, Hard: -1}},
+			},
+		},
+		{name: "negative cpu shares", req: &protos.HostConfig{CpuShares: -1}, errField: "host_config.cpu_shares"},
+		{name: "cpu period too short", req: &protos.HostConfig{CpuPeriod: 10}, errField: "host_config.cpu_period"},
+		{name: "cpu quota too short", req: &protos.HostConfig{CpuQuota: 10}, errField: "host_config.cpu_quota"},
+		{
+			name:     "nano cpus with quota",
+			req:      &protos.HostConfig{NanoCpus: 1000000000, CpuQuota: 50000},
+			errField: "host_config.nano_cpus",
+		},
+		{name: "invalid cpuset", req: &protos.HostConfig{CpusetCpus: "0-"}, errField: "host_config.cpuset_cpus"},
+		{name: "memory too small", req: &protos.HostConfig{Memory: 1024}, errField: "host_config.memory"},
+		{
+			name:     "reservation above memory",
+			req:      &protos.HostConfig{Memory: 64 << 20, MemoryReservation: 128 << 20},
+			errField: "host_config.memory_reservation",
+		},
+		{
+			name:     "swap below memory",
+			req:      &protos.HostConfig{Memory: 64 << 20, MemorySwap: 32 << 20},
+			errField: "host_config.memory_swap",
+		},
+		{name: "swap without memory", req: &protos.HostConfig{MemorySwap: 64 << 20}, errField: "host_config.memory_swap"},
+		{name: "invalid pids limit", req: &protos.HostConfig{PidsLimit: &pidsLimit}, errField: "host_config.pids_limit"},
+		{
+			name:     "unknown ulimit",
+			req:      &protos.HostConfig{Ulimits: []*protos.Ulimit{{Name: "files", Soft: 1, Hard: 1}}},
+			errField: "host_config.ulimits[0].name",
+		},
+		{
+			name: "duplicate ulimit",
+			req: &protos.HostConfig{Ulimits: []*protos.Ulimit{
+				{Name: "nofile", Soft: 1, Hard: 1}, {Name: "NOFILE", Soft: 2, Hard: 2},
+			}},
+			errField: "host_config.ulimits[1].name",
+		},
+		{
+			name:     "soft ulimit above hard",
+			req:      &protos.HostConfig{Ulimits: []*protos.Ulimit{{Name: "nofile", Soft: 2048, Hard: 1024}}},
+			errField: "host_config.ulimits[0].soft",
+		},
+		{
+			name:     "relative device path",
+			req:      &protos.HostConfig{Devices: []*protos.DeviceMapping{{PathOnHost: "dev/fuse"}}},
+			errField: "host_config.devices[0].path_on_host",
+		},
+		{
+			name: "invalid device permissions",
+			req: &protos.HostConfig{Devices: []*protos.DeviceMapping{
+				{PathOnHost: "/dev/fuse", CgroupPermissions: "rwx"},
+			}},
+			errField: "host_config.devices[0].cgroup_permissions",
+		},
+		{name: "unknown capability", req: &protos.HostConfig{CapDrop: []string{"FLY"}}, errField: "host_config.cap_drop[0]"},
+		{
+			name:     "unknown security option",
+			req:      &protos.HostConfig{SecurityOpt: []string{"selinux=off"}},
+			errField: "host_config.security_opt[0]",
+		},
+		{
+			name:     "security option without value",
+			req:      &protos.HostConfig{SecurityOpt: []string{"seccomp"}},
+			errField: "host_config.security_opt[0]",
+		},
+		{
+			name:     "invalid sysctl",
+			req:      &protos.HostConfig{Sysctls: map[string]string{"net core": "1"}},
+			errField: "host_config.sysctls",
+		},
+		{
+			name:     "relative tmpfs",
+			req:      &protos.HostConfig{Tmpfs: map[string]string{"run": ""}},
+			errField: "host_config.tmpfs",
+		},
+		{name: "invalid dns", req: &protos.HostConfig{Dns: []string{"dns.local"}}, errField: "host_config.dns[0]"},
+		{
+			name:     "extra host without address",
+			req:      &protos.HostConfig{ExtraHosts: []string{"db"}},
+			errField: "host_config.extra_hosts[0]",
+		},
+		{
+			name:     "extra host with invalid address",
+			req:      &protos.HostConfig{ExtraHosts: []string{"db=10.0.0"}},
+			errField: "host_config.extra_hosts[0]",
+		},
+		{
+			name:     "log options without driver",
+			req:      &protos.HostConfig{LogConfig: &protos.LogConfig{Config: map[string]string{"max-size": "1m"}}},
+			errField: "host_config.log_config.type",
+		},
+		{
+			name:     "unknown restart policy",
+			req:      &protos.HostConfig{RestartPolicy: &protos.RestartPolicy{Name: "sometimes"}},
+			errField: "host_config.restart_policy",
+		},
+		{
+			name:     "retry count without on-failure",
+			req:      &protos.HostConfig{RestartPolicy: &protos.RestartPolicy{Name: "always", MaximumRetryCount: 3}},
+			errField: "host_config.restart_policy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHostConfig(tt.req)
+			if tt.errField == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), tt.errField+":"), err.Error())
+		})
+	}
+}
+
+func TestMapHostMounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		mounts   []*protos.Mount
+		expected []mount.Mount
+		errField string
+	}{
+		{
+			name: "bind mount with propagation",
+			mounts: []*protos.Mount{{
+				Source:      "/srv/data",
+				Target:      "/data",
+				BindOptions: &protos.MountBindOptions{Propagation: "rshared", CreateMountpoint: true},
+			}},
+			expected: []mount.Mount{{
+				Type:        mount.TypeBind,
+				Source:      "/srv/data",
+				Target:      "/data",
+				BindOptions: &mount.BindOptions{Propagation: mount.PropagationRShared, CreateMountpoint: true},
+			}},
+		},
+		{
+			name: "named volume with options",
+			mounts: []*protos.Mount{{
+				Type:     protos.MountType_MOUNT_TYPE_VOLUME,
+				Source:   "cache",
+				Target:   "/cache",
+				ReadOnly: true,
+				VolumeOptions: &protos.MountVolumeOptions{
+					NoCopy:       true,
+					Labels:       map[string]string{"app": "web"},
+					Subpath:      "web",
+					DriverConfig: &protos.MountDriverConfig{Name: "local"},
+				},
+			}},
+			expected: []mount.Mount{{
+				Type:     mount.TypeVolume,
+				Source:   "cache",
+				Target:   "/cache",
+				ReadOnly: true,
+				VolumeOptions: &mount.VolumeOptions{
+					NoCopy:       true,
+					Labels:       map[string]string{"app": "web"},
+					Subpath:      "web",
+					DriverConfig: &mount.Driver{Name: "local"},
+				},
+			}},
+		},
+		{
+			name:     "anonymous volume",
+			mounts:   []*protos.Mount{{Type: protos.MountType_MOUNT_TYPE_VOLUME, Target: "/tmp/cache"}},
+			expected: []mount.Mount{{Type: mount.TypeVolume, Target: "/tmp/cache"}},
+		},
+		{
+			name: "tmpfs with size and mode",
+			mounts: []*protos.Mount{{
+				Type:         protos.MountType_MOUNT_TYPE_TMPFS,
+				Target:       "/run",
+				TmpfsOptions: &protos.MountTmpfsOptions{SizeBytes: 64 << 20, Mode: 0o1777},
+			}},
+			expected: []mount.Mount{{
+				Type:         mount.TypeTmpfs,
+				Target:       "/run",
+				TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 64 << 20, Mode: 0o1777},
+			}},
+		},
+		{
+			name:     "relative target",
+			mounts:   []*protos.Mount{{Source: "/srv", Target: "data"}},
+			errField: "host_config.mounts[0].target",
+		},
+		{
+			name:     "relative bind source",
+			mounts:   []*protos.Mount{{Source: "./data", Target: "/data"}},
+			errField: "host_config.mounts[0].source",
+		},
+		{
+			name: "unknown propagation",
+			mounts: []*protos.Mount{{
+				Source: "/srv", Target: "/srv", BindOptions: &protos.MountBindOptions{Propagation: "everywhere"},
+			}},
+			errField: "host_config.mounts[0].bind_options.propagation",
+		},
+		{
+			name: "bind mount with volume options",
+			mounts: []*protos.Mount{{
+				Source: "/srv", Target: "/srv", VolumeOptions: &protos.MountVolumeOptions{NoCopy: true},
+			}},
+			errField: "host_config.mounts[0].type",
+		},
+		{
+			name: "invalid volume name",
+			mounts: []*protos.Mount{
+				{Source: "/srv", Target: "/srv"},
+				{Type: protos.MountType_MOUNT_TYPE_VOLUME, Source: "/srv/data", Target: "/data"},
+			},
+			errField: "host_config.mounts[1].source",
+		},
+		{
+			name: "create anonymous volume",
+			mounts: []*protos.Mount{{
+				Type: protos.MountType_MOUNT_TYPE_VOLUME, Target: "/data", CreateVolume: true,
+			}},
+			errField: "host_config.mounts[0].create_volume",
+		},
+		{
+			name: "tmpfs with source",
+			mounts: []*protos.Mount{{
+				Type: protos.MountType_MOUNT_TYPE_TMPFS, Source: "tmp", Target: "/tmp",
+			}},
+			errField: "host_config.mounts[0].source",
+		},
+		{
+			name: "tmpfs with invalid mode",
+			mounts: []*protos.Mount{{
+				Type: protos.MountType_MOUNT_TYPE_TMPFS, Target: "/tmp", TmpfsOptions: &protos.MountTmpfsOptions{Mode: 0o17777},
+			}},
+			errField: "host_config.mounts[0].tmpfs_options.mode",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := mapHostMounts(tt.mounts)
+			if tt.errField != "" {
+				require.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tt.errField+":"), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
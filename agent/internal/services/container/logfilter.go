@@ -0,0 +1,225 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/whiteo/yadoma/internal/protos"
+)
+
+const (
+	maxLogPatternLength = 1024
+	// maxLogPatternInsts caps the compiled size of a pattern. Go regular expressions
+	// run in time linear to pattern size times input size, and lines are already
+	// bounded by the maximum line length, so this bounds the cost per line.
+	maxLogPatternInsts = 10000
+	maxLogContextLines = 100
+)
+
+var logLevels = map[string]string{
+	"trace":    "trace",
+	"debug":    "debug",
+	"info":     "info",
+	"warn":     "warn",
+	"warning":  "warn",
+	"error":    "error",
+	"err":      "error",
+	"fatal":    "fatal",
+	"critical": "fatal",
+	"panic":    "panic",
+}
+
+// logFilter selects log lines by include/exclude patterns and extracted level, and
+// surrounds every selected line with up to before/after context lines. It keeps
+// state across lines and must be used by a single goroutine.
+type logFilter struct {
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	levels  map[string]struct{}
+	before  int
+	after   int
+
+	history   []*protos.GetContainerLogsResponse
+	afterLeft int
+}
+
+func newLogFilter(req *protos.GetContainerLogsRequest) (*logFilter, error) {
+	include, err := compileLogPattern(req.GetInclude(), req.GetIgnoreCase())
+	if err != nil {
+		return nil, fmt.Errorf("invalid include pattern: %w", err)
+	}
+	exclude, err := compileLogPattern(req.GetExclude(), req.GetIgnoreCase())
+	if err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+
+	before, after := int(req.GetContextBefore()), int(req.GetContextAfter())
+	if before < 0 || before > maxLogContextLines || after < 0 || after > maxLogContextLines {
+		return nil, fmt.Errorf("context lines must be between 0 and %d", maxLogContextLines)
+	}
+
+	f := &logFilter{include: include, exclude: exclude, before: before, after: after}
+	for _, l := range req.GetLevels() {
+		level, ok := logLevels[strings.ToLower(l)]
+		if !ok {
+			return nil, fmt.Errorf("unknown log level %q", l)
+		}
+		if f.levels == nil {
+			f.levels = make(map[string]struct{})
+		}
+		f.levels[level] = struct{}{}
+	}
+	return f, nil
+}
+
+// clone returns a filter with the same selection and no line state, for use by
+// another goroutine.
+func (f *logFilter) clone() *logFilter {
+	return &logFilter{include: f.include, exclude: f.exclude, levels: f.levels, before: f.before, after: f.after}
+}
+
+// apply sends frame, together with any pending context lines, if it is selected.
+// Unselected lines are kept as context for a later match or dropped. The level of
+// frame is only extracted and filled in when the filter selects by level.
+func (f *logFilter) apply(
+	frame *protos.GetContainerLogsResponse,
+	send func(*protos.GetContainerLogsResponse) error,
+) error {
+	if f.levels != nil {
+		frame.Level = extractLogLevel(frame.GetLine())
+	}
+
+	if f.matches(frame) {
+		for _, h := range f.history {
+			h.Context = true
+			if err := send(h); err != nil {
+				return err
+			}
+		}
+		f.history = f.history[:0]
+		f.afterLeft = f.after
+		return send(frame)
+	}
+
+	if f.afterLeft > 0 {
+		f.afterLeft--
+		frame.Context = true
+		return send(frame)
+	}
+	if f.before > 0 {
+		if len(f.history) == f.before {
+			copy(f.history, f.history[1:])
+			f.history = f.history[:f.before-1]
+		}
+		f.history = append(f.history, frame)
+	}
+	return nil
+}
+
+func (f *logFilter) matches(frame *protos.GetContainerLogsResponse) bool {
+	if f.include != nil && !f.include.Match(frame.GetLine()) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.Match(frame.GetLine()) {
+		return false
+	}
+	if f.levels != nil {
+		if _, ok := f.levels[frame.GetLevel()]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compileLogPattern compiles a user supplied pattern after checking its length and
+// compiled size. An empty pattern yields nil, which disables that filter.
+func compileLogPattern(expr string, ignoreCase bool) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if len(expr) > maxLogPatternLength {
+		return nil, fmt.Errorf("pattern is longer than %d bytes", maxLogPatternLength)
+	}
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > maxLogPatternInsts {
+		return nil, errors.New("pattern is too complex")
+	}
+	return regexp.Compile(expr)
+}
+
+// extractLogLevel returns the normalized level of a JSON log line written by common
+// structured loggers: zerolog and logrus use a string "level" field, bunyan a numeric
+// one. A leading Docker timestamp is skipped. Other lines have no level.
+func extractLogLevel(line []byte) string {
+	line = bytes.TrimSpace(line)
+	if len(line) > 0 && line[0] != '{' {
+		if i := bytes.IndexByte(line, ' '); i >= 0 {
+			line = bytes.TrimSpace(line[i+1:])
+		}
+	}
+	if len(line) == 0 || line[0] != '{' {
+		return ""
+	}
+
+	var v struct {
+		Level json.RawMessage `json:"level"`
+	}
+	if err := json.Unmarshal(line, &v); err != nil || len(v.Level) == 0 {
+		return ""
+	}
+
+	var name string
+	if err := json.Unmarshal(v.Level, &name); err == nil {
+		return logLevels[strings.ToLower(name)]
+	}
+	var n int
+	if err := json.Unmarshal(v.Level, &n); err == nil {
+		return bunyanLevel(n)
+	}
+	return ""
+}
+
+func bunyanLevel(n int) string {
+	switch {
+	case n >= 60:
+		return "fatal"
+	case n >= 50:
+		return "error"
+	case n >= 40:
+		return "warn"
+	case n >= 30:
+		return "info"
+	case n >= 20:
+		return "debug"
+	case n >= 10:
+		return "trace"
+	default:
+		return ""
+	}
+}
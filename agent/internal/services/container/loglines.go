@@ -0,0 +1,208 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+)
+
+const (
+	defaultMaxLogLine = 16 * 1024
+	maxLogLineLimit   = 1024 * 1024
+	logIdleFlush      = 250 * time.Millisecond
+	// logTimestampScan bounds how far a line is searched for Docker's timestamp prefix.
+	logTimestampScan = len(time.RFC3339Nano) + 8
+)
+
+// logLine is one line of container output. Partial is set when the line was cut at
+// the maximum length or flushed while idle; the rest of it follows in later lines.
+type logLine struct {
+	stream    protos.LogStream
+	timestamp int64
+	text      []byte
+	partial   bool
+}
+
+// logSplitter turns Docker log output into lines. Docker is asked for timestamps, so
+// every log message starts with an RFC3339Nano timestamp and a space; the timestamp is
+// parsed into the line and, unless keepTimestamps is set, removed from its text.
+// Writers for stdout and stderr may be used from one goroutine while idle flushes run
+// on timers; emit is always called under the splitter lock, so it may write to a gRPC
+// stream. The first emit error is returned by every later Write.
+type logSplitter struct {
+	mu             sync.Mutex
+	emit           func(logLine) error
+	maxLen         int
+	idle           time.Duration
+	keepTimestamps bool
+	buffers        map[protos.LogStream]*lineBuffer
+	err            error
+}
+
+type lineBuffer struct {
+	stream protos.LogStream
+	buf    []byte
+	ts     int64
+	// headerDone is set once the timestamp of the buffered line has been consumed.
+	headerDone bool
+	timer      *time.Timer
+}
+
+func newLogSplitter(maxLen int, idle time.Duration, keepTimestamps bool, emit func(logLine) error) *logSplitter {
+	return &logSplitter{
+		emit:           emit,
+		maxLen:         maxLen,
+		idle:           idle,
+		keepTimestamps: keepTimestamps,
+		buffers:        make(map[protos.LogStream]*lineBuffer),
+	}
+}
+
+// writer returns an io.Writer that splits data of the given stream into lines.
+func (s *logSplitter) writer(stream protos.LogStream) io.Writer {
+	return &splitterWriter{s: s, stream: stream}
+}
+
+type splitterWriter struct {
+	s      *logSplitter
+	stream protos.LogStream
+}
+
+func (w *splitterWriter) Write(p []byte) (int, error) {
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	n := len(p)
+	b := s.buffers[w.stream]
+	if b == nil {
+		b = &lineBuffer{stream: w.stream}
+		s.buffers[w.stream] = b
+	}
+
+	for len(p) > 0 && s.err == nil {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			b.buf = append(b.buf, p...)
+			p = nil
+		} else {
+			b.buf = append(b.buf, p[:i]...)
+			p = p[i+1:]
+		}
+		s.parseHeader(b, i >= 0)
+
+		for s.err == nil && b.headerDone && len(b.buf) > s.maxLen {
+			s.send(b, b.buf[:s.maxLen], true)
+			b.buf = append(b.buf[:0], b.buf[s.maxLen:]...)
+		}
+		if i >= 0 && s.err == nil {
+			s.send(b, b.buf, false)
+			b.buf = b.buf[:0]
+			b.headerDone = false
+		}
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	s.armIdleFlush(b)
+	return n, nil
+}
+
+// parseHeader consumes the timestamp prefix of a new line once it is complete.
+// A line without a parsable timestamp keeps the previous line's timestamp.
+func (s *logSplitter) parseHeader(b *lineBuffer, eol bool) {
+	if b.headerDone {
+		return
+	}
+	sp := bytes.IndexByte(b.buf, ' ')
+	if sp < 0 && !eol && len(b.buf) < logTimestampScan {
+		return
+	}
+	b.headerDone = true
+	if sp < 0 || sp > logTimestampScan {
+		return
+	}
+	ts, err := time.Parse(time.RFC3339Nano, string(b.buf[:sp]))
+	if err != nil {
+		return
+	}
+	b.ts = ts.UnixNano()
+	if !s.keepTimestamps {
+		b.buf = append(b.buf[:0], b.buf[sp+1:]...)
+	}
+}
+
+func (s *logSplitter) send(b *lineBuffer, text []byte, partial bool) {
+	line := logLine{
+		stream:    b.stream,
+		timestamp: b.ts,
+		text:      bytes.Clone(text),
+		partial:   partial,
+	}
+	if err := s.emit(line); err != nil {
+		s.err = err
+	}
+}
+
+func (s *logSplitter) armIdleFlush(b *lineBuffer) {
+	if len(b.buf) == 0 || s.idle <= 0 {
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		return
+	}
+	if b.timer != nil {
+		b.timer.Reset(s.idle)
+		return
+	}
+	b.timer = time.AfterFunc(s.idle, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.err != nil || len(b.buf) == 0 {
+			return
+		}
+		if s.parseHeader(b, true); len(b.buf) > 0 {
+			s.send(b, b.buf, true)
+		}
+		b.buf = b.buf[:0]
+	})
+}
+
+// Close stops idle flushing and emits every buffered line. Output that ends without
+// a newline is emitted as a complete line. It returns the first emit error, if any.
+func (s *logSplitter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stream := range []protos.LogStream{protos.LogStream_LOG_STREAM_STDOUT, protos.LogStream_LOG_STREAM_STDERR} {
+		b := s.buffers[stream]
+		if b == nil {
+			continue
+		}
+		if b.timer != nil {
+			b.timer.Stop()
+		}
+		if s.err == nil && len(b.buf) > 0 {
+			s.parseHeader(b, true)
+			s.send(b, b.buf, false)
+			b.buf = b.buf[:0]
+		}
+	}
+	return s.err
+}
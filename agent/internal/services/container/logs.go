@@ -11,10 +11,11 @@
 package container
 
 import (
+	"context"
+	"errors"
 	"io"
 
 	"github.com/whiteo/yadoma/internal/protos"
-	service "github.com/whiteo/yadoma/internal/services"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/pkg/stdcopy"
@@ -24,12 +25,24 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// GetContainerLogs streams logs of a Docker container to the gRPC client.
-// It validates the request, constructs Docker `container.LogsOptions` (with ShowStdout and ShowStderr enabled),
-// and acquires a log reader from the Docker layer using the incoming context for cancellation.
-// Docker's multiplexed log stream (8-byte headers) is demultiplexed using stdcopy.StdCopy before streaming.
-// Log data is forwarded to the client as chunked `GetContainerLogsResponse` messages until EOF or context cancellation.
-// Returns `InvalidArgument` for an empty container ID and `Internal` for failures obtaining or streaming logs.
+// GetContainerLogs streams logs of a Docker container to the gRPC client line by line.
+// It validates the request and builds Docker `container.LogsOptions` from it: Follow, Tail
+// (number of most recent lines; unset means all), Since/Until (Unix nanoseconds; zero means
+// unbounded), and the selected stream (stdout only, stderr only, or both by default).
+// A log reader is acquired from the Docker layer using the incoming context for cancellation.
+// Docker's multiplexed log stream (8-byte headers) is demultiplexed using stdcopy.StdCopy, and
+// each stream is split into lines. Every `GetContainerLogsResponse` carries one line without its
+// newline, the stream it came from, the parsed Docker timestamp, and a sequence number starting at 1.
+// The timestamp prefix stays in the line text only if Timestamps is requested. Lines longer than
+// MaxLineLength (16 KiB by default) and lines left incomplete while the container is idle are sent
+// in parts marked as partial.
+// Lines are then filtered in the agent: Include and Exclude are regular expressions (optionally
+// case-insensitive), Levels keeps lines whose level, extracted from JSON logs, is one of the given
+// ones, and ContextBefore/ContextAfter add surrounding lines marked as context. Sequence numbers
+// count all lines, so gaps show where lines were filtered out.
+// Returns `InvalidArgument` for an empty container ID, a negative tail, a negative Since or Until,
+// an unknown stream, an out of range max line length, Until before Since, or an invalid filter,
+// and `Internal` for failures obtaining logs.
 func (s *Service) GetContainerLogs(
 	req *protos.GetContainerLogsRequest,
 	stream protos.ContainerService_GetContainerLogsServer,
@@ -37,20 +50,57 @@ func (s *Service) GetContainerLogs(
 	if req.GetId() == "" {
 		return status.Error(codes.InvalidArgument, "container ID is required")
 	}
+	filter, err := validateLogsRequest(req)
+	if err != nil {
+		return err
+	}
 
 	inspectJSON, err := s.layer.GetContainerDetails(stream.Context(), req.GetId())
 	if err != nil {
 		return status.Errorf(codes.Internal, "cannot inspect container: %v", err)
 	}
 
-	opts := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Follow:     req.GetFollow(),
-		Timestamps: false,
+	return s.streamLogs(stream.Context(), req.GetId(), inspectJSON.Config.Tty, req, filter, mapLogsOptions(req), stream.Send)
+}
+
+// validateLogsRequest checks the log options of req and returns the line filter they
+// describe; the container ID is not checked.
+func validateLogsRequest(req *protos.GetContainerLogsRequest) (*logFilter, error) {
+	if req.Tail != nil && req.GetTail() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "tail must not be negative")
+	}
+	if req.GetSinceNano() < 0 || req.GetUntilNano() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "since and until must not be negative")
+	}
+	if req.GetUntilNano() != 0 && req.GetUntilNano() < req.GetSinceNano() {
+		return nil, status.Error(codes.InvalidArgument, "until must not be before since")
 	}
+	if _, ok := protos.LogStream_name[int32(req.GetStream())]; !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown log stream %d", req.GetStream())
+	}
+	if req.GetMaxLineLength() < 0 || req.GetMaxLineLength() > maxLogLineLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "max line length must be between 0 and %d", maxLogLineLimit)
+	}
+	filter, err := newLogFilter(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return filter, nil
+}
 
-	logsReader, err := s.layer.GetContainerLogs(stream.Context(), req.GetId(), opts)
+// streamLogs reads the logs of container id with opts, splits them as described by
+// req, and passes every line selected by filter to send. It returns when the log
+// stream ends, ctx is canceled, or send fails.
+func (s *Service) streamLogs(
+	ctx context.Context,
+	id string,
+	tty bool,
+	req *protos.GetContainerLogsRequest,
+	filter *logFilter,
+	opts container.LogsOptions,
+	send func(*protos.GetContainerLogsResponse) error,
+) error {
+	logsReader, err := s.layer.GetContainerLogs(ctx, id, opts)
 	if err != nil {
 		return status.Errorf(codes.Internal, "cannot get container logs: %v", err)
 	}
@@ -60,26 +110,41 @@ func (s *Service) GetContainerLogs(
 		}
 	}()
 
-	if inspectJSON.Config.Tty {
-		log.Debug().Str("container", req.GetId()).Msg("Container has TTY, streaming logs directly")
-		return service.StreamReader(logsReader, func(chunk []byte) error {
-			return stream.Send(&protos.GetContainerLogsResponse{Chunk: chunk})
-		})
-	}
-
-	log.Debug().Str("container", req.GetId()).Msg("Container has no TTY, demultiplexing logs")
-	pipeReader, pipeWriter := io.Pipe()
-	defer pipeReader.Close()
+	var seq uint64
+	splitter := newLogSplitter(maxLineLength(req), logIdleFlush, req.GetTimestamps(), func(l logLine) error {
+		seq++
+		return filter.apply(&protos.GetContainerLogsResponse{
+			Line:          l.text,
+			Stream:        l.stream,
+			TimestampNano: l.timestamp,
+			Seq:           seq,
+			Partial:       l.partial,
+		}, send)
+	})
 
-	go func() {
-		defer pipeWriter.Close()
-		_, err = stdcopy.StdCopy(pipeWriter, pipeWriter, logsReader)
-		if err != nil && err != io.EOF {
-			log.Error().Err(err).Msg("error demultiplexing logs")
-		}
-	}()
+	if tty {
+		log.Debug().Str("container", id).Msg("Container has TTY, streaming logs directly")
+		_, err = io.Copy(splitter.writer(protos.LogStream_LOG_STREAM_STDOUT), logsReader)
+	} else {
+		log.Debug().Str("container", id).Msg("Container has no TTY, demultiplexing logs")
+		_, err = stdcopy.StdCopy(
+			splitter.writer(protos.LogStream_LOG_STREAM_STDOUT),
+			splitter.writer(protos.LogStream_LOG_STREAM_STDERR),
+			logsReader,
+		)
+	}
+	if cErr := splitter.Close(); err == nil {
+		err = cErr
+	}
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
 
-	return service.StreamReader(pipeReader, func(chunk []byte) error {
-		return stream.Send(&protos.GetContainerLogsResponse{Chunk: chunk})
-	})
+func maxLineLength(req *protos.GetContainerLogsRequest) int {
+	if req.GetMaxLineLength() > 0 {
+		return int(req.GetMaxLineLength())
+	}
+	return defaultMaxLogLine
 }
@@ -11,13 +11,25 @@
 package container
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net"
+	"net/netip"
+	"path"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/whiteo/yadoma/internal/metrics"
 	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
 
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/go-connections/nat"
 )
@@ -29,6 +41,110 @@ func extractStatus(state *container.State) string {
 	return state.Status
 }
 
+// secretEnvMarkers are the words in environment variable names whose values are masked
+// in container details. A marker matches a whole _ separated part of a name, or the last
+// word of a camel case name such as apiKey.
+var secretEnvMarkers = []string{"PASSWORD", "PASSWD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "AUTH"}
+
+// maskedValue replaces the values of secret environment variables.
+const maskedValue = "********"
+
+func mapState(state *container.State) *protos.ContainerState {
+	if state == nil {
+		return nil
+	}
+	res := &protos.ContainerState{
+		Status:     state.Status,
+		Running:    state.Running,
+		Paused:     state.Paused,
+		Restarting: state.Restarting,
+		OomKilled:  state.OOMKilled,
+		Dead:       state.Dead,
+		Pid:        clampToInt32(state.Pid),
+		ExitCode:   clampToInt32(state.ExitCode),
+		Error:      state.Error,
+		StartedAt:  state.StartedAt,
+		FinishedAt: state.FinishedAt,
+	}
+	res.Health, res.LastHealthCheck = mapHealth(state.Health)
+	if state.Health != nil {
+		res.FailingStreak = clampToInt32(state.Health.FailingStreak)
+	}
+	return res
+}
+
+// mapHealth maps the health status of a container and the result of its last check.
+func mapHealth(h *container.Health) (string, *protos.HealthcheckResult) {
+	if h == nil {
+		return "", nil
+	}
+	if len(h.Log) == 0 || h.Log[len(h.Log)-1] == nil {
+		return h.Status, nil
+	}
+	last := h.Log[len(h.Log)-1]
+	return h.Status, &protos.HealthcheckResult{
+		StartNano: last.Start.UnixNano(),
+		EndNano:   last.End.UnixNano(),
+		ExitCode:  clampToInt32(last.ExitCode),
+		Output:    last.Output,
+	}
+}
+
+// maskEnv masks the values of environment variables whose names suggest a secret, such
+// as DB_PASSWORD or API_TOKEN, so container details do not leak credentials. Names that
+// only contain a marker inside a word, such as KEYBOARD_LAYOUT, AUTHOR or MONKEY, are kept.
+func maskEnv(env []string) []string {
+	if env == nil {
+		return nil
+	}
+	res := make([]string, 0, len(env))
+	for _, e := range env {
+		name, value, ok := strings.Cut(e, "=")
+		if ok && value != "" && isSecretEnv(name) {
+			e = name + "=" + maskedValue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+func isSecretEnv(name string) bool {
+	parts := strings.Split(strings.ToUpper(name), "_")
+	return slices.ContainsFunc(secretEnvMarkers, func(marker string) bool {
+		if slices.Contains(parts, marker) {
+			return true
+		}
+		i := len(name) - len(marker)
+		return i > 0 && strings.EqualFold(name[i:], marker) && startsWord(name, i)
+	})
+}
+
+// startsWord reports whether a camel case word starts at byte i of name, as in apiKey.
+func startsWord(name string, i int) bool {
+	prev, cur := rune(name[i-1]), rune(name[i])
+	return unicode.IsUpper(cur) && (unicode.IsLower(prev) || unicode.IsDigit(prev))
+}
+
+func mapResourceLimits(res container.Resources) *protos.ResourceLimits {
+	limits := &protos.ResourceLimits{
+		CpuShares:         res.CPUShares,
+		CpuPeriod:         res.CPUPeriod,
+		CpuQuota:          res.CPUQuota,
+		NanoCpus:          res.NanoCPUs,
+		CpusetCpus:        res.CpusetCpus,
+		CpusetMems:        res.CpusetMems,
+		Memory:            res.Memory,
+		MemoryReservation: res.MemoryReservation,
+		MemorySwap:        res.MemorySwap,
+		BlkioWeight:       uint32(res.BlkioWeight),
+	}
+	if res.PidsLimit != nil {
+		limit := *res.PidsLimit
+		limits.PidsLimit = &limit
+	}
+	return limits
+}
+
 func mapMounts(mounts []container.MountPoint) []*protos.MountPoint {
 	res := make([]*protos.MountPoint, 0, len(mounts))
 	for _, m := range mounts {
@@ -62,76 +178,590 @@ func mapStats(stats container.StatsResponse) *protos.GetContainerStatsResponse {
 		rxTotal += netStats.RxBytes
 		txTotal += netStats.TxBytes
 	}
+	blkRead, blkWrite := metrics.BlockIO(stats.BlkioStats)
+	memUsed := metrics.MemWorkingSet(stats.MemoryStats)
+
+	var memPercent float64
+	if stats.MemoryStats.Limit != 0 {
+		memPercent = float64(memUsed) / float64(stats.MemoryStats.Limit) * 100
+	}
 
 	return &protos.GetContainerStatsResponse{
-		Id:        stats.ID,
-		CpuUsage:  stats.CPUStats.CPUUsage.TotalUsage,
-		MemUsage:  stats.MemoryStats.Usage,
-		MemLimit:  stats.MemoryStats.Limit,
-		NetInput:  rxTotal,
-		NetOutput: txTotal,
+		Id:            stats.ID,
+		Name:          strings.TrimPrefix(stats.Name, "/"),
+		CpuUsage:      stats.CPUStats.CPUUsage.TotalUsage,
+		MemUsage:      stats.MemoryStats.Usage,
+		MemLimit:      stats.MemoryStats.Limit,
+		NetInput:      rxTotal,
+		NetOutput:     txTotal,
+		CpuPercent:    metrics.CPUPercent(stats.CPUStats, stats.PreCPUStats),
+		OnlineCpus:    metrics.OnlineCPUs(stats.CPUStats),
+		MemWorkingSet: memUsed,
+		MemPercent:    memPercent,
+		BlockRead:     blkRead,
+		BlockWrite:    blkWrite,
+		Pids:          stats.PidsStats.Current,
+		Networks:      mapNetworkStats(stats.Networks),
 	}
 }
 
-func mapConfig(req *protos.CreateContainerRequest) *container.Config {
-	return &container.Config{
-		Image: req.GetImage(),
-		Cmd:   req.GetCmd(),
-		Env:   req.GetEnv(),
+func mapNetworkStats(nets map[string]container.NetworkStats) []*protos.NetworkInterfaceStats {
+	res := make([]*protos.NetworkInterfaceStats, 0, len(nets))
+	for _, name := range slices.Sorted(maps.Keys(nets)) {
+		n := nets[name]
+		res = append(res, &protos.NetworkInterfaceStats{
+			Name:      name,
+			RxBytes:   n.RxBytes,
+			RxPackets: n.RxPackets,
+			RxErrors:  n.RxErrors,
+			RxDropped: n.RxDropped,
+			TxBytes:   n.TxBytes,
+			TxPackets: n.TxPackets,
+			TxErrors:  n.TxErrors,
+			TxDropped: n.TxDropped,
+		})
 	}
+	return res
 }
 
-func mapHostConfig(h *protos.HostConfig) *container.HostConfig {
-	if h == nil {
-		return &container.HostConfig{}
+func mapHistorySample(stats *protos.GetContainerStatsResponse) metrics.Sample {
+	return metrics.Sample{
+		CPUPercent: stats.GetCpuPercent(),
+		MemUsage:   stats.GetMemWorkingSet(),
+		MemLimit:   stats.GetMemLimit(),
+		NetRx:      stats.GetNetInput(),
+		NetTx:      stats.GetNetOutput(),
+		BlockRead:  stats.GetBlockRead(),
+		BlockWrite: stats.GetBlockWrite(),
+		Pids:       stats.GetPids(),
+	}
+}
+
+func mapStatsSeries(se metrics.Series) *protos.StatsSeries {
+	points := make([]*protos.StatsPoint, 0, len(se.Points))
+	for _, p := range se.Points {
+		points = append(points, &protos.StatsPoint{
+			TimestampNano: p.Time.UnixNano(),
+			CpuPercent:    p.CPUPercent,
+			CpuPercentMax: p.CPUPercentMax,
+			MemUsage:      p.MemUsage,
+			MemUsageMax:   p.MemUsageMax,
+			MemLimit:      p.MemLimit,
+			NetInput:      p.NetRx,
+			NetOutput:     p.NetTx,
+			BlockRead:     p.BlockRead,
+			BlockWrite:    p.BlockWrite,
+			Pids:          p.Pids,
+			Samples:       p.Samples,
+		})
+	}
+	return &protos.StatsSeries{
+		ContainerId:   se.ID,
+		ContainerName: se.Name,
+		ResolutionMs:  se.Resolution.Milliseconds(),
+		Points:        points,
+	}
+}
+
+// hostnamePattern matches an RFC 1123 host name made of dot separated labels.
+var hostnamePattern = regexp.MustCompile(
+	`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// signalNames are the signal names accepted as stop signal, without the SIG prefix.
+var signalNames = map[string]struct{}{
+	"ABRT": {}, "ALRM": {}, "BUS": {}, "CHLD": {}, "CONT": {}, "FPE": {}, "HUP": {}, "ILL": {},
+	"INT": {}, "IO": {}, "IOT": {}, "KILL": {}, "PIPE": {}, "POLL": {}, "PROF": {}, "PWR": {},
+	"QUIT": {}, "SEGV": {}, "STKFLT": {}, "STOP": {}, "SYS": {}, "TERM": {}, "TRAP": {}, "TSTP": {},
+	"TTIN": {}, "TTOU": {}, "URG": {}, "USR1": {}, "USR2": {}, "VTALRM": {}, "WINCH": {}, "XCPU": {},
+	"XFSZ": {},
+}
+
+// mapConfig maps the container settings of a create request.
+// It returns an error naming the request field of the first invalid value.
+func mapConfig(req *protos.CreateContainerRequest) (*container.Config, error) {
+	if err := validateConfig(req); err != nil {
+		return nil, err
+	}
+	exposed, err := mapExposedPorts(req.GetExposedPorts())
+	if err != nil {
+		return nil, err
+	}
+
+	config := &container.Config{
+		Image:        req.GetImage(),
+		Cmd:          req.GetCmd(),
+		Env:          req.GetEnv(),
+		Entrypoint:   req.GetEntrypoint(),
+		WorkingDir:   req.GetWorkingDir(),
+		User:         req.GetUser(),
+		Hostname:     req.GetHostname(),
+		Domainname:   req.GetDomainname(),
+		Labels:       req.GetLabels(),
+		ExposedPorts: exposed,
+		StopSignal:   req.GetStopSignal(),
+		Healthcheck:  mapHealthcheck(req.GetHealthcheck()),
+		Tty:          req.GetTty(),
+		OpenStdin:    req.GetOpenStdin(),
+		StdinOnce:    req.GetStdinOnce(),
 	}
+	if req.StopTimeout != nil {
+		timeout := int(req.GetStopTimeout())
+		config.StopTimeout = &timeout
+	}
+	return config, nil
+}
 
-	portBindings := nat.PortMap{}
-	for _, binding := range h.GetPortBindings() {
-		for _, m := range binding.GetHostPorts() {
-			port := nat.Port(fmt.Sprintf("%d/tcp", m.ContainerPort))
-			hostBinding := nat.PortBinding{
-				HostIP:   m.GetHostIp(),
-				HostPort: strconv.Itoa(int(m.GetHostPort())),
+func validateConfig(req *protos.CreateContainerRequest) error {
+	for i, e := range req.GetEnv() {
+		if name, _, _ := strings.Cut(e, "="); name == "" {
+			return fmt.Errorf("env[%d]: variable name is required in %q", i, e)
+		}
+	}
+	if dir := req.GetWorkingDir(); dir != "" && !path.IsAbs(dir) {
+		return fmt.Errorf("working_dir: %q is not an absolute path", dir)
+	}
+	if h := req.GetHostname(); h != "" && (len(h) > 253 || !hostnamePattern.MatchString(h)) {
+		return fmt.Errorf("hostname: %q is not a valid host name", h)
+	}
+	if d := req.GetDomainname(); d != "" && (len(d) > 253 || !hostnamePattern.MatchString(d)) {
+		return fmt.Errorf("domainname: %q is not a valid domain name", d)
+	}
+	if _, ok := req.GetLabels()[""]; ok {
+		return errors.New("labels: label key is required")
+	}
+	if sig := req.GetStopSignal(); sig != "" && !validSignal(sig) {
+		return fmt.Errorf("stop_signal: unknown signal %q", sig)
+	}
+	if req.GetStopTimeout() < -1 {
+		return fmt.Errorf("stop_timeout: %d is invalid, use -1 to wait without limit", req.GetStopTimeout())
+	}
+	return validateHealthcheck(req.GetHealthcheck())
+}
+
+// validSignal reports whether sig is a signal number or a signal name with or
+// without the SIG prefix, such as "15", "SIGTERM" or "term".
+func validSignal(sig string) bool {
+	if n, err := strconv.Atoi(sig); err == nil {
+		return n > 0 && n <= 64
+	}
+	name := strings.TrimPrefix(strings.ToUpper(sig), "SIG")
+	if n, ok := strings.CutPrefix(name, "RTMIN+"); ok {
+		offset, err := strconv.Atoi(n)
+		return err == nil && offset >= 0 && offset <= 30
+	}
+	_, ok := signalNames[name]
+	return ok
+}
+
+func mapExposedPorts(specs []string) (nat.PortSet, error) {
+	var set nat.PortSet
+	for i, spec := range specs {
+		ports, err := parsePorts(spec)
+		if err != nil {
+			return nil, fmt.Errorf("exposed_ports[%d]: %w", i, err)
+		}
+		if set == nil {
+			set = nat.PortSet{}
+		}
+		for _, p := range ports {
+			set[p] = struct{}{}
+		}
+	}
+	return set, nil
+}
+
+// parsePorts parses a container port or port range with an optional protocol,
+// such as "80", "53/udp" or "8000-8010/tcp", into the single ports it covers.
+// The protocol defaults to tcp.
+func parsePorts(spec string) ([]nat.Port, error) {
+	proto, rng := nat.SplitProtoPort(spec)
+	proto = strings.ToLower(proto)
+	switch proto {
+	case "tcp", "udp", "sctp":
+	default:
+		return nil, fmt.Errorf("unsupported protocol %q in %q, use tcp, udp or sctp", proto, spec)
+	}
+	start, end, err := nat.ParsePortRangeToInt(rng)
+	if err != nil || start == 0 {
+		return nil, fmt.Errorf("invalid port %q, use a port or range from 1 to 65535", spec)
+	}
+
+	ports := make([]nat.Port, 0, end-start+1)
+	for p := start; p <= end; p++ {
+		ports = append(ports, nat.Port(strconv.Itoa(p)+"/"+proto))
+	}
+	return ports, nil
+}
+
+// validateHealthcheck checks a healthcheck. The test must be empty to inherit the
+// image healthcheck, NONE to disable it, or start with CMD or CMD-SHELL.
+func validateHealthcheck(h *protos.Healthcheck) error {
+	if test := h.GetTest(); len(test) > 0 {
+		switch test[0] {
+		case "NONE":
+		case "CMD", "CMD-SHELL":
+			if len(test) < 2 {
+				return fmt.Errorf("healthcheck.test: %s requires a command", test[0])
 			}
-			portBindings[port] = append(portBindings[port], hostBinding)
+		default:
+			return fmt.Errorf("healthcheck.test: must start with NONE, CMD or CMD-SHELL, got %q", test[0])
 		}
 	}
+	for _, d := range []struct {
+		field string
+		ms    int64
+	}{
+		{"interval_ms", h.GetIntervalMs()},
+		{"timeout_ms", h.GetTimeoutMs()},
+		{"start_period_ms", h.GetStartPeriodMs()},
+		{"start_interval_ms", h.GetStartIntervalMs()},
+	} {
+		if d.ms < 0 {
+			return fmt.Errorf("healthcheck.%s: %d must not be negative", d.field, d.ms)
+		}
+	}
+	if h.GetRetries() < 0 {
+		return fmt.Errorf("healthcheck.retries: %d must not be negative", h.GetRetries())
+	}
+	return nil
+}
 
-	var mounts []mount.Mount
-	for _, m := range h.GetMounts() {
-		mounts = append(mounts, mount.Mount{
-			Source:   m.GetSource(),
-			Target:   m.GetTarget(),
-			ReadOnly: m.GetReadOnly(),
-			Type:     mount.TypeBind,
-		})
+func mapHealthcheck(h *protos.Healthcheck) *container.HealthConfig {
+	if h == nil {
+		return nil
+	}
+	return &container.HealthConfig{
+		Test:          h.GetTest(),
+		Interval:      time.Duration(h.GetIntervalMs()) * time.Millisecond,
+		Timeout:       time.Duration(h.GetTimeoutMs()) * time.Millisecond,
+		StartPeriod:   time.Duration(h.GetStartPeriodMs()) * time.Millisecond,
+		StartInterval: time.Duration(h.GetStartIntervalMs()) * time.Millisecond,
+		Retries:       int(h.GetRetries()),
+	}
+}
+
+// mapHostConfig maps the host settings of a create request.
+// It returns an error naming the request field of the first invalid value.
+func mapHostConfig(h *protos.HostConfig) (*container.HostConfig, error) {
+	if h == nil {
+		return &container.HostConfig{}, nil
+	}
+	if err := validateHostConfig(h); err != nil {
+		return nil, err
+	}
+
+	portBindings, err := mapPortBindings(h.GetPortBindings())
+	if err != nil {
+		return nil, fmt.Errorf("host_config.%w", err)
+	}
+
+	mounts, err := mapHostMounts(h.GetMounts())
+	if err != nil {
+		return nil, err
 	}
 
-	return &container.HostConfig{
+	hostConfig := &container.HostConfig{
 		PortBindings: portBindings,
 		AutoRemove:   h.GetAutoRemove(),
 		RestartPolicy: container.RestartPolicy{
 			Name:              container.RestartPolicyMode(h.GetRestartPolicy().GetName()),
 			MaximumRetryCount: int(h.GetRestartPolicy().GetMaximumRetryCount()),
 		},
-		Mounts: mounts,
+		Mounts:         mounts,
+		Resources:      mapResources(h),
+		CapAdd:         mapCapabilities(h.GetCapAdd()),
+		CapDrop:        mapCapabilities(h.GetCapDrop()),
+		Privileged:     h.GetPrivileged(),
+		ReadonlyRootfs: h.GetReadonlyRootfs(),
+		SecurityOpt:    h.GetSecurityOpt(),
+		Sysctls:        h.GetSysctls(),
+		Tmpfs:          h.GetTmpfs(),
+		DNS:            h.GetDns(),
+		DNSSearch:      h.GetDnsSearch(),
+		DNSOptions:     h.GetDnsOptions(),
+		ExtraHosts:     h.GetExtraHosts(),
+		LogConfig: container.LogConfig{
+			Type:   h.GetLogConfig().GetType(),
+			Config: h.GetLogConfig().GetConfig(),
+		},
+		ShmSize: h.GetShmSize(),
+	}
+	if h.Init != nil {
+		useInit := h.GetInit()
+		hostConfig.Init = &useInit
 	}
+	return hostConfig, nil
 }
 
-func mapNetworking(networks []string) *network.NetworkingConfig {
-	if len(networks) == 0 {
+// validateRestartPolicy checks a restart policy the way the daemon does, so that an
+// invalid policy is reported with its field. A nil policy is valid.
+func validateRestartPolicy(p *protos.RestartPolicy) error {
+	if p == nil {
 		return nil
 	}
+	err := container.ValidateRestartPolicy(container.RestartPolicy{
+		Name:              container.RestartPolicyMode(p.GetName()),
+		MaximumRetryCount: int(p.GetMaximumRetryCount()),
+	})
+	if err != nil {
+		return fmt.Errorf("restart_policy: %w", err)
+	}
+	return nil
+}
 
-	endpoints := make(map[string]*network.EndpointSettings, len(networks))
-	for _, n := range networks {
-		endpoints[n] = &network.EndpointSettings{}
+// mapPortBindings maps the published ports of a host config. Each mapping binds a
+// container port or range of the given protocol, tcp by default, to a host port or
+// range; a zero host port lets Docker allocate an ephemeral port when the container
+// starts, and a host range for a single container port lets Docker pick a free port
+// from it.
+func mapPortBindings(bindings map[string]*protos.PortBinding) (nat.PortMap, error) {
+	res := nat.PortMap{}
+	for _, key := range slices.Sorted(maps.Keys(bindings)) {
+		for i, m := range bindings[key].GetHostPorts() {
+			mapped, err := mapPortMapping(m)
+			if err != nil {
+				return nil, fmt.Errorf("port_bindings[%s].host_ports[%d].%w", key, i, err)
+			}
+			for port, b := range mapped {
+				res[port] = append(res[port], b...)
+			}
+		}
+	}
+	return res, nil
+}
+
+func mapPortMapping(m *protos.PortMapping) (nat.PortMap, error) {
+	proto := strings.ToLower(m.GetProtocol())
+	switch proto {
+	case "":
+		proto = "tcp"
+	case "tcp", "udp", "sctp":
+	default:
+		return nil, fmt.Errorf("protocol: unsupported protocol %q, use tcp, udp or sctp", m.GetProtocol())
+	}
+	if ip := m.GetHostIp(); ip != "" {
+		if _, err := netip.ParseAddr(ip); err != nil {
+			return nil, fmt.Errorf("host_ip: %q is not an IP address", ip)
+		}
+	}
+
+	start, end := portRange(m.GetContainerPort(), m.GetContainerPortEnd())
+	if start < 1 || end < start || end > math.MaxUint16 {
+		return nil, fmt.Errorf("container_port: %d-%d is not a port or range from 1 to 65535", start, end)
+	}
+	hostStart, hostEnd := portRange(m.GetHostPort(), m.GetHostPortEnd())
+	if hostStart < 0 || hostEnd < hostStart || hostEnd > math.MaxUint16 || (hostStart == 0 && hostEnd != 0) {
+		return nil, fmt.Errorf("host_port: %d-%d is not a port or range from 1 to 65535, or 0 for any port",
+			hostStart, hostEnd)
+	}
+	single := start == end
+	if hostStart != 0 && !single && hostEnd-hostStart != end-start {
+		return nil, fmt.Errorf("host_port: range %d-%d does not match container range %d-%d",
+			hostStart, hostEnd, start, end)
+	}
+
+	res := make(nat.PortMap, end-start+1)
+	for p := start; p <= end; p++ {
+		var hostPort string
+		switch {
+		case hostStart == 0:
+		case single && hostEnd != hostStart:
+			hostPort = fmt.Sprintf("%d-%d", hostStart, hostEnd)
+		default:
+			hostPort = strconv.Itoa(int(hostStart + p - start))
+		}
+		port := nat.Port(fmt.Sprintf("%d/%s", p, proto))
+		res[port] = []nat.PortBinding{{HostIP: m.GetHostIp(), HostPort: hostPort}}
+	}
+	return res, nil
+}
+
+// portRange returns the bounds of a port range whose end is zero for a single port.
+func portRange(start, end int32) (int32, int32) {
+	if end == 0 {
+		return start, start
+	}
+	return start, end
+}
+
+// mapPortMap maps published ports to port mappings ordered by port. Unpublished
+// ports are skipped. A zero host port means Docker allocates it on start.
+func mapPortMap(ports nat.PortMap) []*protos.PortMapping {
+	keys := slices.Collect(maps.Keys(ports))
+	nat.Sort(keys, func(a, b nat.Port) bool {
+		if a.Int() != b.Int() {
+			return a.Int() < b.Int()
+		}
+		return a.Proto() < b.Proto()
+	})
+
+	var res []*protos.PortMapping
+	for _, port := range keys {
+		for _, b := range ports[port] {
+			hostStart, hostEnd, err := nat.ParsePortRangeToInt(b.HostPort)
+			if err != nil {
+				continue
+			}
+			m := &protos.PortMapping{
+				HostIp:        b.HostIP,
+				HostPort:      clampToInt32(hostStart),
+				ContainerPort: clampToInt32(port.Int()),
+				Protocol:      port.Proto(),
+			}
+			if hostEnd != hostStart {
+				m.HostPortEnd = clampToInt32(hostEnd)
+			}
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// validateNetworking checks the endpoints a container joins on creation and returns
+// an error naming the request field of the first invalid value.
+func validateNetworking(req *protos.CreateContainerRequest) error {
+	configured := make(map[string]struct{}, len(req.GetEndpoints()))
+	for i, e := range req.GetEndpoints() {
+		if err := validateEndpoint(e); err != nil {
+			return fmt.Errorf("endpoints[%d].%w", i, err)
+		}
+		if _, ok := configured[e.GetNetwork()]; ok {
+			return fmt.Errorf("endpoints[%d].network: %q is configured more than once", i, e.GetNetwork())
+		}
+		configured[e.GetNetwork()] = struct{}{}
+	}
+	return nil
+}
+
+func validateEndpoint(e *protos.NetworkEndpoint) error {
+	if e.GetNetwork() == "" {
+		return errors.New("network: network name or ID is required")
+	}
+	if mac := e.GetMacAddress(); mac != "" {
+		if _, err := net.ParseMAC(mac); err != nil {
+			return fmt.Errorf("mac_address: %q is not a MAC address", mac)
+		}
+	}
+	for i, link := range e.GetLinks() {
+		name, alias, hasAlias := strings.Cut(link, ":")
+		if name == "" || (hasAlias && alias == "") {
+			return fmt.Errorf("links[%d]: %q must be container or container:alias", i, link)
+		}
 	}
+	return validateEndpointIPAM(e.GetIpam())
+}
 
+func validateEndpointIPAM(ipam *protos.EndpointIPAMConfig) error {
+	if ip := ipam.GetIpv4Address(); ip != "" {
+		if addr, err := netip.ParseAddr(ip); err != nil || !addr.Is4() {
+			return fmt.Errorf("ipam.ipv4_address: %q is not an IPv4 address", ip)
+		}
+	}
+	if ip := ipam.GetIpv6Address(); ip != "" {
+		if addr, err := netip.ParseAddr(ip); err != nil || !addr.Is6() {
+			return fmt.Errorf("ipam.ipv6_address: %q is not an IPv6 address", ip)
+		}
+	}
+	for i, ip := range ipam.GetLinkLocalIps() {
+		if addr, err := netip.ParseAddr(ip); err != nil || !addr.IsLinkLocalUnicast() {
+			return fmt.Errorf("ipam.link_local_ips[%d]: %q is not a link-local address", i, ip)
+		}
+	}
+	return nil
+}
+
+// mapNetworking maps the networks a container joins on creation. Networks listed by
+// name join with default settings; endpoints configure a network's static addresses,
+// aliases, MAC address, links, and driver options.
+func mapNetworking(req *protos.CreateContainerRequest) *network.NetworkingConfig {
+	if len(req.GetNetworks()) == 0 && len(req.GetEndpoints()) == 0 {
+		return nil
+	}
+
+	endpoints := make(map[string]*network.EndpointSettings, len(req.GetNetworks())+len(req.GetEndpoints()))
+	for _, n := range req.GetNetworks() {
+		endpoints[n] = &network.EndpointSettings{}
+	}
+	for _, e := range req.GetEndpoints() {
+		endpoints[e.GetNetwork()] = mapEndpoint(e)
+	}
 	return &network.NetworkingConfig{EndpointsConfig: endpoints}
 }
 
+func mapEndpoint(e *protos.NetworkEndpoint) *network.EndpointSettings {
+	settings := &network.EndpointSettings{
+		MacAddress: e.GetMacAddress(),
+		Aliases:    e.GetAliases(),
+		Links:      e.GetLinks(),
+		DriverOpts: e.GetDriverOpts(),
+	}
+	if ipam := e.GetIpam(); ipam != nil {
+		settings.IPAMConfig = &network.EndpointIPAMConfig{
+			IPv4Address:  ipam.GetIpv4Address(),
+			IPv6Address:  ipam.GetIpv6Address(),
+			LinkLocalIPs: ipam.GetLinkLocalIps(),
+		}
+	}
+	return settings
+}
+
+func mapLogsOptions(req *protos.GetContainerLogsRequest) container.LogsOptions {
+	opts := container.LogsOptions{
+		ShowStdout: req.GetStream() != protos.LogStream_LOG_STREAM_STDERR,
+		ShowStderr: req.GetStream() != protos.LogStream_LOG_STREAM_STDOUT,
+		Since:      service.FormatUnixNano(req.GetSinceNano()),
+		Until:      service.FormatUnixNano(req.GetUntilNano()),
+		Timestamps: true,
+		Follow:     req.GetFollow(),
+		Tail:       "all",
+	}
+	if req.Tail != nil {
+		opts.Tail = strconv.FormatInt(req.GetTail(), 10)
+	}
+	return opts
+}
+
+func mapExecOptions(start *protos.ExecStart) container.ExecOptions {
+	opts := container.ExecOptions{
+		User:         start.GetUser(),
+		Tty:          start.GetTty(),
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env:          start.GetEnv(),
+		WorkingDir:   start.GetWorkingDir(),
+		Cmd:          start.GetCmd(),
+	}
+	if start.GetTty() {
+		opts.ConsoleSize = mapConsoleSize(start.GetSize())
+	}
+	return opts
+}
+
+func mapConsoleSize(size *protos.TerminalSize) *[2]uint {
+	if size.GetHeight() == 0 || size.GetWidth() == 0 {
+		return nil
+	}
+	return &[2]uint{uint(size.GetHeight()), uint(size.GetWidth())}
+}
+
+func mapResizeOptions(size *protos.TerminalSize) container.ResizeOptions {
+	return container.ResizeOptions{
+		Height: uint(size.GetHeight()),
+		Width:  uint(size.GetWidth()),
+	}
+}
+
+func clampToInt32(v int) int32 {
+	if v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if v < math.MinInt32 {
+		return math.MinInt32
+	}
+	return int32(v)
+}
+
 func mapPorts(ports []container.Port) []string {
 	result := make([]string, 0, len(ports))
 	for _, port := range ports {
@@ -3,13 +3,17 @@
 package container
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/go-connections/nat"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/whiteo/yadoma/internal/protos"
 )
 
@@ -247,12 +251,17 @@ func TestMapStats(t *testing.T) {
 				},
 			},
 			expected: &protos.GetContainerStatsResponse{
-				Id:        "container123",
-				CpuUsage:  1000000000,
-				MemUsage:  128000000,
-				MemLimit:  256000000,
-				NetInput:  1024,
-				NetOutput: 2048,
+				Id:            "container123",
+				CpuUsage:      1000000000,
+				MemUsage:      128000000,
+				MemLimit:      256000000,
+				NetInput:      1024,
+				NetOutput:     2048,
+				MemWorkingSet: 128000000,
+				MemPercent:    50,
+				Networks: []*protos.NetworkInterfaceStats{
+					{Name: "eth0", RxBytes: 1024, TxBytes: 2048},
+				},
 			},
 		},
 		{
@@ -280,12 +289,105 @@ func TestMapStats(t *testing.T) {
 				},
 			},
 			expected: &protos.GetContainerStatsResponse{
-				Id:        "container456",
-				CpuUsage:  2000000000,
-				MemUsage:  64000000,
-				MemLimit:  128000000,
-				NetInput:  3072,
-				NetOutput: 1536,
+				Id:            "container456",
+				CpuUsage:      2000000000,
+				MemUsage:      64000000,
+				MemLimit:      128000000,
+				NetInput:      3072,
+				NetOutput:     1536,
+				MemWorkingSet: 64000000,
+				MemPercent:    50,
+				Networks: []*protos.NetworkInterfaceStats{
+					{Name: "eth0", RxBytes: 1024, TxBytes: 512},
+					{Name: "eth1", RxBytes: 2048, TxBytes: 1024},
+				},
+			},
+		},
+		{
+			name: "cgroup v1 with previous sample",
+			stats: container.StatsResponse{
+				ID: "container789",
+				CPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 3000, PercpuUsage: []uint64{1000, 2000}},
+					SystemUsage: 20000,
+				},
+				PreCPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 1000},
+					SystemUsage: 10000,
+				},
+				MemoryStats: container.MemoryStats{
+					Usage: 400,
+					Limit: 1000,
+					Stats: map[string]uint64{"total_inactive_file": 100, "cache": 300},
+				},
+				BlkioStats: container.BlkioStats{
+					IoServiceBytesRecursive: []container.BlkioStatEntry{
+						{Major: 8, Op: "Read", Value: 10},
+						{Major: 8, Op: "Write", Value: 20},
+						{Major: 8, Op: "Total", Value: 30},
+						{Major: 9, Op: "Read", Value: 5},
+					},
+				},
+				PidsStats: container.PidsStats{Current: 7},
+				Networks: map[string]container.NetworkStats{
+					"eth0": {RxBytes: 1, RxPackets: 2, RxErrors: 3, RxDropped: 4,
+						TxBytes: 5, TxPackets: 6, TxErrors: 7, TxDropped: 8},
+				},
+			},
+			expected: &protos.GetContainerStatsResponse{
+				Id:            "container789",
+				CpuUsage:      3000,
+				CpuPercent:    40,
+				OnlineCpus:    2,
+				MemUsage:      400,
+				MemLimit:      1000,
+				MemWorkingSet: 300,
+				MemPercent:    30,
+				BlockRead:     15,
+				BlockWrite:    20,
+				Pids:          7,
+				NetInput:      1,
+				NetOutput:     5,
+				Networks: []*protos.NetworkInterfaceStats{
+					{Name: "eth0", RxBytes: 1, RxPackets: 2, RxErrors: 3, RxDropped: 4,
+						TxBytes: 5, TxPackets: 6, TxErrors: 7, TxDropped: 8},
+				},
+			},
+		},
+		{
+			name: "cgroup v2 with online cpus",
+			stats: container.StatsResponse{
+				ID: "container000",
+				CPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 5000},
+					SystemUsage: 40000,
+					OnlineCPUs:  4,
+				},
+				PreCPUStats: container.CPUStats{
+					CPUUsage:    container.CPUUsage{TotalUsage: 3000},
+					SystemUsage: 20000,
+				},
+				MemoryStats: container.MemoryStats{
+					Usage: 400,
+					Stats: map[string]uint64{"inactive_file": 500},
+				},
+				BlkioStats: container.BlkioStats{
+					IoServiceBytesRecursive: []container.BlkioStatEntry{
+						{Op: "read", Value: 1},
+						{Op: "write", Value: 2},
+					},
+				},
+			},
+			expected: &protos.GetContainerStatsResponse{
+				Id:            "container000",
+				CpuUsage:      5000,
+				CpuPercent:    40,
+				OnlineCpus:    4,
+				MemUsage:      400,
+				MemWorkingSet: 400,
+				BlockRead:     1,
+				BlockWrite:    2,
+				Networks:      []*protos.NetworkInterfaceStats{},
 			},
 		},
 	}
@@ -299,10 +401,14 @@ func TestMapStats(t *testing.T) {
 }
 
 func TestMapConfig(t *testing.T) {
+	stopTimeout := int32(30)
+	expectedStopTimeout := 30
+
 	tests := []struct {
 		name     string
 		req      *protos.CreateContainerRequest
 		expected *container.Config
+		errField string
 	}{
 		{
 			name: "basic config",
@@ -328,17 +434,153 @@ func TestMapConfig(t *testing.T) {
 				Env:   nil,
 			},
 		},
+		{
+			name: "full config",
+			req: &protos.CreateContainerRequest{
+				Image:        "postgres:17",
+				Entrypoint:   []string{"docker-entrypoint.sh"},
+				Cmd:          []string{"postgres"},
+				WorkingDir:   "/var/lib/postgresql",
+				User:         "postgres:postgres",
+				Hostname:     "db-1",
+				Domainname:   "internal.example.com",
+				Labels:       map[string]string{"com.docker.compose.project": "shop"},
+				ExposedPorts: []string{"5432", "9000-9001/udp", "7/SCTP"},
+				StopSignal:   "SIGINT",
+				StopTimeout:  &stopTimeout,
+				Healthcheck: &protos.Healthcheck{
+					Test:            []string{"CMD-SHELL", "pg_isready"},
+					IntervalMs:      5000,
+					TimeoutMs:       2000,
+					StartPeriodMs:   30000,
+					StartIntervalMs: 1000,
+					Retries:         5,
+				},
+				Tty:       true,
+				OpenStdin: true,
+				StdinOnce: true,
+			},
+			expected: &container.Config{
+				Image:      "postgres:17",
+				Entrypoint: []string{"docker-entrypoint.sh"},
+				Cmd:        []string{"postgres"},
+				WorkingDir: "/var/lib/postgresql",
+				User:       "postgres:postgres",
+				Hostname:   "db-1",
+				Domainname: "internal.example.com",
+				Labels:     map[string]string{"com.docker.compose.project": "shop"},
+				ExposedPorts: nat.PortSet{
+					"5432/tcp": {},
+					"9000/udp": {},
+					"9001/udp": {},
+					"7/sctp":   {},
+				},
+				StopSignal:  "SIGINT",
+				StopTimeout: &expectedStopTimeout,
+				Healthcheck: &container.HealthConfig{
+					Test:          []string{"CMD-SHELL", "pg_isready"},
+					Interval:      5 * time.Second,
+					Timeout:       2 * time.Second,
+					StartPeriod:   30 * time.Second,
+					StartInterval: time.Second,
+					Retries:       5,
+				},
+				Tty:       true,
+				OpenStdin: true,
+				StdinOnce: true,
+			},
+		},
+		{
+			name:     "env without name",
+			req:      &protos.CreateContainerRequest{Image: "alpine", Env: []string{"A=1", "=2"}},
+			errField: "env[1]",
+		},
+		{
+			name:     "relative working dir",
+			req:      &protos.CreateContainerRequest{Image: "alpine", WorkingDir: "app"},
+			errField: "working_dir",
+		},
+		{
+			name:     "invalid hostname",
+			req:      &protos.CreateContainerRequest{Image: "alpine", Hostname: "-web_1"},
+			errField: "hostname",
+		},
+		{
+			name:     "invalid domain name",
+			req:      &protos.CreateContainerRequest{Image: "alpine", Domainname: "example..com"},
+			errField: "domainname",
+		},
+		{
+			name:     "empty label key",
+			req:      &protos.CreateContainerRequest{Image: "alpine", Labels: map[string]string{"": "x"}},
+			errField: "labels",
+		},
+		{
+			name:     "invalid exposed port",
+			req:      &protos.CreateContainerRequest{Image: "alpine", ExposedPorts: []string{"80", "http"}},
+			errField: "exposed_ports[1]",
+		},
+		{
+			name:     "unsupported exposed port protocol",
+			req:      &protos.CreateContainerRequest{Image: "alpine", ExposedPorts: []string{"80/icmp"}},
+			errField: "exposed_ports[0]",
+		},
+		{
+			name:     "unknown stop signal",
+			req:      &protos.CreateContainerRequest{Image: "alpine", StopSignal: "SIGNOPE"},
+			errField: "stop_signal",
+		},
+		{
+			name: "negative healthcheck interval",
+			req: &protos.CreateContainerRequest{Image: "alpine", Healthcheck: &protos.Healthcheck{
+				Test: []string{"CMD", "true"}, IntervalMs: -1,
+			}},
+			errField: "healthcheck.interval_ms",
+		},
+		{
+			name: "invalid healthcheck test",
+			req: &protos.CreateContainerRequest{Image: "alpine", Healthcheck: &protos.Healthcheck{
+				Test: []string{"curl", "localhost"},
+			}},
+			errField: "healthcheck.test",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := mapConfig(tt.req)
+			result, err := mapConfig(tt.req)
+			if tt.errField != "" {
+				require.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tt.errField+":"), err.Error())
+				assert.Nil(t, result)
+				return
+			}
+			require.NoError(t, err)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
 }
 
+func TestValidSignal(t *testing.T) {
+	for sig, expected := range map[string]bool{
+		"SIGTERM":    true,
+		"term":       true,
+		"15":         true,
+		"SIGRTMIN+3": true,
+		"0":          false,
+		"65":         false,
+		"SIGFOO":     false,
+		"":           false,
+	} {
+		assert.Equal(t, expected, validSignal(sig), sig)
+	}
+}
+
 func TestMapHostConfig(t *testing.T) {
+	pidsLimit := int64(200)
+	expectedPidsLimit := int64(200)
+	useInit := true
+
 	tests := []struct {
 		name     string
 		req      *protos.HostConfig
@@ -417,21 +659,189 @@ func TestMapHostConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "host config with resources, security and runtime options",
+			req: &protos.HostConfig{
+				CpuShares:         512,
+				NanoCpus:          1500000000,
+				CpusetCpus:        "0-1",
+				Memory:            512 << 20,
+				MemoryReservation: 256 << 20,
+				MemorySwap:        1 << 30,
+				PidsLimit:         &pidsLimit,
+				Ulimits:           []*protos.Ulimit{{Name: "NOFILE", Soft: 1024, Hard: 4096}},
+				Devices:           []*protos.DeviceMapping{{PathOnHost: "/dev/fuse"}},
+				CapAdd:            []string{"net_admin", "CAP_SYS_TIME"},
+				CapDrop:           []string{"ALL"},
+				ReadonlyRootfs:    true,
+				SecurityOpt:       []string{"no-new-privileges", "seccomp=unconfined"},
+				Sysctls:           map[string]string{"net.core.somaxconn": "1024"},
+				Tmpfs:             map[string]string{"/run": "size=64m"},
+				Dns:               []string{"10.0.0.2"},
+				ExtraHosts:        []string{"db:10.0.0.5", "host.docker.internal:host-gateway"},
+				LogConfig:         &protos.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "10m"}},
+				ShmSize:           128 << 20,
+				Init:              &useInit,
+			},
+			expected: &container.HostConfig{
+				PortBindings: nat.PortMap{},
+				Resources: container.Resources{
+					CPUShares:         512,
+					NanoCPUs:          1500000000,
+					CpusetCpus:        "0-1",
+					Memory:            512 << 20,
+					MemoryReservation: 256 << 20,
+					MemorySwap:        1 << 30,
+					PidsLimit:         &expectedPidsLimit,
+					Ulimits:           []*container.Ulimit{{Name: "nofile", Soft: 1024, Hard: 4096}},
+					Devices: []container.DeviceMapping{{
+						PathOnHost:        "/dev/fuse",
+						PathInContainer:   "/dev/fuse",
+						CgroupPermissions: "rwm",
+					}},
+				},
+				CapAdd:         strslice.StrSlice{"CAP_NET_ADMIN", "CAP_SYS_TIME"},
+				CapDrop:        strslice.StrSlice{"ALL"},
+				ReadonlyRootfs: true,
+				SecurityOpt:    []string{"no-new-privileges", "seccomp=unconfined"},
+				Sysctls:        map[string]string{"net.core.somaxconn": "1024"},
+				Tmpfs:          map[string]string{"/run": "size=64m"},
+				DNS:            []string{"10.0.0.2"},
+				ExtraHosts:     []string{"db:10.0.0.5", "host.docker.internal:host-gateway"},
+				LogConfig:      container.LogConfig{Type: "json-file", Config: map[string]string{"max-size": "10m"}},
+				ShmSize:        128 << 20,
+				Init:           &useInit,
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := mapHostConfig(tt.req)
+			result, err := mapHostConfig(tt.req)
+			require.NoError(t, err)
 			assert.Equal(t, tt.expected, result)
 		})
 	}
 }
 
-func TestMapNetworking(t *testing.T) {
+func TestMapPortBindings(t *testing.T) {
 	tests := []struct {
 		name     string
-		networks []string
-		expected *network.NetworkingConfig
+		mappings []*protos.PortMapping
+		expected nat.PortMap
+		errField string
+	}{
+		{
+			name:     "udp port",
+			mappings: []*protos.PortMapping{{HostPort: 53, ContainerPort: 53, Protocol: "UDP"}},
+			expected: nat.PortMap{"53/udp": {{HostPort: "53"}}},
+		},
+		{
+			name:     "ephemeral host port",
+			mappings: []*protos.PortMapping{{HostIp: "127.0.0.1", ContainerPort: 80}},
+			expected: nat.PortMap{"80/tcp": {{HostIP: "127.0.0.1", HostPort: ""}}},
+		},
+		{
+			name: "matching ranges",
+			mappings: []*protos.PortMapping{{
+				HostPort: 9000, HostPortEnd: 9002, ContainerPort: 8000, ContainerPortEnd: 8002, Protocol: "sctp",
+			}},
+			expected: nat.PortMap{
+				"8000/sctp": {{HostPort: "9000"}},
+				"8001/sctp": {{HostPort: "9001"}},
+				"8002/sctp": {{HostPort: "9002"}},
+			},
+		},
+		{
+			name:     "host range for one container port",
+			mappings: []*protos.PortMapping{{HostPort: 8000, HostPortEnd: 8010, ContainerPort: 80}},
+			expected: nat.PortMap{"80/tcp": {{HostPort: "8000-8010"}}},
+		},
+		{
+			name:     "ephemeral host ports for a range",
+			mappings: []*protos.PortMapping{{ContainerPort: 7000, ContainerPortEnd: 7001}},
+			expected: nat.PortMap{"7000/tcp": {{HostPort: ""}}, "7001/tcp": {{HostPort: ""}}},
+		},
+		{
+			name: "same port on two addresses",
+			mappings: []*protos.PortMapping{
+				{HostIp: "0.0.0.0", HostPort: 80, ContainerPort: 80},
+				{HostIp: "::", HostPort: 80, ContainerPort: 80},
+			},
+			expected: nat.PortMap{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "80"}, {HostIP: "::", HostPort: "80"}}},
+		},
+		{
+			name:     "unsupported protocol",
+			mappings: []*protos.PortMapping{{ContainerPort: 80, Protocol: "icmp"}},
+			errField: "port_bindings[web].host_ports[0].protocol",
+		},
+		{
+			name:     "missing container port",
+			mappings: []*protos.PortMapping{{HostPort: 80}},
+			errField: "port_bindings[web].host_ports[0].container_port",
+		},
+		{
+			name:     "inverted container range",
+			mappings: []*protos.PortMapping{{ContainerPort: 90, ContainerPortEnd: 80}},
+			errField: "port_bindings[web].host_ports[0].container_port",
+		},
+		{
+			name:     "host port out of range",
+			mappings: []*protos.PortMapping{{HostPort: 70000, ContainerPort: 80}},
+			errField: "port_bindings[web].host_ports[0].host_port",
+		},
+		{
+			name: "mismatched ranges",
+			mappings: []*protos.PortMapping{{
+				HostPort: 9000, HostPortEnd: 9001, ContainerPort: 8000, ContainerPortEnd: 8002,
+			}},
+			errField: "port_bindings[web].host_ports[0].host_port",
+		},
+		{
+			name:     "invalid host ip",
+			mappings: []*protos.PortMapping{{HostIp: "localhost", ContainerPort: 80}},
+			errField: "port_bindings[web].host_ports[0].host_ip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := mapPortBindings(map[string]*protos.PortBinding{"web": {HostPorts: tt.mappings}})
+			if tt.errField != "" {
+				require.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tt.errField+":"), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestMapPortMap(t *testing.T) {
+	ports := nat.PortMap{
+		"8080/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}},
+		"53/udp":   {{HostPort: "53"}},
+		"53/tcp":   {{HostPort: "5353"}},
+		"80/tcp":   {{HostPort: "8000-8010"}},
+		"9000/tcp": nil,
+	}
+
+	assert.Equal(t, []*protos.PortMapping{
+		{HostPort: 5353, ContainerPort: 53, Protocol: "tcp"},
+		{HostPort: 53, ContainerPort: 53, Protocol: "udp"},
+		{HostPort: 8000, HostPortEnd: 8010, ContainerPort: 80, Protocol: "tcp"},
+		{HostIp: "0.0.0.0", HostPort: 32768, ContainerPort: 8080, Protocol: "tcp"},
+	}, mapPortMap(ports))
+	assert.Nil(t, mapPortMap(nil))
+}
+
+func TestMapNetworking(t *testing.T) {
+	tests := []struct {
+		name      string
+		networks  []string
+		endpoints []*protos.NetworkEndpoint
+		expected  *network.NetworkingConfig
 	}{
 		{
 			name:     "empty networks",
@@ -457,12 +867,237 @@ func TestMapNetworking(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "endpoint overrides listed network",
+			networks: []string{"backend", "frontend"},
+			endpoints: []*protos.NetworkEndpoint{{
+				Network:    "backend",
+				MacAddress: "02:42:ac:11:00:02",
+				Aliases:    []string{"db", "postgres"},
+				Links:      []string{"cache:redis"},
+				DriverOpts: map[string]string{"com.docker.network.endpoint.ifname": "eth1"},
+				Ipam: &protos.EndpointIPAMConfig{
+					Ipv4Address:  "172.20.0.10",
+					Ipv6Address:  "fd00::10",
+					LinkLocalIps: []string{"169.254.0.10"},
+				},
+			}},
+			expected: &network.NetworkingConfig{
+				EndpointsConfig: map[string]*network.EndpointSettings{
+					"backend": {
+						MacAddress: "02:42:ac:11:00:02",
+						Aliases:    []string{"db", "postgres"},
+						Links:      []string{"cache:redis"},
+						DriverOpts: map[string]string{"com.docker.network.endpoint.ifname": "eth1"},
+						IPAMConfig: &network.EndpointIPAMConfig{
+							IPv4Address:  "172.20.0.10",
+							IPv6Address:  "fd00::10",
+							LinkLocalIPs: []string{"169.254.0.10"},
+						},
+					},
+					"frontend": {},
+				},
+			},
+		},
+		{
+			name:      "endpoint only",
+			endpoints: []*protos.NetworkEndpoint{{Network: "custom", Aliases: []string{"web"}}},
+			expected: &network.NetworkingConfig{
+				EndpointsConfig: map[string]*network.EndpointSettings{
+					"custom": {Aliases: []string{"web"}},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := mapNetworking(tt.networks)
-			assert.Equal(t, tt.expected, result)
+			req := &protos.CreateContainerRequest{Networks: tt.networks, Endpoints: tt.endpoints}
+			require.NoError(t, validateNetworking(req))
+			assert.Equal(t, tt.expected, mapNetworking(req))
+		})
+	}
+}
+
+func TestValidateNetworking(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoints []*protos.NetworkEndpoint
+		errField  string
+	}{
+		{
+			name:      "missing network",
+			endpoints: []*protos.NetworkEndpoint{{Aliases: []string{"web"}}},
+			errField:  "endpoints[0].network",
+		},
+		{
+			name:      "duplicate network",
+			endpoints: []*protos.NetworkEndpoint{{Network: "custom"}, {Network: "custom"}},
+			errField:  "endpoints[1].network",
+		},
+		{
+			name:      "invalid mac address",
+			endpoints: []*protos.NetworkEndpoint{{Network: "custom", MacAddress: "02:42"}},
+			errField:  "endpoints[0].mac_address",
+		},
+		{
+			name: "ipv6 address as ipv4",
+			endpoints: []*protos.NetworkEndpoint{{
+				Network: "custom", Ipam: &protos.EndpointIPAMConfig{Ipv4Address: "fd00::10"},
+			}},
+			errField: "endpoints[0].ipam.ipv4_address",
+		},
+		{
+			name: "invalid ipv6 address",
+			endpoints: []*protos.NetworkEndpoint{{
+				Network: "custom", Ipam: &protos.EndpointIPAMConfig{Ipv6Address: "10.0.0.1"},
+			}},
+			errField: "endpoints[0].ipam.ipv6_address",
+		},
+		{
+			name: "routable link-local address",
+			endpoints: []*protos.NetworkEndpoint{{
+				Network: "custom", Ipam: &protos.EndpointIPAMConfig{LinkLocalIps: []string{"10.0.0.1"}},
+			}},
+			errField: "endpoints[0].ipam.link_local_ips[0]",
+		},
+		{
+			name:      "link without container",
+			endpoints: []*protos.NetworkEndpoint{{Network: "custom", Links: []string{":alias"}}},
+			errField:  "endpoints[0].links[0]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNetworking(&protos.CreateContainerRequest{Endpoints: tt.endpoints})
+			require.Error(t, err)
+			assert.True(t, strings.HasPrefix(err.Error(), tt.errField+":"), err.Error())
+		})
+	}
+}
+
+func TestMapExecOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    *protos.ExecStart
+		expected container.ExecOptions
+	}{
+		{
+			name:  "without tty ignores size",
+			start: &protos.ExecStart{Cmd: []string{"ls"}, Size: &protos.TerminalSize{Height: 24, Width: 80}},
+			expected: container.ExecOptions{
+				AttachStdin: true, AttachStdout: true, AttachStderr: true, Cmd: []string{"ls"},
+			},
+		},
+		{
+			name: "tty with size",
+			start: &protos.ExecStart{Cmd: []string{"sh"}, Tty: true, User: "root", WorkingDir: "/",
+				Env: []string{"TERM=xterm"}, Size: &protos.TerminalSize{Height: 24, Width: 80}},
+			expected: container.ExecOptions{
+				User: "root", Tty: true, AttachStdin: true, AttachStdout: true, AttachStderr: true,
+				Env: []string{"TERM=xterm"}, WorkingDir: "/", Cmd: []string{"sh"}, ConsoleSize: &[2]uint{24, 80},
+			},
+		},
+		{
+			name:  "tty with incomplete size",
+			start: &protos.ExecStart{Cmd: []string{"sh"}, Tty: true, Size: &protos.TerminalSize{Height: 24}},
+			expected: container.ExecOptions{
+				Tty: true, AttachStdin: true, AttachStdout: true, AttachStderr: true, Cmd: []string{"sh"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mapExecOptions(tt.start))
+		})
+	}
+}
+
+func TestMapLogsOptions(t *testing.T) {
+	tail := int64(100)
+	zero := int64(0)
+
+	tests := []struct {
+		name     string
+		req      *protos.GetContainerLogsRequest
+		expected container.LogsOptions
+	}{
+		{
+			name:     "defaults to whole history of both streams with timestamps",
+			req:      &protos.GetContainerLogsRequest{Id: "c1"},
+			expected: container.LogsOptions{ShowStdout: true, ShowStderr: true, Timestamps: true, Tail: "all"},
+		},
+		{
+			name: "tail, time range and timestamps",
+			req: &protos.GetContainerLogsRequest{Id: "c1", Follow: true, Tail: &tail, Timestamps: true,
+				SinceNano: 1735689600000000000, UntilNano: 1735689660500000000},
+			expected: container.LogsOptions{ShowStdout: true, ShowStderr: true, Follow: true, Timestamps: true,
+				Tail: "100", Since: "1735689600.000000000", Until: "1735689660.500000000"},
+		},
+		{
+			name:     "zero tail with stdout only",
+			req:      &protos.GetContainerLogsRequest{Id: "c1", Tail: &zero, Stream: protos.LogStream_LOG_STREAM_STDOUT},
+			expected: container.LogsOptions{ShowStdout: true, Timestamps: true, Tail: "0"},
+		},
+		{
+			name:     "stderr only",
+			req:      &protos.GetContainerLogsRequest{Id: "c1", Stream: protos.LogStream_LOG_STREAM_STDERR},
+			expected: container.LogsOptions{ShowStderr: true, Timestamps: true, Tail: "all"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, mapLogsOptions(tt.req))
+		})
+	}
+}
+
+func TestMaskEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      []string
+		expected []string
+	}{
+		{
+			name: "secret values are masked",
+			env: []string{
+				"PATH=/usr/bin", "DB_PASSWORD=hunter2", "github_token=ghp_x", "AWS_SECRET_ACCESS_KEY=abc",
+				"API_KEY=", "DEBUG",
+			},
+			expected: []string{
+				"PATH=/usr/bin", "DB_PASSWORD=********", "github_token=********", "AWS_SECRET_ACCESS_KEY=********",
+				"API_KEY=", "DEBUG",
+			},
+		},
+		{
+			name:     "markers ending a camel case name are masked",
+			env:      []string{"githubToken=ghp_x", "apiKey=abc", "db2Passwd=hunter2"},
+			expected: []string{"githubToken=********", "apiKey=********", "db2Passwd=********"},
+		},
+		{
+			name: "markers inside words are kept",
+			env: []string{
+				"KEYBOARD_LAYOUT=us", "MONKEY_MODE=on", "AUTHOR=leo", "TOKENIZER_PATH=/opt/tok",
+				"MONKEY=1", "TURKEY=2", "HOTKEY=F5", "hotkey=F6",
+			},
+			expected: []string{
+				"KEYBOARD_LAYOUT=us", "MONKEY_MODE=on", "AUTHOR=leo", "TOKENIZER_PATH=/opt/tok",
+				"MONKEY=1", "TURKEY=2", "HOTKEY=F5", "hotkey=F6",
+			},
+		},
+		{
+			name:     "no env",
+			env:      nil,
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, maskEnv(tt.env))
 		})
 	}
 }
@@ -0,0 +1,402 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"container/heap"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// logMergeWindow is how long a line may wait for slower containers so that
+	// lines of all containers are sent in timestamp order.
+	logMergeWindow = 200 * time.Millisecond
+	logMergeTick   = logMergeWindow / 4
+)
+
+// StreamMultiContainerLogs streams the logs of several containers as one stream.
+// Containers are selected either by ID or by labels ("key" or "key=value", all of which
+// must match); label selection includes stopped containers. The log options, filters,
+// and line framing of Options apply to every container as in GetContainerLogs, and its
+// ID is ignored. Lines are merged in Docker timestamp order: a line is held back until
+// every other container has caught up with it or for at most a short merge window.
+// Each response carries the container ID and name next to the line.
+// When following without an end time, containers that match the selection and start
+// later, or restart, are picked up from the moment they start. If the Docker event
+// stream breaks, it is resubscribed with backoff from the last start it delivered.
+// Returns InvalidArgument for a missing or ambiguous selection or invalid log options,
+// and Internal if the selected containers cannot be resolved.
+func (s *Service) StreamMultiContainerLogs(
+	req *protos.StreamMultiContainerLogsRequest,
+	stream protos.ContainerService_StreamMultiContainerLogsServer,
+) error {
+	if len(req.GetIds()) == 0 && len(req.GetLabels()) == 0 {
+		return status.Error(codes.InvalidArgument, "container IDs or labels are required")
+	}
+	if len(req.GetIds()) > 0 && len(req.GetLabels()) > 0 {
+		return status.Error(codes.InvalidArgument, "select containers either by IDs or by labels")
+	}
+	for _, label := range req.GetLabels() {
+		if label == "" || strings.HasPrefix(label, "=") {
+			return status.Errorf(codes.InvalidArgument, "invalid label selector %q", label)
+		}
+	}
+	opts := req.GetOptions()
+	if opts == nil {
+		opts = &protos.GetContainerLogsRequest{}
+	}
+	filter, err := validateLogsRequest(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	targets, err := s.resolveLogTargets(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	session := &multiLogSession{
+		service:      s,
+		ctx:          ctx,
+		opts:         opts,
+		filter:       filter,
+		merger:       newLogMerger(logMergeWindow),
+		lines:        make(chan *mergedLine, 256),
+		restarts:     make(map[string]int64),
+		follow:       opts.GetFollow() && opts.GetUntilNano() == 0,
+		startFilters: containerStartFilters(req),
+		backoff:      service.NewBackoff(service.EventsRetryMin, service.EventsRetryMax),
+	}
+	for _, t := range targets {
+		session.start(t, mapLogsOptions(opts))
+	}
+	session.cursor = service.NewEventCursor(time.Now().UnixNano())
+
+	return session.run(stream)
+}
+
+// logTarget is a container whose logs are merged.
+type logTarget struct {
+	id   string
+	name string
+	tty  bool
+}
+
+func (s *Service) resolveLogTargets(
+	ctx context.Context,
+	req *protos.StreamMultiContainerLogsRequest,
+) ([]logTarget, error) {
+	ids := req.GetIds()
+	if len(req.GetLabels()) > 0 {
+		args := filters.NewArgs()
+		for _, label := range req.GetLabels() {
+			args.Add("label", label)
+		}
+		list, err := s.layer.GetContainers(ctx, container.ListOptions{All: true, Filters: args})
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot list containers: %v", err)
+		}
+		ids = make([]string, 0, len(list))
+		for _, c := range list {
+			ids = append(ids, c.ID)
+		}
+	}
+
+	targets := make([]logTarget, 0, len(ids))
+	for _, id := range ids {
+		t, err := s.logTarget(ctx, id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot inspect container %s: %v", id, err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+func (s *Service) logTarget(ctx context.Context, id string) (logTarget, error) {
+	details, err := s.layer.GetContainerDetails(ctx, id)
+	if err != nil {
+		return logTarget{}, err
+	}
+	return logTarget{
+		id:   details.ID,
+		name: strings.TrimPrefix(details.Name, "/"),
+		tty:  details.Config.Tty,
+	}, nil
+}
+
+func containerStartFilters(req *protos.StreamMultiContainerLogsRequest) filters.Args {
+	args := filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("event", string(events.ActionStart)),
+	)
+	for _, id := range req.GetIds() {
+		args.Add("container", id)
+	}
+	for _, label := range req.GetLabels() {
+		args.Add("label", label)
+	}
+	return args
+}
+
+// multiLogSession is the state of one StreamMultiContainerLogs call. Every container
+// is read by its own goroutine into lines; all other state is owned by run.
+type multiLogSession struct {
+	service *Service
+	ctx     context.Context
+	opts    *protos.GetContainerLogsRequest
+	// filter is cloned for every container, as a filter keeps per stream state.
+	filter *logFilter
+	merger *logMerger
+	lines  chan *mergedLine
+	// restarts holds start times of containers that started again before the
+	// stream of their previous run ended.
+	restarts map[string]int64
+	// follow tells whether containers that start later are picked up; their start
+	// events are watched from cursor and resubscribed with backoff.
+	follow       bool
+	startFilters filters.Args
+	cursor       *service.EventCursor
+	backoff      *service.Backoff
+}
+
+func (ms *multiLogSession) run(stream protos.ContainerService_StreamMultiContainerLogsServer) error {
+	send := func(l *mergedLine) error {
+		return stream.Send(&protos.StreamMultiContainerLogsResponse{
+			ContainerId:   l.target.id,
+			ContainerName: l.target.name,
+			Log:           l.frame,
+		})
+	}
+
+	var starts <-chan events.Message
+	var startErrs <-chan error
+	var resubscribe <-chan time.Time
+	if ms.follow {
+		starts, startErrs = ms.watchStarts()
+	}
+
+	ticker := time.NewTicker(logMergeTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case l := <-ms.lines:
+			ms.receive(l)
+		case ev := <-starts:
+			if ms.cursor.Advance(ev) {
+				ms.backoff.Reset()
+				ms.pickUp(ev.Actor.ID, ev.TimeNano)
+			}
+		case err := <-startErrs:
+			starts, startErrs = nil, nil
+			if ms.ctx.Err() == nil {
+				retry := ms.backoff.Next()
+				log.Warn().Err(err).Dur("retry_in", retry).Msg("Container start events interrupted, resubscribing")
+				resubscribe = time.After(retry)
+			}
+		case <-resubscribe:
+			resubscribe = nil
+			starts, startErrs = ms.watchStarts()
+		case <-ticker.C:
+		case <-ms.ctx.Done():
+			return status.FromContextError(ms.ctx.Err()).Err()
+		}
+
+		if err := ms.merger.flush(time.Now(), send); err != nil {
+			return err
+		}
+		if !ms.follow && ms.merger.drained() {
+			return nil
+		}
+	}
+}
+
+// watchStarts subscribes to start events of matching containers from the cursor.
+func (ms *multiLogSession) watchStarts() (<-chan events.Message, <-chan error) {
+	return ms.service.layer.GetEvents(ms.ctx, events.ListOptions{
+		Since:   ms.cursor.Since(),
+		Filters: ms.startFilters,
+	})
+}
+
+func (ms *multiLogSession) receive(l *mergedLine) {
+	if l.frame != nil {
+		ms.merger.push(l, time.Now())
+		return
+	}
+
+	ms.merger.finish(l.target.id)
+	if since, ok := ms.restarts[l.target.id]; ok {
+		delete(ms.restarts, l.target.id)
+		ms.pickUp(l.target.id, since)
+	}
+}
+
+// pickUp starts following a container that started at since, unless the stream of
+// its previous run is still open; then it is picked up once that stream ends.
+func (ms *multiLogSession) pickUp(id string, since int64) {
+	if ms.merger.active(id) {
+		ms.restarts[id] = since
+		return
+	}
+
+	t, err := ms.service.logTarget(ms.ctx, id)
+	if err != nil {
+		log.Warn().Err(err).Str("container", id).Msg("Cannot follow logs of started container")
+		return
+	}
+	opts := mapLogsOptions(ms.opts)
+	opts.Since = service.FormatUnixNano(since)
+	opts.Tail = "all"
+	log.Debug().Str("container", id).Str("name", t.name).Msg("Following logs of started container")
+	ms.start(t, opts)
+}
+
+func (ms *multiLogSession) start(t logTarget, opts container.LogsOptions) {
+	ms.merger.add(t.id)
+	send := func(f *protos.GetContainerLogsResponse) error {
+		select {
+		case ms.lines <- &mergedLine{target: t, frame: f}:
+			return nil
+		case <-ms.ctx.Done():
+			return ms.ctx.Err()
+		}
+	}
+
+	go func() {
+		err := ms.service.streamLogs(ms.ctx, t.id, t.tty, ms.opts, ms.filter.clone(), opts, send)
+		if err != nil && ms.ctx.Err() == nil {
+			log.Warn().Err(err).Str("container", t.id).Msg("Container log stream failed")
+		}
+		select {
+		case ms.lines <- &mergedLine{target: t}:
+		case <-ms.ctx.Done():
+		}
+	}()
+}
+
+// mergedLine is a log line of one container. A line without a frame marks the
+// end of that container's stream.
+type mergedLine struct {
+	target  logTarget
+	frame   *protos.GetContainerLogsResponse
+	arrived time.Time
+	order   uint64
+}
+
+// logMerger orders lines of several containers by timestamp. Docker timestamps of
+// one container never decrease, so a line can be sent once every open stream has
+// delivered a line at least as new, or once it has waited for the merge window.
+type logMerger struct {
+	window  time.Duration
+	pending lineHeap
+	last    map[string]int64
+	order   uint64
+}
+
+func newLogMerger(window time.Duration) *logMerger {
+	return &logMerger{window: window, last: make(map[string]int64)}
+}
+
+func (m *logMerger) add(id string) {
+	m.last[id] = 0
+}
+
+func (m *logMerger) active(id string) bool {
+	_, ok := m.last[id]
+	return ok
+}
+
+func (m *logMerger) finish(id string) {
+	delete(m.last, id)
+}
+
+func (m *logMerger) push(l *mergedLine, now time.Time) {
+	m.order++
+	l.arrived, l.order = now, m.order
+	if ts := l.frame.GetTimestampNano(); ts > m.last[l.target.id] {
+		m.last[l.target.id] = ts
+	}
+	heap.Push(&m.pending, l)
+}
+
+// drained reports whether all streams have ended and every line has been sent.
+func (m *logMerger) drained() bool {
+	return len(m.last) == 0 && len(m.pending) == 0
+}
+
+func (m *logMerger) flush(now time.Time, send func(*mergedLine) error) error {
+	for len(m.pending) > 0 && m.ready(m.pending[0], now) {
+		next := m.pending[0]
+		heap.Pop(&m.pending)
+		if err := send(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *logMerger) ready(l *mergedLine, now time.Time) bool {
+	if now.Sub(l.arrived) >= m.window {
+		return true
+	}
+	for _, last := range m.last {
+		if last < l.frame.GetTimestampNano() {
+			return false
+		}
+	}
+	return true
+}
+
+type lineHeap []*mergedLine
+
+func (h lineHeap) Len() int { return len(h) }
+
+func (h lineHeap) Less(i, j int) bool {
+	ti, tj := h[i].frame.GetTimestampNano(), h[j].frame.GetTimestampNano()
+	if ti != tj {
+		return ti < tj
+	}
+	return h[i].order < h[j].order
+}
+
+func (h lineHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *lineHeap) Push(x any) {
+	if l, ok := x.(*mergedLine); ok {
+		*h = append(*h, l)
+	}
+}
+
+func (h *lineHeap) Pop() any {
+	old := *h
+	l := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return l
+}
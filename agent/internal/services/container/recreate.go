@@ -0,0 +1,459 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultHealthTimeout = time.Minute
+	healthPollInterval   = 500 * time.Millisecond
+	// shortIDLength is the length of the short container ID Docker uses as default
+	// host name and network alias.
+	shortIDLength = 12
+)
+
+// recreation tracks the steps of a recreate that have to be undone on failure.
+type recreation struct {
+	old     container.InspectResponse
+	name    string
+	stopped bool
+	renamed bool
+	// aside is the name the old container was renamed to.
+	aside string
+	newID string
+}
+
+// RecreateContainer replaces a container with one created from its current configuration
+// and a patch, keeping its name, networks with their static addresses, MAC addresses,
+// and aliases, and volumes, including anonymous ones. The patch may replace the image, the command, or
+// the port bindings, and set or remove environment variables ("NAME=value" sets NAME,
+// "NAME" removes it) and labels. If the patch sets the image, the settings the container
+// inherited from its old image, such as its environment, entrypoint, command, working
+// directory, user, and healthcheck, are dropped, so the defaults of the new image apply;
+// settings the container overrode are kept. The new image must be present locally.
+// The old container is stopped and renamed aside, the new one is created under the old
+// name and started, and the old one is removed only once the new one runs, or is healthy
+// when wait_healthy is set and the container has a healthcheck (within health_timeout_seconds,
+// one minute by default). If any step fails, the new container is removed and the old one
+// is renamed back and restarted if it was running; if the new container cannot be removed,
+// the old one is restarted under its temporary name.
+// On success, it returns the new container ID, its name, bound ports, and warnings such as
+// an old container that could not be removed. On failure, it returns gRPC errors:
+// `InvalidArgument` for a missing ID or an invalid patch field, `NotFound` for an unknown
+// container, `FailedPrecondition` for an auto-remove container, which stopping would delete,
+// `Internal` for Docker-layer failures, and `Unavailable` if the new container did not
+// become ready.
+func (s *Service) RecreateContainer(
+	ctx context.Context,
+	req *protos.RecreateContainerRequest,
+) (*protos.RecreateContainerResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "container ID is required")
+	}
+	if err := validateRecreate(req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	old, err := s.layer.GetContainerDetails(ctx, req.GetId())
+	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "container %s does not exist", req.GetId())
+		}
+		return nil, status.Errorf(codes.Internal, "cannot get container details: %v", err)
+	}
+	if old.ContainerJSONBase == nil || old.Config == nil || old.HostConfig == nil {
+		return nil, status.Errorf(codes.Internal, "cannot recreate container %s: incomplete details", req.GetId())
+	}
+	if old.HostConfig.AutoRemove {
+		return nil, status.Errorf(codes.FailedPrecondition,
+			"cannot recreate container %s: it is removed when stopped", req.GetId())
+	}
+
+	var oldImage *image.InspectResponse
+	if req.GetImage() != "" {
+		var img image.InspectResponse
+		if img, err = s.layer.GetImageDetails(ctx, old.Image); err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot inspect image of container: %v", err)
+		}
+		oldImage = &img
+	}
+
+	spec := mapRecreateSpec(old, oldImage)
+	if err = applyRecreatePatch(spec, req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	r := &recreation{old: old, name: strings.TrimPrefix(old.Name, "/")}
+	details, err := s.replaceContainer(ctx, r, spec, req)
+	if err != nil {
+		s.rollbackRecreate(ctx, r)
+		return nil, err
+	}
+
+	resp := &protos.RecreateContainerResponse{Id: r.newID, Name: r.name}
+	if details.NetworkSettings != nil {
+		resp.Ports = mapPortMap(details.NetworkSettings.Ports)
+	}
+	if err = s.layer.RemoveContainer(ctx, old.ID, container.RemoveOptions{}); err != nil {
+		log.Error().Err(err).Str("container", old.ID).Msg("cannot remove recreated container")
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("old container %s was not removed: %v", old.ID, err))
+	}
+	log.Info().Str("container", r.newID).Str("old", old.ID).Str("name", r.name).Msg("Recreated container")
+	return resp, nil
+}
+
+func validateRecreate(req *protos.RecreateContainerRequest) error {
+	for i, e := range req.GetEnv() {
+		if name, _, _ := strings.Cut(e, "="); name == "" {
+			return fmt.Errorf("env[%d]: variable name is required in %q", i, e)
+		}
+	}
+	if _, ok := req.GetLabels()[""]; ok {
+		return errors.New("labels: label key is required")
+	}
+	if req.StopTimeout != nil && req.GetStopTimeout() < -1 {
+		return fmt.Errorf("stop_timeout: %d is invalid, use -1 to wait without limit", req.GetStopTimeout())
+	}
+	if req.GetHealthTimeoutSeconds() < 0 {
+		return fmt.Errorf("health_timeout_seconds: %d must not be negative", req.GetHealthTimeoutSeconds())
+	}
+	return nil
+}
+
+// mapRecreateSpec reconstructs the create configs of an existing container. The host
+// name Docker derived from the old container ID and the short ID alias are dropped,
+// so the new container gets its own. If oldImage is set, the image is replaced and the
+// settings the container inherited from oldImage are dropped as well.
+func mapRecreateSpec(old container.InspectResponse, oldImage *image.InspectResponse) *createSpec {
+	shortID := old.ID[:min(len(old.ID), shortIDLength)]
+
+	config := *old.Config
+	config.Env = slices.Clone(config.Env)
+	config.Labels = maps.Clone(config.Labels)
+	config.ExposedPorts = maps.Clone(config.ExposedPorts)
+	config.Volumes = maps.Clone(config.Volumes)
+	if config.Hostname == shortID {
+		config.Hostname = ""
+	}
+
+	hostConfig := *old.HostConfig
+	hostConfig.Mounts = preserveVolumes(hostConfig.Binds, hostConfig.Mounts, old.Mounts)
+
+	if oldImage != nil && oldImage.Config != nil {
+		dropImageDefaults(&config, oldImage)
+		for port := range hostConfig.PortBindings {
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = nat.PortSet{}
+			}
+			config.ExposedPorts[port] = struct{}{}
+		}
+	}
+
+	spec := &createSpec{config: &config, hostConfig: &hostConfig}
+	if old.NetworkSettings != nil && len(old.NetworkSettings.Networks) > 0 {
+		endpoints := make(map[string]*network.EndpointSettings, len(old.NetworkSettings.Networks))
+		for name, e := range old.NetworkSettings.Networks {
+			endpoints[name] = mapRecreateEndpoint(e, shortID)
+		}
+		spec.networking = &network.NetworkingConfig{EndpointsConfig: endpoints}
+	}
+	return spec
+}
+
+// dropImageDefaults removes the settings config inherited from img, the image it was
+// created from: environment variables, labels, exposed ports, and volumes the image
+// defines with the same value, and the entrypoint, command, working directory, user,
+// stop signal, and healthcheck if they equal those of the image.
+func dropImageDefaults(config *container.Config, img *image.InspectResponse) {
+	defaults := img.Config
+	config.Env = slices.DeleteFunc(config.Env, func(e string) bool {
+		return slices.Contains(defaults.Env, e)
+	})
+	maps.DeleteFunc(config.Labels, func(key, value string) bool {
+		v, ok := defaults.Labels[key]
+		return ok && v == value
+	})
+	for port := range defaults.ExposedPorts {
+		delete(config.ExposedPorts, nat.Port(port))
+	}
+	for target := range defaults.Volumes {
+		delete(config.Volumes, target)
+	}
+
+	if slices.Equal(config.Entrypoint, defaults.Entrypoint) {
+		config.Entrypoint = nil
+	}
+	if slices.Equal(config.Cmd, defaults.Cmd) {
+		config.Cmd = nil
+	}
+	if config.WorkingDir == defaults.WorkingDir {
+		config.WorkingDir = ""
+	}
+	if config.User == defaults.User {
+		config.User = ""
+	}
+	if config.StopSignal == defaults.StopSignal {
+		config.StopSignal = ""
+	}
+	if config.Healthcheck != nil && reflect.DeepEqual(config.Healthcheck, defaults.Healthcheck) {
+		config.Healthcheck = nil
+	}
+}
+
+// preserveVolumes adds the volumes a container uses but does not configure by name, such
+// as anonymous volumes declared by its image or its mounts, to its mounts, so the new
+// container keeps their data.
+func preserveVolumes(binds []string, mounts []mount.Mount, points []container.MountPoint) []mount.Mount {
+	targets := make(map[string]struct{}, len(binds)+len(mounts))
+	for _, b := range binds {
+		parts := strings.Split(b, ":")
+		if len(parts) > 1 {
+			targets[path.Clean(parts[1])] = struct{}{}
+		}
+	}
+	res := make([]mount.Mount, 0, len(mounts))
+	for _, m := range mounts {
+		if m.Type == mount.TypeVolume && m.Source == "" {
+			continue
+		}
+		targets[path.Clean(m.Target)] = struct{}{}
+		res = append(res, m)
+	}
+
+	for _, p := range points {
+		if _, ok := targets[path.Clean(p.Destination)]; ok || p.Type != mount.TypeVolume {
+			continue
+		}
+		res = append(res, mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   p.Name,
+			Target:   p.Destination,
+			ReadOnly: !p.RW,
+		})
+	}
+	return res
+}
+
+func mapRecreateEndpoint(e *network.EndpointSettings, shortID string) *network.EndpointSettings {
+	settings := &network.EndpointSettings{}
+	if e == nil {
+		return settings
+	}
+	settings.Links = e.Links
+	settings.DriverOpts = e.DriverOpts
+	settings.MacAddress = e.MacAddress
+	if e.IPAMConfig != nil {
+		ipam := *e.IPAMConfig
+		settings.IPAMConfig = &ipam
+	}
+	for _, alias := range e.Aliases {
+		if alias != shortID {
+			settings.Aliases = append(settings.Aliases, alias)
+		}
+	}
+	return settings
+}
+
+// applyRecreatePatch applies the changes of the request to the reconstructed configs.
+func applyRecreatePatch(spec *createSpec, req *protos.RecreateContainerRequest) error {
+	if req.GetImage() != "" {
+		spec.config.Image = req.GetImage()
+	}
+	if len(req.GetCmd()) > 0 {
+		spec.config.Cmd = req.GetCmd()
+	}
+	spec.config.Env = patchEnv(spec.config.Env, req.GetEnv())
+
+	if len(req.GetLabels()) > 0 && spec.config.Labels == nil {
+		spec.config.Labels = make(map[string]string, len(req.GetLabels()))
+	}
+	maps.Copy(spec.config.Labels, req.GetLabels())
+	for _, key := range req.GetRemoveLabels() {
+		delete(spec.config.Labels, key)
+	}
+
+	if len(req.GetPortBindings()) == 0 {
+		return nil
+	}
+	bindings, err := mapPortBindings(req.GetPortBindings())
+	if err != nil {
+		return err
+	}
+	spec.hostConfig.PortBindings = bindings
+	for port := range bindings {
+		if spec.config.ExposedPorts == nil {
+			spec.config.ExposedPorts = nat.PortSet{}
+		}
+		spec.config.ExposedPorts[port] = struct{}{}
+	}
+	return nil
+}
+
+// patchEnv applies environment changes to env: "NAME=value" sets NAME, replacing its
+// current value, and "NAME" removes it.
+func patchEnv(env, changes []string) []string {
+	for _, change := range changes {
+		name, _, set := strings.Cut(change, "=")
+		env = slices.DeleteFunc(env, func(e string) bool {
+			n, _, _ := strings.Cut(e, "=")
+			return n == name
+		})
+		if set {
+			env = append(env, change)
+		}
+	}
+	return env
+}
+
+// replaceContainer stops the old container and renames it aside, then creates and starts
+// the new one under the old name and waits until it is ready. The steps taken are
+// recorded in r for the rollback.
+func (s *Service) replaceContainer(
+	ctx context.Context,
+	r *recreation,
+	spec *createSpec,
+	req *protos.RecreateContainerRequest,
+) (container.InspectResponse, error) {
+	stopOpts := container.StopOptions{}
+	if req.StopTimeout != nil {
+		timeout := int(req.GetStopTimeout())
+		stopOpts.Timeout = &timeout
+	}
+	if err := s.layer.StopContainer(ctx, r.old.ID, stopOpts); err != nil {
+		return container.InspectResponse{}, status.Errorf(codes.Internal, "cannot stop container: %v", err)
+	}
+	r.stopped = true
+
+	r.aside = fmt.Sprintf("%s-%s-old", r.name, r.old.ID[:min(len(r.old.ID), shortIDLength)])
+	if err := s.layer.RenameContainer(ctx, r.old.ID, r.aside); err != nil {
+		return container.InspectResponse{}, status.Errorf(codes.Internal, "cannot rename container: %v", err)
+	}
+	r.renamed = true
+
+	resp, err := s.layer.CreateContainer(ctx, spec.config, spec.hostConfig, spec.networking, &ocispec.Platform{}, r.name)
+	if err != nil {
+		return container.InspectResponse{}, status.Errorf(codes.Internal, "cannot create container: %v", err)
+	}
+	r.newID = resp.ID
+
+	if err = s.layer.StartContainer(ctx, r.newID, container.StartOptions{}); err != nil {
+		return container.InspectResponse{}, status.Errorf(codes.Internal, "cannot start container: %v", err)
+	}
+
+	timeout := defaultHealthTimeout
+	if req.GetHealthTimeoutSeconds() > 0 {
+		timeout = time.Duration(req.GetHealthTimeoutSeconds()) * time.Second
+	}
+	return s.waitReady(ctx, r.newID, req.GetWaitHealthy(), timeout)
+}
+
+// waitReady waits until the container runs and, if healthy is set and the container has
+// a healthcheck, until it reports healthy. It returns the last container details.
+func (s *Service) waitReady(
+	ctx context.Context,
+	id string,
+	healthy bool,
+	timeout time.Duration,
+) (container.InspectResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		details, err := s.layer.GetContainerDetails(ctx, id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return details, status.Errorf(codes.Unavailable, "new container did not become ready: %v", ctx.Err())
+			}
+			return details, status.Errorf(codes.Internal, "cannot get container details: %v", err)
+		}
+		state := details.State
+		switch {
+		case state == nil:
+			return details, status.Error(codes.Internal, "cannot get container state")
+		case !state.Running:
+			return details, status.Errorf(codes.Unavailable, "new container exited with code %d", state.ExitCode)
+		case !healthy || state.Health == nil || state.Health.Status == container.Healthy:
+			return details, nil
+		case state.Health.Status == container.Unhealthy:
+			return details, status.Error(codes.Unavailable, "new container is unhealthy")
+		}
+
+		select {
+		case <-ctx.Done():
+			return details, status.Errorf(codes.Unavailable, "new container did not become healthy: %v", ctx.Err())
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// rollbackRecreate undoes the recorded steps of a failed recreate: it removes the new
+// container, renames the old one back, and restarts it if it was running. If the new
+// container cannot be removed, it still holds the name, so the old one is restarted
+// under the name it was renamed to. Failures are logged, as the error of the failed
+// step is the one returned to the caller.
+func (s *Service) rollbackRecreate(ctx context.Context, r *recreation) {
+	if !r.stopped {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+
+	nameFree := true
+	if r.newID != "" {
+		if err := s.layer.RemoveContainer(ctx, r.newID, container.RemoveOptions{Force: true}); err != nil {
+			log.Error().Err(err).Str("container", r.newID).Msg("cannot remove container after failed recreate")
+			nameFree = false
+		}
+	}
+	name := r.name
+	switch {
+	case r.renamed && !nameFree:
+		name = r.aside
+		log.Warn().Str("container", r.old.ID).Str("name", name).
+			Msg("Keeping temporary name of container, the new container still holds its name")
+	case r.renamed:
+		if err := s.layer.RenameContainer(ctx, r.old.ID, r.name); err != nil {
+			log.Error().Err(err).Str("container", r.old.ID).Msg("cannot restore container name after failed recreate")
+			name = r.aside
+		}
+	}
+	if r.old.State != nil && r.old.State.Running {
+		if err := s.layer.StartContainer(ctx, r.old.ID, container.StartOptions{}); err != nil {
+			log.Error().Err(err).Str("container", r.old.ID).Msg("cannot restart container after failed recreate")
+			return
+		}
+	}
+	log.Info().Str("container", r.old.ID).Str("name", name).Msg("Restored container after failed recreate")
+}
@@ -0,0 +1,196 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package container
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/whiteo/yadoma/internal/protos"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const oldContainerID = "0123456789abcdef0123456789abcdef"
+
+func TestMapRecreateSpec(t *testing.T) {
+	old := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:   oldContainerID,
+			Name: "/web",
+			HostConfig: &container.HostConfig{
+				Binds:  []string{"/srv/conf:/etc/nginx:ro"},
+				Mounts: []mount.Mount{{Type: mount.TypeVolume, Target: "/cache"}},
+			},
+		},
+		Config: &container.Config{
+			Hostname: "0123456789ab",
+			Image:    "nginx:1.27",
+			Env:      []string{"PATH=/usr/bin"},
+			Labels:   map[string]string{"app": "web"},
+		},
+		Mounts: []container.MountPoint{
+			{Type: mount.TypeBind, Source: "/srv/conf", Destination: "/etc/nginx"},
+			{Type: mount.TypeVolume, Name: "anon-cache", Destination: "/cache", RW: true},
+			{Type: mount.TypeVolume, Name: "anon-data", Destination: "/usr/share/nginx/html"},
+		},
+		NetworkSettings: &container.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+			"backend": {
+				IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "172.20.0.10"},
+				Aliases:    []string{"web", "0123456789ab"},
+				IPAddress:  "172.20.0.10",
+				MacAddress: "02:42:ac:14:00:0a",
+			},
+		}},
+	}
+
+	spec := mapRecreateSpec(old, nil)
+
+	assert.Empty(t, spec.config.Hostname)
+	assert.Equal(t, "nginx:1.27", spec.config.Image)
+	assert.Equal(t, []mount.Mount{
+		{Type: mount.TypeVolume, Source: "anon-cache", Target: "/cache"},
+		{Type: mount.TypeVolume, Source: "anon-data", Target: "/usr/share/nginx/html", ReadOnly: true},
+	}, spec.hostConfig.Mounts)
+	assert.Equal(t, []string{"/srv/conf:/etc/nginx:ro"}, spec.hostConfig.Binds)
+	require.NotNil(t, spec.networking)
+	assert.Equal(t, map[string]*network.EndpointSettings{
+		"backend": {
+			IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "172.20.0.10"},
+			Aliases:    []string{"web"},
+			MacAddress: "02:42:ac:14:00:0a",
+		},
+	}, spec.networking.EndpointsConfig)
+
+	spec.config.Labels["app"] = "changed"
+	assert.Equal(t, "web", old.Config.Labels["app"], "patching the spec must not change the inspected config")
+}
+
+func TestMapRecreateSpecDropsImageDefaults(t *testing.T) {
+	healthcheck := &container.HealthConfig{Test: []string{"CMD", "curl", "-f", "http://localhost/"}}
+	old := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         oldContainerID,
+			Name:       "/web",
+			HostConfig: &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8080"}}}},
+		},
+		Config: &container.Config{
+			Image:        "nginx:1.27",
+			Env:          []string{"PATH=/usr/sbin:/usr/bin", "NGINX_VERSION=1.27.0", "MODE=prod"},
+			Entrypoint:   []string{"/docker-entrypoint.sh"},
+			Cmd:          []string{"nginx", "-g", "daemon off; worker_processes 2;"},
+			WorkingDir:   "/srv",
+			StopSignal:   "SIGQUIT",
+			Healthcheck:  healthcheck,
+			Labels:       map[string]string{"maintainer": "NGINX", "app": "web"},
+			ExposedPorts: nat.PortSet{"80/tcp": {}, "8443/tcp": {}},
+			Volumes:      map[string]struct{}{"/var/cache/nginx": {}},
+		},
+	}
+	oldImage := &image.InspectResponse{Config: &dockerspec.DockerOCIImageConfig{
+		ImageConfig: ocispec.ImageConfig{
+			Env:          []string{"PATH=/usr/sbin:/usr/bin", "NGINX_VERSION=1.27.0"},
+			Entrypoint:   []string{"/docker-entrypoint.sh"},
+			Cmd:          []string{"nginx", "-g", "daemon off;"},
+			WorkingDir:   "/",
+			StopSignal:   "SIGQUIT",
+			Labels:       map[string]string{"maintainer": "NGINX"},
+			ExposedPorts: map[string]struct{}{"80/tcp": {}},
+			Volumes:      map[string]struct{}{"/var/cache/nginx": {}},
+		},
+		DockerOCIImageConfigExt: dockerspec.DockerOCIImageConfigExt{
+			Healthcheck: &dockerspec.HealthcheckConfig{Test: []string{"CMD", "curl", "-f", "http://localhost/"}},
+		},
+	}}
+
+	spec := mapRecreateSpec(old, oldImage)
+
+	assert.Equal(t, []string{"MODE=prod"}, spec.config.Env)
+	assert.Nil(t, spec.config.Entrypoint)
+	assert.Equal(t, []string{"nginx", "-g", "daemon off; worker_processes 2;"}, []string(spec.config.Cmd),
+		"an overridden command is kept")
+	assert.Equal(t, "/srv", spec.config.WorkingDir, "an overridden working directory is kept")
+	assert.Empty(t, spec.config.StopSignal)
+	assert.Nil(t, spec.config.Healthcheck)
+	assert.Equal(t, map[string]string{"app": "web"}, spec.config.Labels)
+	assert.Equal(t, nat.PortSet{"80/tcp": {}, "8443/tcp": {}}, spec.config.ExposedPorts,
+		"published ports stay exposed")
+	assert.Empty(t, spec.config.Volumes)
+
+	assert.Len(t, old.Config.Env, 3, "dropping defaults must not change the inspected config")
+	assert.Contains(t, old.Config.Volumes, "/var/cache/nginx")
+}
+
+func TestApplyRecreatePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *protos.RecreateContainerRequest
+		check    func(*testing.T, *createSpec)
+		errField string
+	}{
+		{
+			name: "image, command, env and labels",
+			req: &protos.RecreateContainerRequest{
+				Image:        "nginx:1.28",
+				Cmd:          []string{"nginx", "-g", "daemon off;"},
+				Env:          []string{"MODE=prod", "DEBUG"},
+				Labels:       map[string]string{"version": "1.28"},
+				RemoveLabels: []string{"canary"},
+			},
+			check: func(t *testing.T, spec *createSpec) {
+				assert.Equal(t, "nginx:1.28", spec.config.Image)
+				assert.Equal(t, []string{"nginx", "-g", "daemon off;"}, []string(spec.config.Cmd))
+				assert.Equal(t, []string{"PATH=/usr/bin", "MODE=prod"}, spec.config.Env)
+				assert.Equal(t, map[string]string{"app": "web", "version": "1.28"}, spec.config.Labels)
+				assert.Equal(t, nat.PortMap{"80/tcp": {{HostPort: "8080"}}}, spec.hostConfig.PortBindings)
+			},
+		},
+		{
+			name: "port bindings replace the current ones",
+			req: &protos.RecreateContainerRequest{PortBindings: map[string]*protos.PortBinding{
+				"https": {HostPorts: []*protos.PortMapping{{HostPort: 8443, ContainerPort: 443}}},
+			}},
+			check: func(t *testing.T, spec *createSpec) {
+				assert.Equal(t, nat.PortMap{"443/tcp": {{HostPort: "8443"}}}, spec.hostConfig.PortBindings)
+				assert.Contains(t, spec.config.ExposedPorts, nat.Port("443/tcp"))
+			},
+		},
+		{
+			name: "invalid port binding",
+			req: &protos.RecreateContainerRequest{PortBindings: map[string]*protos.PortBinding{
+				"web": {HostPorts: []*protos.PortMapping{{HostPort: 80}}},
+			}},
+			errField: "port_bindings[web].host_ports[0].container_port",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &createSpec{
+				config: &container.Config{
+					Env:    []string{"PATH=/usr/bin", "DEBUG=1", "MODE=dev"},
+					Labels: map[string]string{"app": "web", "canary": "true"},
+				},
+				hostConfig: &container.HostConfig{PortBindings: nat.PortMap{"80/tcp": {{HostPort: "8080"}}}},
+			}
+
+			err := applyRecreatePatch(spec, tt.req)
+
+			if tt.errField != "" {
+				require.Error(t, err)
+				assert.True(t, strings.HasPrefix(err.Error(), tt.errField+":"), err.Error())
+				return
+			}
+			require.NoError(t, err)
+			tt.check(t, spec)
+		})
+	}
+}
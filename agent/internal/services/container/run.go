@@ -0,0 +1,238 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rollbackTimeout bounds the removal of a container whose run failed. The removal
+// outlives the caller's context, which may be the reason the run failed.
+const rollbackTimeout = 30 * time.Second
+
+// RunContainer pulls, creates, connects, and starts a container as one operation and
+// streams its progress. Depending on the pull policy, the image is pulled when missing
+// locally (the default), always, or never. Pull progress is streamed per layer in the
+// PULLING phase, followed by CREATING, CONNECTING for each extra network, STARTING,
+// and a final RUNNING message with the container ID and the host ports Docker bound.
+// The container request is validated like in CreateContainer before anything is pulled.
+// If connecting or starting the container fails, it is forcibly removed, along with the
+// volumes created for it, before the error is returned. A started container is kept even
+// if it cannot be inspected or reported afterwards. On failure, it returns a gRPC error (codes.InvalidArgument for an invalid
+// field, codes.NotFound for an image missing under the never policy or a missing volume,
+// codes.Internal for pull, create, connect, and start errors).
+func (s *Service) RunContainer(req *protos.RunContainerRequest, stream protos.ContainerService_RunContainerServer) error {
+	if req.GetContainer() == nil {
+		return status.Error(codes.InvalidArgument, "container is required")
+	}
+	spec, err := mapCreateSpec(req.GetContainer())
+	if err != nil {
+		return err
+	}
+	if err = validateRunNetworks(req); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx := stream.Context()
+	if err = s.ensureImage(ctx, req, stream); err != nil {
+		return err
+	}
+
+	if err = stream.Send(&protos.RunContainerResponse{Phase: protos.RunPhase_RUN_PHASE_CREATING}); err != nil {
+		return err
+	}
+	id, volumes, err := s.createContainer(ctx, req.GetContainer(), spec)
+	if err != nil {
+		return err
+	}
+
+	if err = s.startContainer(ctx, id, req, stream); err != nil {
+		s.rollback(ctx, id, volumes, stream)
+		return err
+	}
+	return s.reportRunning(ctx, id, stream)
+}
+
+// validateRunNetworks validates the extra networks of a run. A network may be joined
+// only once, either on creation or by a later connect.
+func validateRunNetworks(req *protos.RunContainerRequest) error {
+	joined := make(map[string]struct{}, len(req.GetContainer().GetNetworks())+len(req.GetConnectNetworks()))
+	for _, n := range req.GetContainer().GetNetworks() {
+		joined[n] = struct{}{}
+	}
+	for _, e := range req.GetContainer().GetEndpoints() {
+		joined[e.GetNetwork()] = struct{}{}
+	}
+	for i, e := range req.GetConnectNetworks() {
+		if err := validateEndpoint(e); err != nil {
+			return fmt.Errorf("connect_networks[%d].%w", i, err)
+		}
+		if _, ok := joined[e.GetNetwork()]; ok {
+			return fmt.Errorf("connect_networks[%d].network: %q is joined more than once", i, e.GetNetwork())
+		}
+		joined[e.GetNetwork()] = struct{}{}
+	}
+	return nil
+}
+
+// ensureImage makes the image of the run available according to its pull policy.
+func (s *Service) ensureImage(
+	ctx context.Context,
+	req *protos.RunContainerRequest,
+	stream protos.ContainerService_RunContainerServer,
+) error {
+	ref := req.GetContainer().GetImage()
+	switch req.GetPullPolicy() {
+	case protos.PullPolicy_PULL_POLICY_ALWAYS:
+	case protos.PullPolicy_PULL_POLICY_MISSING, protos.PullPolicy_PULL_POLICY_NEVER:
+		_, err := s.layer.GetImageDetails(ctx, ref)
+		switch {
+		case err == nil:
+			return nil
+		case !cerrdefs.IsNotFound(err):
+			return status.Errorf(codes.Internal, "cannot inspect image %s: %v", ref, err)
+		case req.GetPullPolicy() == protos.PullPolicy_PULL_POLICY_NEVER:
+			return status.Errorf(codes.NotFound, "image %s does not exist and the pull policy is never", ref)
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument, "pull_policy: unknown policy %d", req.GetPullPolicy())
+	}
+	return s.pullImage(ctx, ref, req.GetRegistryAuth(), stream)
+}
+
+// pullImage pulls the image and forwards the progress of its layers. An error reported
+// in the pull output fails the pull.
+func (s *Service) pullImage(
+	ctx context.Context,
+	ref, registryAuth string,
+	stream protos.ContainerService_RunContainerServer,
+) error {
+	pullReader, err := s.layer.PullImage(ctx, ref, image.PullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot pull image %s: %v", ref, err)
+	}
+	defer func() {
+		if cErr := pullReader.Close(); cErr != nil {
+			log.Error().Err(cErr).Msg("error closing pull reader")
+		}
+	}()
+
+	var pullErr error
+	err = service.StreamDecoder[jsonmessage.JSONMessage](pullReader, func(msg jsonmessage.JSONMessage) error {
+		if msg.Error != nil {
+			pullErr = msg.Error
+			return pullErr
+		}
+		resp := &protos.RunContainerResponse{
+			Phase:   protos.RunPhase_RUN_PHASE_PULLING,
+			Message: msg.Status,
+			LayerId: msg.ID,
+		}
+		if msg.Progress != nil {
+			resp.ProgressCurrent = msg.Progress.Current
+			resp.ProgressTotal = msg.Progress.Total
+		}
+		return stream.Send(resp)
+	})
+	if pullErr != nil {
+		return status.Errorf(codes.Internal, "cannot pull image %s: %v", ref, pullErr)
+	}
+	return err
+}
+
+// startContainer connects the created container to the extra networks and starts it.
+func (s *Service) startContainer(
+	ctx context.Context,
+	id string,
+	req *protos.RunContainerRequest,
+	stream protos.ContainerService_RunContainerServer,
+) error {
+	for _, e := range req.GetConnectNetworks() {
+		err := stream.Send(&protos.RunContainerResponse{
+			Phase:       protos.RunPhase_RUN_PHASE_CONNECTING,
+			Message:     e.GetNetwork(),
+			ContainerId: id,
+		})
+		if err != nil {
+			return err
+		}
+		if err = s.layer.ConnectNetwork(ctx, e.GetNetwork(), id, mapEndpoint(e)); err != nil {
+			return status.Errorf(codes.Internal, "cannot connect container to network %s: %v", e.GetNetwork(), err)
+		}
+	}
+
+	err := stream.Send(&protos.RunContainerResponse{Phase: protos.RunPhase_RUN_PHASE_STARTING, ContainerId: id})
+	if err != nil {
+		return err
+	}
+	if err = s.layer.StartContainer(ctx, id, container.StartOptions{}); err != nil {
+		return status.Errorf(codes.Internal, "cannot start container: %v", err)
+	}
+	return nil
+}
+
+// reportRunning sends the final message of a run with the host ports Docker bound. The
+// container has started, so it is kept if this fails.
+func (s *Service) reportRunning(
+	ctx context.Context,
+	id string,
+	stream protos.ContainerService_RunContainerServer,
+) error {
+	details, err := s.layer.GetContainerDetails(ctx, id)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot inspect container: %v", err)
+	}
+	resp := &protos.RunContainerResponse{Phase: protos.RunPhase_RUN_PHASE_RUNNING, ContainerId: id}
+	if details.NetworkSettings != nil {
+		resp.Ports = mapPortMap(details.NetworkSettings.Ports)
+	}
+	return stream.Send(resp)
+}
+
+// rollback forcibly removes a container whose run failed and then the volumes created
+// for it, which the forced removal keeps. Failures are logged, as the error of the
+// failed step is the one returned to the caller.
+func (s *Service) rollback(
+	ctx context.Context,
+	id string,
+	volumes []string,
+	stream protos.ContainerService_RunContainerServer,
+) {
+	if err := stream.Send(&protos.RunContainerResponse{
+		Phase:       protos.RunPhase_RUN_PHASE_ROLLING_BACK,
+		ContainerId: id,
+	}); err != nil {
+		log.Debug().Err(err).Str("container", id).Msg("cannot report run rollback")
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	if err := s.layer.RemoveContainer(ctx, id, container.RemoveOptions{Force: true}); err != nil {
+		log.Error().Err(err).Str("container", id).Msg("cannot remove container after failed run")
+		return
+	}
+	log.Info().Str("container", id).Msg("Removed container after failed run")
+	s.removeVolumes(ctx, volumes)
+}
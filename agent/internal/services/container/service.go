@@ -15,10 +15,15 @@ import (
 	"io"
 
 	docker "github.com/whiteo/yadoma/internal/dockers"
+	"github.com/whiteo/yadoma/internal/metrics"
 	"github.com/whiteo/yadoma/internal/protos"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"google.golang.org/grpc"
@@ -29,6 +34,7 @@ type layerAPI interface {
 	GetContainerDetails(ctx context.Context, id string) (container.InspectResponse, error)
 	GetContainerLogs(ctx context.Context, id string, opts container.LogsOptions) (io.ReadCloser, error)
 	GetContainerStats(ctx context.Context, id string, stream bool) (container.StatsResponseReader, error)
+	GetContainerStatsOneShot(ctx context.Context, id string) (container.StatsResponseReader, error)
 	CreateContainer(ctx context.Context,
 		config *container.Config,
 		hostConfig *container.HostConfig,
@@ -44,19 +50,39 @@ type layerAPI interface {
 	UnpauseContainer(ctx context.Context, id string) error
 	KillContainer(ctx context.Context, id, signal string) error
 	RenameContainer(ctx context.Context, id, name string) error
+	UpdateContainer(ctx context.Context, id string, config container.UpdateConfig) (container.UpdateResponse, error)
+	WaitContainer(ctx context.Context,
+		id string,
+		condition container.WaitCondition,
+	) (<-chan container.WaitResponse, <-chan error)
+	CreateExec(ctx context.Context, id string, opts container.ExecOptions) (container.ExecCreateResponse, error)
+	AttachExec(ctx context.Context, execID string, opts container.ExecAttachOptions) (types.HijackedResponse, error)
+	ResizeExec(ctx context.Context, execID string, opts container.ResizeOptions) error
+	InspectExec(ctx context.Context, execID string) (container.ExecInspect, error)
+	AttachContainer(ctx context.Context, id string, opts container.AttachOptions) (types.HijackedResponse, error)
+	ResizeContainer(ctx context.Context, id string, opts container.ResizeOptions) error
+	GetEvents(ctx context.Context, opts events.ListOptions) (<-chan events.Message, <-chan error)
+	GetVolumeDetails(ctx context.Context, id string) (volume.Volume, error)
+	CreateVolume(ctx context.Context, opts volume.CreateOptions) (volume.Volume, error)
+	RemoveVolume(ctx context.Context, id string, force bool) error
+	GetImageDetails(ctx context.Context, id string) (image.InspectResponse, error)
+	PullImage(ctx context.Context, link string, opts image.PullOptions) (io.ReadCloser, error)
+	ConnectNetwork(ctx context.Context, networkID, containerID string, config *network.EndpointSettings) error
 }
 
 type Service struct {
 	protos.UnimplementedContainerServiceServer
-	layer layerAPI
+	layer   layerAPI
+	history *metrics.Store
 }
 
 // NewContainerService constructs a new Service backed by the provided Docker layer.
 // It initializes the service dependency used to perform container operations and
 // returns an instance ready to be registered on a gRPC server via Register.
-// Callers should provide a non-nil layer to avoid runtime failures.
-func NewContainerService(layer *docker.Layer) *Service {
-	return &Service{layer: layer}
+// Callers should provide a non-nil layer to avoid runtime failures. The stats history
+// may be nil, which disables recording and GetStatsHistory.
+func NewContainerService(layer *docker.Layer, history *metrics.Store) *Service {
+	return &Service{layer: layer, history: history}
 }
 
 // Register attaches this service implementation to the provided gRPC server.
@@ -25,6 +25,10 @@ import (
 // Validates that a container ID is provided, then requests stats from the Docker layer,
 // honoring the incoming context for cancellation and closing the response body on exit.
 // Decodes each Docker stats payload and sends it to the client after mapping to protobuf.
+// Besides the raw counters, every response carries values computed the way `docker stats`
+// does: CPU percent from the usage deltas to the previous sample and the online CPUs,
+// memory usage without reclaimable cache (cgroup v1 and v2), block I/O read and write
+// bytes, the PID count, and per-interface network counters.
 // Returns gRPC errors: InvalidArgument for a missing ID, Internal on Docker access failures;
 // supports single-shot or continuous updates based on the request's stream flag.
 func (s *Service) GetContainerStats(
@@ -63,6 +67,7 @@ func (s *Service) GetContainerStats(
 		log.Trace().
 			Str("container", containerID).
 			Uint64("cpu", stats.CPUStats.CPUUsage.TotalUsage).
+			Uint64("pre_cpu", stats.PreCPUStats.CPUUsage.TotalUsage).
 			Uint64("mem", stats.MemoryStats.Usage).
 			Msg("Decoded stats, sending to client")
 		return stream.Send(mapStats(stats))
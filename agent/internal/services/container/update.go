@@ -0,0 +1,102 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/whiteo/yadoma/internal/protos"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	minBlkioWeight = 10
+	maxBlkioWeight = 1000
+)
+
+// UpdateContainer changes the resources and restart policy of a container without
+// recreating it, so a running container can be throttled live.
+// It updates the CPU shares, period, quota, count and cpuset, the memory limit,
+// reservation and swap, the PIDs limit, the block IO weight, and the restart policy.
+// Zero values leave the current setting unchanged; at least one setting must be given.
+// On success, it returns the engine warnings, such as a limit the kernel does not support.
+// On failure, it returns gRPC errors: `InvalidArgument` for a missing ID, no change, or an
+// invalid field, with the message naming the field, and `Internal` for Docker-layer failures.
+func (s *Service) UpdateContainer(
+	ctx context.Context,
+	req *protos.UpdateContainerRequest,
+) (*protos.UpdateContainerResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "container ID is required")
+	}
+	config := mapUpdateConfig(req)
+	if err := validateUpdate(req, config.Resources); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if reflect.ValueOf(config).IsZero() {
+		return nil, status.Error(codes.InvalidArgument, "at least one resource or the restart policy is required")
+	}
+
+	resp, err := s.layer.UpdateContainer(ctx, req.GetId(), config)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot update container: %v", err)
+	}
+	log.Info().Str("container", req.GetId()).Strs("warnings", resp.Warnings).Msg("Updated container")
+
+	return &protos.UpdateContainerResponse{Warnings: resp.Warnings}, nil
+}
+
+// validateUpdate checks the settings of an update request; res are its resources
+// as mapped by mapUpdateConfig.
+func validateUpdate(req *protos.UpdateContainerRequest, res container.Resources) error {
+	if err := validateResources("", res); err != nil {
+		return err
+	}
+	if w := req.GetBlkioWeight(); w != 0 && (w < minBlkioWeight || w > maxBlkioWeight) {
+		return fmt.Errorf("blkio_weight: %d must be between %d and %d", w, minBlkioWeight, maxBlkioWeight)
+	}
+	return validateRestartPolicy(req.GetRestartPolicy())
+}
+
+func mapUpdateConfig(req *protos.UpdateContainerRequest) container.UpdateConfig {
+	config := container.UpdateConfig{
+		Resources: container.Resources{
+			CPUShares:         req.GetCpuShares(),
+			CPUPeriod:         req.GetCpuPeriod(),
+			CPUQuota:          req.GetCpuQuota(),
+			NanoCPUs:          req.GetNanoCpus(),
+			CpusetCpus:        req.GetCpusetCpus(),
+			CpusetMems:        req.GetCpusetMems(),
+			Memory:            req.GetMemory(),
+			MemoryReservation: req.GetMemoryReservation(),
+			MemorySwap:        req.GetMemorySwap(),
+			BlkioWeight:       uint16(req.GetBlkioWeight()),
+		},
+	}
+	if req.PidsLimit != nil {
+		limit := req.GetPidsLimit()
+		config.PidsLimit = &limit
+	}
+	if p := req.GetRestartPolicy(); p != nil {
+		config.RestartPolicy = container.RestartPolicy{
+			Name:              container.RestartPolicyMode(p.GetName()),
+			MaximumRetryCount: int(p.GetMaximumRetryCount()),
+		}
+	}
+	return config
+}
@@ -0,0 +1,189 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package container provides service-layer operations for managing Docker containers.
+// It implements gRPC-facing logic that validates requests, invokes the Docker layer,
+// maps results to protobuf messages, and returns errors as gRPC status codes.
+// Supported operations cover the container lifecycle and inspection, including create,
+// list, inspect, logs and stats streaming, start/stop/restart, kill, pause/unpause,
+// rename, and remove. Calls respect the caller's context; streaming endpoints propagate
+// cancellation and require the caller to consume and close streams. The package is
+// internal to the agent and intended to be used by higher-level gRPC servers.
+package container
+
+import (
+	"context"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// healthWaitPollInterval is how often the health of a container is inspected while
+// waiting for it to become healthy, in case a health event is missed.
+const healthWaitPollInterval = 5 * time.Second
+
+// WaitContainer blocks until a container meets the requested condition and reports how
+// it ended. The conditions not-running (the default), next-exit, and removed wait for the
+// container's exit via Docker; healthy follows the container's health events and inspects
+// its health until the healthcheck passes.
+// An optional timeout bounds the wait in addition to the caller's deadline.
+// On success, it returns the exit code, the error Docker reported on exit, whether the
+// container was killed for running out of memory, its health status, and the result of
+// its last health check; the latter are not available once the container is removed.
+// On failure, it returns gRPC errors: `InvalidArgument` for a missing ID, an unknown
+// condition, or a negative timeout, `NotFound` for an unknown container,
+// `FailedPrecondition` when waiting for health of a container without a healthcheck or
+// one that exited, `DeadlineExceeded` when the timeout passes, and `Internal` for
+// Docker-layer failures.
+func (s *Service) WaitContainer(
+	ctx context.Context,
+	req *protos.WaitContainerRequest,
+) (*protos.WaitContainerResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "container ID is required")
+	}
+	if req.GetTimeoutSeconds() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "timeout_seconds: %d must not be negative", req.GetTimeoutSeconds())
+	}
+
+	if req.GetTimeoutSeconds() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.GetTimeoutSeconds())*time.Second)
+		defer cancel()
+	}
+
+	switch req.GetCondition() {
+	case protos.WaitCondition_WAIT_CONDITION_NOT_RUNNING:
+		return s.waitExit(ctx, req.GetId(), container.WaitConditionNotRunning)
+	case protos.WaitCondition_WAIT_CONDITION_NEXT_EXIT:
+		return s.waitExit(ctx, req.GetId(), container.WaitConditionNextExit)
+	case protos.WaitCondition_WAIT_CONDITION_REMOVED:
+		return s.waitExit(ctx, req.GetId(), container.WaitConditionRemoved)
+	case protos.WaitCondition_WAIT_CONDITION_HEALTHY:
+		return s.waitHealthy(ctx, req.GetId())
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "condition: unknown condition %d", req.GetCondition())
+	}
+}
+
+// waitExit waits for the container to meet an exit condition and, unless it was removed,
+// inspects it for the details of its exit.
+func (s *Service) waitExit(
+	ctx context.Context,
+	id string,
+	condition container.WaitCondition,
+) (*protos.WaitContainerResponse, error) {
+	waits, errs := s.layer.WaitContainer(ctx, id, condition)
+
+	var exit container.WaitResponse
+	select {
+	case exit = <-waits:
+	case err := <-errs:
+		return nil, waitError(ctx, id, err)
+	}
+
+	resp := &protos.WaitContainerResponse{ExitCode: exit.StatusCode}
+	if exit.Error != nil {
+		resp.Error = exit.Error.Message
+	}
+	if condition == container.WaitConditionRemoved {
+		return resp, nil
+	}
+
+	details, err := s.layer.GetContainerDetails(ctx, id)
+	if err != nil {
+		log.Warn().Err(err).Str("container", id).Msg("Cannot inspect container after it exited")
+		return resp, nil
+	}
+	if details.ContainerJSONBase != nil && details.State != nil {
+		resp.OomKilled = details.State.OOMKilled
+		resp.Health, resp.LastHealthCheck = mapHealth(details.State.Health)
+	}
+	return resp, nil
+}
+
+// waitHealthy waits until the container reports healthy. Its health is inspected on each
+// health, die, or destroy event of the container and periodically in case an event is
+// missed; without events, polling alone is used.
+func (s *Service) waitHealthy(ctx context.Context, id string) (*protos.WaitContainerResponse, error) {
+	evCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	msgs, errs := s.layer.GetEvents(evCtx, events.ListOptions{
+		Since: service.FormatUnixNano(time.Now().UnixNano()),
+		Filters: filters.NewArgs(
+			filters.Arg("type", string(events.ContainerEventType)),
+			filters.Arg("container", id),
+			filters.Arg("event", string(events.ActionHealthStatus)),
+			filters.Arg("event", string(events.ActionDie)),
+			filters.Arg("event", string(events.ActionDestroy)),
+		),
+	})
+
+	ticker := time.NewTicker(healthWaitPollInterval)
+	defer ticker.Stop()
+
+	for {
+		details, err := s.layer.GetContainerDetails(ctx, id)
+		if err != nil {
+			return nil, waitError(ctx, id, err)
+		}
+		if resp, done, hErr := healthResult(id, details); done || hErr != nil {
+			return resp, hErr
+		}
+
+		select {
+		case <-msgs:
+		case err = <-errs:
+			if evCtx.Err() == nil {
+				log.Warn().Err(err).Str("container", id).Msg("Cannot follow health events, polling only")
+			}
+			msgs, errs = nil, nil
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// healthResult reports whether an inspected container is healthy, or an error if it
+// cannot become healthy.
+func healthResult(id string, details container.InspectResponse) (*protos.WaitContainerResponse, bool, error) {
+	if details.ContainerJSONBase == nil || details.State == nil {
+		return nil, false, status.Errorf(codes.Internal, "cannot get state of container %s", id)
+	}
+	state := details.State
+	if state.Health == nil || state.Health.Status == container.NoHealthcheck {
+		return nil, false, status.Errorf(codes.FailedPrecondition, "container %s has no healthcheck", id)
+	}
+	if state.Health.Status == container.Healthy {
+		resp := &protos.WaitContainerResponse{OomKilled: state.OOMKilled}
+		resp.Health, resp.LastHealthCheck = mapHealth(state.Health)
+		return resp, true, nil
+	}
+	if !state.Running {
+		return nil, false, status.Errorf(codes.FailedPrecondition,
+			"container %s exited with code %d before becoming healthy", id, state.ExitCode)
+	}
+	return nil, false, nil
+}
+
+// waitError maps an error of waiting for a container to a gRPC status error.
+func waitError(ctx context.Context, id string, err error) error {
+	switch {
+	case ctx.Err() != nil:
+		return status.FromContextError(ctx.Err()).Err()
+	case cerrdefs.IsNotFound(err):
+		return status.Errorf(codes.NotFound, "container %s does not exist", id)
+	default:
+		return status.Errorf(codes.Internal, "cannot wait for container: %v", err)
+	}
+}
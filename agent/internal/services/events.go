@@ -0,0 +1,83 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package service provides shared utilities for the agent's gRPC service layer.
+// It offers helpers to stream bytes and JSON-decoded messages from io.Reader
+// sources to server-side send callbacks, and to resume Docker event subscriptions.
+//
+// Helpers normalize I/O termination (io.EOF is treated as a clean close),
+// propagate context cancellation and deadlines, and avoid spawning goroutines.
+// The package does not manage the lifetime of readers or streams; callers are
+// responsible for closing resources and consuming streams.
+//
+// Intended for internal use by higher-level handlers (e.g., container, image,
+// network, and system).
+package service
+
+import (
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+// Delays between resubscriptions to an interrupted Docker event stream.
+const (
+	EventsRetryMin = 500 * time.Millisecond
+	EventsRetryMax = 10 * time.Second
+)
+
+// EventCursor tracks the resume position of a Docker event stream. Docker's since
+// filter is inclusive, so events sharing the last delivered timestamp are remembered
+// and dropped when they are replayed after a resubscription.
+type EventCursor struct {
+	nano int64
+	seen map[string]struct{}
+}
+
+// NewEventCursor returns a cursor positioned at since, in Unix nanoseconds.
+func NewEventCursor(since int64) *EventCursor {
+	return &EventCursor{nano: since, seen: make(map[string]struct{})}
+}
+
+// Since returns the position to resubscribe from in the form of the Docker API.
+func (c *EventCursor) Since() string {
+	return FormatUnixNano(c.nano)
+}
+
+// Advance records msg and reports whether it has not been delivered yet.
+func (c *EventCursor) Advance(msg events.Message) bool {
+	key := string(msg.Type) + "/" + string(msg.Action) + "/" + msg.Actor.ID
+	if msg.TimeNano < c.nano {
+		return false
+	}
+	if msg.TimeNano > c.nano {
+		c.nano = msg.TimeNano
+		clear(c.seen)
+	} else if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = struct{}{}
+	return true
+}
+
+// Backoff is the delay before resubscribing to an interrupted event stream. It doubles
+// after every attempt up to a maximum and is reset once a subscription delivered events.
+type Backoff struct {
+	low, high, next time.Duration
+}
+
+// NewBackoff returns a backoff that starts at low and grows up to high.
+func NewBackoff(low, high time.Duration) *Backoff {
+	return &Backoff{low: low, high: high, next: low}
+}
+
+// Next returns the delay before the next attempt and doubles the following one.
+func (b *Backoff) Next() time.Duration {
+	d := b.next
+	b.next = min(b.next*2, b.high)
+	return d
+}
+
+// Reset starts the delays from the minimum again.
+func (b *Backoff) Reset() {
+	b.next = b.low
+}
@@ -0,0 +1,42 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventCursor(t *testing.T) {
+	start := func(id string, nano int64) events.Message {
+		return events.Message{
+			Type:     events.ContainerEventType,
+			Action:   events.ActionStart,
+			Actor:    events.Actor{ID: id},
+			TimeNano: nano,
+		}
+	}
+
+	c := NewEventCursor(100)
+	assert.False(t, c.Advance(start("c1", 99)), "events before since are dropped")
+	assert.True(t, c.Advance(start("c1", 100)))
+	assert.True(t, c.Advance(start("c2", 100)), "other events with the same timestamp are delivered")
+	assert.False(t, c.Advance(start("c1", 100)), "a replayed event is dropped")
+	assert.True(t, c.Advance(start("c1", 200)))
+	assert.Equal(t, "0.000000200", c.Since())
+}
+
+func TestBackoff(t *testing.T) {
+	b := NewBackoff(time.Second, 3*time.Second)
+
+	assert.Equal(t, time.Second, b.Next())
+	assert.Equal(t, 2*time.Second, b.Next())
+	assert.Equal(t, 3*time.Second, b.Next())
+	assert.Equal(t, 3*time.Second, b.Next())
+
+	b.Reset()
+	assert.Equal(t, time.Second, b.Next())
+}
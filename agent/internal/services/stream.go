@@ -2,7 +2,7 @@
 
 // Package service provides shared utilities for the agent's gRPC service layer.
 // It offers helpers to stream bytes and JSON-decoded messages from io.Reader
-// sources to server-side send callbacks.
+// sources to server-side send callbacks, and to resume Docker event subscriptions.
 //
 // Helpers normalize I/O termination (io.EOF is treated as a clean close),
 // propagate context cancellation and deadlines, and avoid spawning goroutines.
@@ -36,6 +36,13 @@ func (w *streamWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// NewStreamWriter returns an io.Writer that forwards every Write to send as a single chunk.
+// It lets demultiplexers such as stdcopy.StdCopy write straight into a gRPC stream.
+// The writer has the same semantics as streamWriter.Write and retains no buffers.
+func NewStreamWriter(send func([]byte) error) io.Writer {
+	return &streamWriter{send: send}
+}
+
 // StreamReader streams raw bytes from r to the provided send callback.
 // It copies data using a fixed 1 KiB buffer, invoking send once per chunk.
 // Reaching io.EOF is treated as a clean termination and returns nil.
@@ -66,6 +66,24 @@ func TestStreamWriter(t *testing.T) {
 	}
 }
 
+func TestNewStreamWriter(t *testing.T) {
+	var received [][]byte
+	w := NewStreamWriter(func(p []byte) error {
+		received = append(received, append([]byte(nil), p...))
+		return nil
+	})
+
+	n, err := io.WriteString(w, "frame")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, [][]byte{[]byte("frame")}, received)
+
+	failing := NewStreamWriter(func([]byte) error { return errors.New("send failed") })
+	n, err = failing.Write([]byte("x"))
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+}
+
 func TestStreamReader(t *testing.T) {
 	createSuccessfulSendFunc := func() (func([]byte) error, *[][]byte) {
 		var received [][]byte
@@ -0,0 +1,155 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package system provides the agent's service layer for Docker system operations.
+// It exposes gRPC-facing handlers that validate requests, delegate to the Docker
+// client layer, map results to protobuf messages, and translate errors into gRPC
+// status codes.
+//
+// Supported operations include retrieving daemon/system information, reporting
+// aggregate disk usage across images, containers, volumes, and layer sizes, and
+// streaming engine events. Calls respect the caller's context and deadlines; the
+// event stream runs until the client cancels it or the requested end time passes.
+//
+// The package does not spawn goroutines on behalf of the caller and relies on
+// context cancellation for shutdown. It is intended for internal use by the
+// agent's gRPC server layer.
+package system
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/whiteo/yadoma/internal/protos"
+	service "github.com/whiteo/yadoma/internal/services"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/rs/zerolog/log"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var eventTypes = map[string]events.Type{
+	"container": events.ContainerEventType,
+	"image":     events.ImageEventType,
+	"network":   events.NetworkEventType,
+	"volume":    events.VolumeEventType,
+}
+
+// StreamEvents streams Docker Engine events to the gRPC client as typed Event messages.
+// Events can be filtered by object type (container, image, network, volume), action,
+// and label ("key" or "key=value"); values within one filter are OR-ed and different
+// filters are AND-ed, as in the Docker API. Since and Until are Unix nanoseconds; a zero
+// Since streams events from now on and a zero Until streams until the client cancels.
+// If the Docker event stream breaks, for example on a daemon restart, the service
+// resubscribes with backoff from the timestamp of the last delivered event and skips
+// events already sent, so reconnects are invisible to the client.
+// Returns InvalidArgument for unknown types, empty labels, or Until before Since.
+func (s *Service) StreamEvents(req *protos.StreamEventsRequest, stream protos.SystemService_StreamEventsServer) error {
+	args, err := mapEventFilters(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	until := req.GetUntilNano()
+	if until != 0 && until < req.GetSinceNano() {
+		return status.Error(codes.InvalidArgument, "until must not be before since")
+	}
+
+	since := req.GetSinceNano()
+	if since == 0 {
+		since = time.Now().UnixNano()
+	}
+	cursor := service.NewEventCursor(since)
+
+	ctx := stream.Context()
+	backoff := service.NewBackoff(service.EventsRetryMin, service.EventsRetryMax)
+	for {
+		msgs, errs := s.layer.GetEvents(ctx, events.ListOptions{
+			Since:   cursor.Since(),
+			Until:   service.FormatUnixNano(until),
+			Filters: args,
+		})
+
+		sub, sendErr := forwardEvents(stream, msgs, errs, cursor)
+		if sendErr != nil {
+			return sendErr
+		}
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		if until != 0 && errors.Is(sub.err, io.EOF) && time.Now().UnixNano() >= until {
+			return nil
+		}
+
+		if sub.delivered {
+			backoff.Reset()
+		}
+		retry := backoff.Next()
+		log.Warn().Err(sub.err).Dur("retry_in", retry).Msg("Docker event stream interrupted, resubscribing")
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// subscription describes how one Docker event subscription ended.
+type subscription struct {
+	delivered bool
+	err       error
+}
+
+// forwardEvents sends events from one Docker subscription until it ends.
+// It reports whether any event was delivered and the error that ended the
+// subscription; the returned error is non-nil only if sending to the client failed.
+func forwardEvents(
+	stream protos.SystemService_StreamEventsServer,
+	msgs <-chan events.Message,
+	errs <-chan error,
+	cursor *service.EventCursor,
+) (subscription, error) {
+	var sub subscription
+	for {
+		select {
+		case msg := <-msgs:
+			if !cursor.Advance(msg) {
+				continue
+			}
+			if err := stream.Send(&protos.StreamEventsResponse{Event: mapEvent(msg)}); err != nil {
+				return sub, err
+			}
+			sub.delivered = true
+		case sub.err = <-errs:
+			return sub, nil
+		}
+	}
+}
+
+func mapEventFilters(req *protos.StreamEventsRequest) (filters.Args, error) {
+	args := filters.NewArgs()
+	for _, t := range req.GetTypes() {
+		et, ok := eventTypes[strings.ToLower(t)]
+		if !ok {
+			return args, fmt.Errorf("unsupported event type %q", t)
+		}
+		args.Add("type", string(et))
+	}
+	for _, action := range req.GetActions() {
+		if action != "" {
+			args.Add("event", action)
+		}
+	}
+	for _, label := range req.GetLabels() {
+		if label == "" || strings.HasPrefix(label, "=") {
+			return args, fmt.Errorf("invalid label filter %q", label)
+		}
+		args.Add("label", label)
+	}
+	return args, nil
+}
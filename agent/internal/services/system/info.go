@@ -5,9 +5,10 @@
 // client layer, map results to protobuf messages, and translate errors into gRPC
 // status codes.
 //
-// Supported operations include retrieving daemon/system information and reporting
-// aggregate disk usage across images, containers, volumes, and layer sizes.
-// Calls respect the caller's context and deadlines; streaming endpoints are not used.
+// Supported operations include retrieving daemon/system information, reporting
+// aggregate disk usage across images, containers, volumes, and layer sizes, and
+// streaming engine events. Calls respect the caller's context and deadlines; the
+// event stream runs until the client cancels it or the requested end time passes.
 //
 // The package does not spawn goroutines on behalf of the caller and relies on
 // context cancellation for shutdown. It is intended for internal use by the
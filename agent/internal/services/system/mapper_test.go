@@ -8,6 +8,7 @@ import (
 	"github.com/whiteo/yadoma/internal/protos"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/stretchr/testify/assert"
@@ -248,3 +249,22 @@ func TestMapDiskUsageVolume(t *testing.T) {
 		})
 	}
 }
+
+func TestMapEvent(t *testing.T) {
+	msg := events.Message{
+		Type:     events.NetworkEventType,
+		Action:   events.ActionConnect,
+		Actor:    events.Actor{ID: "net-1", Attributes: map[string]string{"container": "c1", "name": "backend"}},
+		Scope:    "local",
+		TimeNano: 1735689600123456789,
+	}
+
+	assert.Equal(t, &protos.Event{
+		Type:       "network",
+		Action:     "connect",
+		ActorId:    "net-1",
+		Attributes: map[string]string{"container": "c1", "name": "backend"},
+		Scope:      "local",
+		TimeNano:   1735689600123456789,
+	}, mapEvent(msg))
+}
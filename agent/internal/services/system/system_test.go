@@ -3,12 +3,16 @@ package system
 import (
 	"context"
 	"errors"
+	"io"
 	"testing"
+	"time"
 
 	"github.com/whiteo/yadoma/internal/protos"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/system"
 	"github.com/docker/docker/api/types/volume"
@@ -17,6 +21,7 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -34,6 +39,60 @@ func (m *mockLayerAPI) GetDiskUsage(ctx context.Context, opts types.DiskUsageOpt
 	return args.Get(0).(types.DiskUsage), args.Error(1)
 }
 
+func (m *mockLayerAPI) GetEvents(ctx context.Context,
+	opts events.ListOptions,
+) (<-chan events.Message, <-chan error) {
+	args := m.Called(ctx, opts)
+	return args.Get(0).(<-chan events.Message), args.Get(1).(<-chan error)
+}
+
+type mockEventsStream struct {
+	ctx  context.Context
+	sent []*protos.StreamEventsResponse
+}
+
+func (m *mockEventsStream) Send(resp *protos.StreamEventsResponse) error {
+	m.sent = append(m.sent, resp)
+	return nil
+}
+
+func (m *mockEventsStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockEventsStream) SendMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockEventsStream) RecvMsg(msg interface{}) error {
+	return nil
+}
+
+func (m *mockEventsStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockEventsStream) SendHeader(metadata.MD) error {
+	return nil
+}
+
+func (m *mockEventsStream) SetTrailer(metadata.MD) {
+}
+
+// eventFeed returns channels that deliver msgs followed by end, like the Docker client does.
+func eventFeed(end error, msgs ...events.Message) (<-chan events.Message, <-chan error) {
+	mc := make(chan events.Message)
+	ec := make(chan error, 1)
+	go func() {
+		defer close(ec)
+		for _, m := range msgs {
+			mc <- m
+		}
+		ec <- end
+	}()
+	return mc, ec
+}
+
 func grpcCode(err error) codes.Code {
 	if err == nil {
 		return codes.OK
@@ -190,3 +249,114 @@ func TestServiceGetDiskUsage(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceStreamEvents(t *testing.T) {
+	since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	until := since + int64(time.Minute)
+	event := func(offset time.Duration, action events.Action, id string) events.Message {
+		return events.Message{
+			Type:     events.ContainerEventType,
+			Action:   action,
+			Actor:    events.Actor{ID: id, Attributes: map[string]string{"name": id}},
+			Scope:    "local",
+			TimeNano: since + int64(offset),
+		}
+	}
+	start := event(time.Second, events.ActionStart, "c1")
+	create := event(time.Second, events.ActionCreate, "c2")
+	die := event(2*time.Second, events.ActionDie, "c1")
+
+	tests := []struct {
+		name     string
+		req      *protos.StreamEventsRequest
+		setup    func(*mockLayerAPI)
+		code     codes.Code
+		expected []events.Message
+	}{
+		{
+			name: "unsupported type",
+			req:  &protos.StreamEventsRequest{Types: []string{"plugin"}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "empty label",
+			req:  &protos.StreamEventsRequest{Labels: []string{"=x"}},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "until before since",
+			req:  &protos.StreamEventsRequest{SinceNano: until, UntilNano: since},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "filtered events until end time",
+			req: &protos.StreamEventsRequest{
+				Types: []string{"Container"}, Actions: []string{"start"}, Labels: []string{"app=web"},
+				SinceNano: since, UntilNano: until,
+			},
+			setup: func(ml *mockLayerAPI) {
+				msgs, errs := eventFeed(io.EOF, start)
+				ml.On("GetEvents", mock.Anything, events.ListOptions{
+					Since: "1735689600.000000000",
+					Until: "1735689660.000000000",
+					Filters: filters.NewArgs(
+						filters.Arg("type", "container"),
+						filters.Arg("event", "start"),
+						filters.Arg("label", "app=web"),
+					),
+				}).Return(msgs, errs).Once()
+			},
+			code:     codes.OK,
+			expected: []events.Message{start},
+		},
+		{
+			name: "resumes after daemon restart without duplicates",
+			req:  &protos.StreamEventsRequest{SinceNano: since, UntilNano: until},
+			setup: func(ml *mockLayerAPI) {
+				msgs, errs := eventFeed(io.ErrUnexpectedEOF, start)
+				ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+					return o.Since == "1735689600.000000000"
+				})).Return(msgs, errs).Once()
+				msgs, errs = eventFeed(io.EOF, start, create, die)
+				ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+					return o.Since == "1735689601.000000000"
+				})).Return(msgs, errs).Once()
+			},
+			code:     codes.OK,
+			expected: []events.Message{start, create, die},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ml := &mockLayerAPI{}
+			if tt.setup != nil {
+				tt.setup(ml)
+			}
+			stream := &mockEventsStream{ctx: context.Background()}
+
+			err := (&Service{layer: ml}).StreamEvents(tt.req, stream)
+
+			assert.Equal(t, tt.code, grpcCode(err))
+			expected := make([]*protos.StreamEventsResponse, 0, len(tt.expected))
+			for _, msg := range tt.expected {
+				expected = append(expected, &protos.StreamEventsResponse{Event: mapEvent(msg)})
+			}
+			assert.Equal(t, expected, append(make([]*protos.StreamEventsResponse, 0), stream.sent...))
+			ml.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServiceStreamEventsCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ml := &mockLayerAPI{}
+	ml.On("GetEvents", mock.Anything, mock.MatchedBy(func(o events.ListOptions) bool {
+		return o.Since != "" && o.Until == ""
+	})).Return(eventFeed(context.Canceled)).Run(func(mock.Arguments) { cancel() })
+
+	err := (&Service{layer: ml}).StreamEvents(&protos.StreamEventsRequest{}, &mockEventsStream{ctx: ctx})
+
+	assert.Equal(t, codes.Canceled, grpcCode(err))
+	ml.AssertExpectations(t)
+}
@@ -0,0 +1,29 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+// Package service provides shared utilities for the agent's gRPC service layer.
+// It offers helpers to stream bytes and JSON-decoded messages from io.Reader
+// sources to server-side send callbacks, and to resume Docker event subscriptions.
+//
+// Helpers normalize I/O termination (io.EOF is treated as a clean close),
+// propagate context cancellation and deadlines, and avoid spawning goroutines.
+// The package does not manage the lifetime of readers or streams; callers are
+// responsible for closing resources and consuming streams.
+//
+// Intended for internal use by higher-level handlers (e.g., container, image,
+// network, and system).
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// FormatUnixNano renders a Unix timestamp in nanoseconds in the "seconds.nanoseconds"
+// form accepted by the Docker API for since/until parameters.
+// Zero means no bound and is rendered as an empty string.
+func FormatUnixNano(nano int64) string {
+	if nano == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d.%09d", nano/int64(time.Second), nano%int64(time.Second))
+}
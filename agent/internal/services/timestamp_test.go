@@ -0,0 +1,27 @@
+// @author Leo Tanas (<a href="https://github.com/whiteo">github</a>)
+
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatUnixNano(t *testing.T) {
+	tests := []struct {
+		name     string
+		nano     int64
+		expected string
+	}{
+		{name: "zero is unbounded", nano: 0, expected: ""},
+		{name: "whole seconds", nano: 1735689600000000000, expected: "1735689600.000000000"},
+		{name: "nanosecond precision", nano: 1735689600000000042, expected: "1735689600.000000042"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FormatUnixNano(tt.nano))
+		})
+	}
+}